@@ -0,0 +1,109 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+var tcpdumpPath = "tcpdump"
+
+// Run captures the traffic of network interface nic of VM "name" with
+// tcpdump until interrupted. The capture is written to file, or to a pcap in
+// the run dir of the VM if file is empty. nic may be omitted, in which case
+// it's taken as the start of the filter and the first interface is used.
+func Run(cfg *config.Config, name string, nic string, file string, filter []string) error {
+	vm, ok := cfg.VM[name]
+	if !ok {
+		return fmt.Errorf("%s not found in the configuration", name)
+	}
+
+	index := 0
+	if nic != "" {
+		var err error
+		if index, err = strconv.Atoi(nic); err != nil {
+			filter = append([]string{nic}, filter...)
+			index = 0
+		}
+	}
+	if index < 0 || index >= len(vm.Network) {
+		return fmt.Errorf("%s has no network interface %d", name, index)
+	}
+
+	iface, vmFilter, err := Interface(cfg, vm.Network[index])
+	if err != nil {
+		return err
+	}
+
+	if file == "" {
+		file = filepath.Join(vm.RunDir, fmt.Sprintf("capture-%d-%s.pcap", index, time.Now().Format("20060102-150405")))
+	}
+
+	cmd := exec.Command(tcpdumpPath, args(iface, file, vmFilter, filter)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+	if file != "-" {
+		fmt.Printf("Capturing to %s, press Ctrl-C to stop\n", file)
+	}
+
+	// tcpdump gets the interrupt too, and flushes the capture before exiting.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	return cmd.Run()
+}
+
+// Interface returns the host interface carrying the traffic of a VM network
+// interface, and a filter limiting a capture to the VM if other VMs share it.
+// Tap interfaces are captured directly, and vmnet interfaces on the bridge
+// of the network, limited to their MAC.
+func Interface(cfg *config.Config, n *config.NetConf) (string, string, error) {
+	switch n.Driver {
+	case "virtio-tap":
+		if n.Device == "" {
+			return "", "", fmt.Errorf("interface on network %s has no tap device", n.MemberOf)
+		}
+		return n.Device, "", nil
+
+	case "virtio-net":
+		nt, ok := cfg.Network[n.MemberOf]
+		if !ok || nt.Vmnet == nil || nt.Vmnet.Bridge == "" {
+			return "", "", fmt.Errorf("vmnet network %s requires a bridge, eg. bridge100, to capture on", n.MemberOf)
+		}
+		// The bridge carries the traffic of every VM on the network.
+		mac := n.HardwareAddr()
+		if mac == "" {
+			return "", "", fmt.Errorf("the MAC of the interface isn't known yet, start the VM with hkmgr up first, or set mac")
+		}
+		return nt.Vmnet.Bridge, "ether host " + mac, nil
+
+	case "virtio-vpnkit":
+		return "", "", fmt.Errorf("vpnkit interfaces have no host interface to capture on")
+	}
+	return "", "", fmt.Errorf("capturing on %s interfaces is not supported", n.Driver)
+}
+
+// args returns the arguments to tcpdump, combining the filter limiting the
+// capture to the VM with the filter given.
+func args(iface string, file string, vmFilter string, filter []string) []string {
+	args := []string{"-i", iface, "-U", "-w", file}
+	switch {
+	case vmFilter != "" && len(filter) > 0:
+		args = append(args, vmFilter+" and ("+strings.Join(filter, " ")+")")
+	case vmFilter != "":
+		args = append(args, vmFilter)
+	case len(filter) > 0:
+		args = append(args, strings.Join(filter, " "))
+	}
+	return args
+}
@@ -0,0 +1,112 @@
+package capture
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+func TestInterface(t *testing.T) {
+	cfg := &config.Config{
+		Network: config.Network{
+			"net1": config.NetTypes{Vmnet: &config.Vmnet{Bridge: "bridge100"}},
+			"net2": config.NetTypes{Tap: &config.Tap{Bridge: "bridge1"}},
+			"net3": config.NetTypes{Vmnet: &config.Vmnet{}},
+			"net4": config.NetTypes{VPNKit: &config.VPNKit{}},
+		},
+	}
+	tests := []struct {
+		name       string
+		n          *config.NetConf
+		wantIface  string
+		wantFilter string
+		wantErr    bool
+	}{
+		{
+			name:      "tap",
+			n:         &config.NetConf{Driver: "virtio-tap", MemberOf: "net2", Device: "tap1", MAC: "02:00:00:00:00:01"},
+			wantIface: "tap1",
+		},
+		{
+			name:    "tap without device",
+			n:       &config.NetConf{Driver: "virtio-tap", MemberOf: "net2"},
+			wantErr: true,
+		},
+		{
+			name:       "vmnet with mac",
+			n:          &config.NetConf{Driver: "virtio-net", MemberOf: "net1", MAC: "02:00:00:00:00:02"},
+			wantIface:  "bridge100",
+			wantFilter: "ether host 02:00:00:00:00:02",
+		},
+		{
+			name:       "vmnet with derived mac",
+			n:          &config.NetConf{Driver: "virtio-net", MemberOf: "net1", VmnetMAC: "5a:94:ef:e4:0c:ee"},
+			wantIface:  "bridge100",
+			wantFilter: "ether host 5a:94:ef:e4:0c:ee",
+		},
+		{
+			name:    "vmnet without mac",
+			n:       &config.NetConf{Driver: "virtio-net", MemberOf: "net1"},
+			wantErr: true,
+		},
+		{
+			name:    "vmnet without bridge",
+			n:       &config.NetConf{Driver: "virtio-net", MemberOf: "net3"},
+			wantErr: true,
+		},
+		{
+			name:    "vpnkit",
+			n:       &config.NetConf{Driver: "virtio-vpnkit", MemberOf: "net4"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iface, filter, err := Interface(cfg, tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Interface() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if iface != tt.wantIface || filter != tt.wantFilter {
+				t.Errorf("Interface() = %s, %s, want %s, %s", iface, filter, tt.wantIface, tt.wantFilter)
+			}
+		})
+	}
+}
+
+func Test_args(t *testing.T) {
+	tests := []struct {
+		name     string
+		vmFilter string
+		filter   []string
+		want     []string
+	}{
+		{
+			name: "no filter",
+			want: []string{"-i", "bridge100", "-U", "-w", "vm.pcap"},
+		},
+		{
+			name:   "filter",
+			filter: []string{"port", "67", "or", "port", "68"},
+			want:   []string{"-i", "bridge100", "-U", "-w", "vm.pcap", "port 67 or port 68"},
+		},
+		{
+			name:     "vm filter",
+			vmFilter: "ether host 02:00:00:00:00:02",
+			want:     []string{"-i", "bridge100", "-U", "-w", "vm.pcap", "ether host 02:00:00:00:00:02"},
+		},
+		{
+			name:     "vm filter and filter",
+			vmFilter: "ether host 02:00:00:00:00:02",
+			filter:   []string{"port 67 or port 68"},
+			want:     []string{"-i", "bridge100", "-U", "-w", "vm.pcap", "ether host 02:00:00:00:00:02 and (port 67 or port 68)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := args("bridge100", "vm.pcap", tt.vmFilter, tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
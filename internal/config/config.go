@@ -1,14 +1,46 @@
 package config
 
 import (
+	"fmt"
 	"path/filepath"
 )
 
 // Config represents a hkmgr.toml config file
 type Config struct {
-	Network Network `toml:"network"`
-	VM      VM      `toml:"vm"`
-	Path    string  // Path to the loaded configuration
+	Host     *Host    `toml:"host,omitempty" json:"host,omitempty"`
+	Network  Network  `toml:"network,omitempty" json:"network,omitempty"`
+	DNS      *DNS     `toml:"dns,omitempty" json:"dns,omitempty"`
+	Hosts    *Hosts   `toml:"hosts,omitempty" json:"hosts,omitempty"`
+	Template VM       `toml:"template,omitempty" json:"template,omitempty"`
+	VM       VM       `toml:"vm,omitempty" json:"vm,omitempty"`
+	Path     string   `toml:"-" json:"-"` // Path to the loaded configuration
+	Files    []string `toml:"-" json:"-"` // Paths of all loaded configuration files
+
+	// AllocateTaps has Defaults choose free tap devices, as up and validate
+	// do, rather than only reading previous choices.
+	AllocateTaps bool `toml:"-" json:"-"`
+
+	// NoPersist has Defaults generate values like UUIDs, MACs, and addresses
+	// without creating run dirs or storing them, as init does to check a
+	// configuration before writing it.
+	NoPersist bool `toml:"-" json:"-"`
+}
+
+// Load prepares a decoded configuration for use, expanding templates and
+// replicas, setting defaults, and turning relative paths into fully qualified
+// paths. Path must be set first.
+func (c *Config) Load() error {
+	if err := c.ExpandTemplates(); err != nil {
+		return err
+	}
+	if err := c.ExpandReplicas(); err != nil {
+		return err
+	}
+	if err := c.Defaults(); err != nil {
+		return err
+	}
+	c.UpdateRelativePaths()
+	return nil
 }
 
 // UpdateRelativePaths finds relative paths in the config and turns them into
@@ -25,10 +57,67 @@ func (c *Config) UpdateRelativePaths() {
 func (c *Config) Defaults() error {
 	configDir := filepath.Dir(c.Path)
 
+	for name, nt := range c.Network {
+		if nt.VPNKit != nil {
+			nt.VPNKit.defaults(configDir, name)
+		}
+	}
+
 	for name := range c.VM {
-		if err := c.VM[name].defaults(configDir, name); err != nil {
+		if err := c.VM[name].defaults(configDir, name, !c.NoPersist); err != nil {
 			return err
 		}
 	}
+
+	if err := c.allocateTaps(); err != nil {
+		return err
+	}
+
+	if err := c.allocateIPs(); err != nil {
+		return err
+	}
+
+	// VMs on vmnet networks use the host as their gateway, which the kexec
+	// cmdline may refer to.
+	for name, nt := range c.Network {
+		if nt.Vmnet == nil {
+			continue
+		}
+		if err := nt.Vmnet.Discover(); err != nil {
+			return fmt.Errorf("network %s, %v", name, err)
+		}
+		for _, vm := range c.VM {
+			for _, n := range vm.Network {
+				if n.MemberOf == name {
+					n.Gateway = nt.Vmnet.Gateway
+				}
+			}
+		}
+	}
+
+	// VMs on tap networks use the DNS server on the bridge, if there is one.
+	for _, vm := range c.VM {
+		for _, n := range vm.Network {
+			n.DNS = c.dnsServer(n)
+		}
+	}
+
+	for name := range c.VM {
+		if err := c.VM[name].renderCmdline(name, c.Domain()); err != nil {
+			return err
+		}
+	}
+
+	// Interfaces on a vpnkit network connect to its ethernet socket.
+	for _, vm := range c.VM {
+		for _, net := range vm.Network {
+			if net.Driver != "virtio-vpnkit" || net.Device != "" {
+				continue
+			}
+			if nt, ok := c.Network[net.MemberOf]; ok && nt.VPNKit != nil {
+				net.Device = nt.VPNKit.EthernetSocket()
+			}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// DiscoverIPs finds the address of each network interface without a
+// configured IP by matching its MAC against the DHCP leases and ARP table of
+// the host. Found addresses are persisted in the run dir, so the last known
+// address is used once the lease or ARP entry has expired.
+func (v *VMConfig) DiscoverIPs() {
+	for _, n := range v.Network {
+		if n.IP != "" {
+			continue
+		}
+		path := filepath.Join(v.RunDir, n.MemberOf+"_discovered_ip")
+		if mac, err := net.ParseMAC(n.HardwareAddr()); err == nil {
+			if ip, err := network.FindIP(mac); err == nil {
+				n.DiscoveredIP = ip.String()
+				ioutil.WriteFile(path, []byte(n.DiscoveredIP), 0644)
+				continue
+			}
+		}
+		if ip, err := ioutil.ReadFile(path); err == nil {
+			n.DiscoveredIP = strings.TrimSpace(string(ip))
+		}
+	}
+}
+
+// IPs returns the configured or discovered address of each network interface
+// with one.
+func (v *VMConfig) IPs() []string {
+	var ips []string
+	for _, n := range v.Network {
+		if ip := n.address(); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// address returns the configured IP without a prefix length, or else the
+// discovered IP.
+func (n *NetConf) address() string {
+	if n.IP != "" {
+		return strings.SplitN(n.IP, "/", 2)[0]
+	}
+	return n.DiscoveredIP
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+func TestVMConfig_DiscoverIPs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	leases := filepath.Join(dir, "dhcpd_leases")
+	if err := ioutil.WriteFile(leases, []byte("{\n\tname=vm1\n\tip_address=192.168.64.2\n\thw_address=1,5a:94:ef:e4:c:ee\n\tlease=0x5e9a1b2c\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	network.LeasesPath = leases
+	defer func() { network.LeasesPath = "/var/db/dhcpd_leases" }()
+
+	vm := &VMConfig{
+		RunDir: dir,
+		Network: []*NetConf{
+			{Driver: "virtio-tap", MemberOf: "net1", IP: "192.168.99.10/24"},
+			{Driver: "virtio-net", MemberOf: "net2", MAC: "5a:94:ef:e4:0c:ee"},
+		},
+	}
+	vm.DiscoverIPs()
+	if got, want := vm.IPs(), []string{"192.168.99.10", "192.168.64.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("VMConfig.IPs() = %v, want %v", got, want)
+	}
+
+	// The discovered address persists once the lease is gone.
+	os.Remove(leases)
+	vm.Network[1].DiscoveredIP = ""
+	vm.Network[1].MAC = ""
+	vm.DiscoverIPs()
+	if got := vm.Network[1].DiscoveredIP; got != "192.168.64.2" {
+		t.Errorf("VMConfig.DiscoverIPs() persisted = %s, want 192.168.64.2", got)
+	}
+}
+
+func TestVMConfig_DiscoverIPsVmnet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	leases := filepath.Join(dir, "dhcpd_leases")
+	if err := ioutil.WriteFile(leases, []byte("{\n\tname=vm1\n\tip_address=192.168.64.2\n\thw_address=1,5a:94:ef:e4:c:ee\n\tlease=0x5e9a1b2c\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	network.LeasesPath = leases
+	defer func() { network.LeasesPath = "/var/db/dhcpd_leases" }()
+
+	origOutput := vmnetMACOutput
+	defer func() { vmnetMACOutput = origOutput }()
+	vmnetMACOutput = func(string) (string, error) { return "MAC: 5a:94:ef:e4:0c:ee\n", nil }
+
+	// Up derives the MAC of vmnet interfaces, which aren't configured with
+	// one, and records it for later commands.
+	vm := &VMConfig{
+		RunDir:  dir,
+		UUID:    "9445CA7C-F976-456E-9061-B932194D8166",
+		Network: []*NetConf{{Driver: "virtio-net", MemberOf: "net0"}},
+	}
+	if err := vm.deriveVmnetMACs(); err != nil {
+		t.Fatalf("VMConfig.deriveVmnetMACs() error = %v", err)
+	}
+
+	loaded := &VMConfig{
+		RunDir:  dir,
+		UUID:    vm.UUID,
+		Network: []*NetConf{{Driver: "virtio-net", MemberOf: "net0"}},
+	}
+	if err := loaded.defaults(dir, "vm1", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Network[0].HardwareAddr(); got != "5a:94:ef:e4:0c:ee" {
+		t.Errorf("NetConf.HardwareAddr() = %s, want 5a:94:ef:e4:0c:ee", got)
+	}
+	loaded.DiscoverIPs()
+	if got, want := loaded.IPs(), []string{"192.168.64.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("VMConfig.IPs() = %v, want %v", got, want)
+	}
+}
+
+func Test_parseVmnetMAC(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    string
+		wantErr bool
+	}{
+		{name: "mac", out: "MAC: 5a:94:ef:e4:0c:ee\n", want: "5a:94:ef:e4:0c:ee"},
+		{name: "other output", out: "vmnet started\nMAC: 5a:94:ef:e4:0c:ee\n", want: "5a:94:ef:e4:0c:ee"},
+		{name: "no mac", out: "Error: vmnet requires root\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVmnetMAC(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVmnetMAC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("parseVmnetMAC() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// DefaultDNSDomain is the domain of VM names when dns doesn't set one.
+const DefaultDNSDomain = "hkmgr.test"
+
+// DNS configures the DNS server resolving VM names, eg. web.hkmgr.test, which
+// listens on the addresses of the bridges of tap networks.
+type DNS struct {
+	Domain   string   `toml:"domain,omitempty" json:"domain,omitempty"`
+	Port     int      `toml:"port,omitzero" json:"port,omitempty"`
+	Upstream []string `toml:"upstream,omitempty" json:"upstream,omitempty"` // eg. ["1.1.1.1"], the nameservers of the host if empty
+}
+
+func (d *DNS) domain() string {
+	if d.Domain == "" {
+		return DefaultDNSDomain
+	}
+	return strings.ToLower(strings.TrimSuffix(d.Domain, "."))
+}
+
+func (d *DNS) port() int {
+	if d.Port == 0 {
+		return 53
+	}
+	return d.Port
+}
+
+// Domain returns the domain of VM names, or an empty string without dns.
+func (c *Config) Domain() string {
+	if c.DNS == nil {
+		return ""
+	}
+	return c.DNS.domain()
+}
+
+// DNSPidFile is the pid file of the DNS server.
+func (c *Config) DNSPidFile() string {
+	return filepath.Join(filepath.Dir(c.Path), ".run/dns/dns.pid")
+}
+
+// StopDNS stops the DNS server, if running.
+func (c *Config) StopDNS() error {
+	return stopPidFile(c.DNSPidFile())
+}
+
+// DNSServer returns the DNS server of the configuration, or nil without dns.
+// It listens on each address of the bridges of tap networks.
+func (c *Config) DNSServer() (*network.DNSServer, error) {
+	if c.DNS == nil {
+		return nil, nil
+	}
+	if c.DNS.Port < 0 || c.DNS.Port > 65535 {
+		return nil, fmt.Errorf("dns port must be between 1 and 65535: %d", c.DNS.Port)
+	}
+	s := &network.DNSServer{Domain: c.DNS.domain()}
+
+	ips, err := c.dnsIPs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		s.Listen = append(s.Listen, network.DNSListener{
+			Addr:    net.JoinHostPort(ip.addr.String(), strconv.Itoa(c.DNS.port())),
+			Network: ip.network,
+		})
+	}
+
+	for _, u := range c.DNS.Upstream {
+		if ip := net.ParseIP(u); ip != nil {
+			u = net.JoinHostPort(ip.String(), "53")
+		}
+		if _, _, err := net.SplitHostPort(u); err != nil {
+			return nil, fmt.Errorf("dns upstream must be an address with an optional port: %s", u)
+		}
+		s.Upstream = append(s.Upstream, u)
+	}
+	if len(s.Upstream) == 0 {
+		var listen []net.IP
+		for _, ip := range ips {
+			listen = append(listen, ip.addr)
+		}
+		// Without upstream servers only VM names are resolved.
+		s.Upstream, _ = network.Nameservers(listen)
+	}
+	return s, nil
+}
+
+// Resolver returns the resolver configuration sending queries for VM names
+// on the host to the DNS server, or nil without dns.
+func (c *Config) Resolver() (*network.Resolver, error) {
+	if c.DNS == nil {
+		return nil, nil
+	}
+	r := &network.Resolver{Domain: c.DNS.domain(), Port: c.DNS.port()}
+	ips, err := c.dnsIPs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		r.Nameservers = append(r.Nameservers, ip.addr)
+	}
+	return r, nil
+}
+
+type dnsIP struct {
+	addr    net.IP
+	network string
+}
+
+// dnsIPs returns the addresses of the bridges of tap networks, ordered by
+// network name.
+func (c *Config) dnsIPs() ([]dnsIP, error) {
+	var names []string
+	for name, nt := range c.Network {
+		if nt.Tap != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var ips []dnsIP
+	for _, name := range names {
+		for _, ip := range c.Network[name].Tap.IP {
+			addr, err := parseAddr(ip)
+			if err != nil {
+				return nil, fmt.Errorf("network %s, %v", name, err)
+			}
+			ips = append(ips, dnsIP{addr: addr.IP, network: name})
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("dns requires a tap network with an ip to listen on")
+	}
+	return ips, nil
+}
+
+// DNSRecords returns the configured or discovered addresses of the VMs, named
+// <vm>.<domain>. Replicas are also named after the VM they were expanded
+// from, which resolves to the addresses of all of them.
+func (c *Config) DNSRecords() []network.DNSRecord {
+	if c.DNS == nil {
+		return nil
+	}
+	var names []string
+	for name := range c.VM {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var records []network.DNSRecord
+	for _, name := range names {
+		vm := c.VM[name]
+		vmNames := []string{name}
+		if vm.Replica != nil {
+			vmNames = append(vmNames, vm.Replica.Of)
+		}
+		for _, n := range vm.Network {
+			ip := net.ParseIP(n.address())
+			if ip == nil {
+				continue
+			}
+			for _, vmName := range vmNames {
+				records = append(records, network.DNSRecord{
+					Name:    strings.ToLower(vmName) + "." + c.DNS.domain(),
+					Network: n.MemberOf,
+					IP:      ip,
+				})
+			}
+		}
+	}
+	return records
+}
+
+// dnsServer returns the address of the DNS server on the network of n, which
+// is the first address of the bridge of a tap network.
+func (c *Config) dnsServer(n *NetConf) string {
+	if c.DNS == nil {
+		return ""
+	}
+	nt, ok := c.Network[n.MemberOf]
+	if !ok || nt.Tap == nil || len(nt.Tap.IP) == 0 {
+		return ""
+	}
+	addr, err := parseAddr(nt.Tap.IP[0])
+	if err != nil {
+		return ""
+	}
+	return addr.IP.String()
+}
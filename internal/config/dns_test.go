@@ -0,0 +1,108 @@
+package config
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+func TestConfig_DNSServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		dns     *DNS
+		network Network
+		want    *network.DNSServer
+		wantErr bool
+	}{
+		{
+			name:    "not configured",
+			network: Network{"lan": {Tap: &Tap{IP: IPList{"192.168.99.1/24"}}}},
+		},
+		{
+			name: "defaults",
+			dns:  &DNS{Upstream: []string{"1.1.1.1", "10.0.0.1:5353", "2606:4700:4700::1111"}},
+			network: Network{
+				"lan":  {Tap: &Tap{IP: IPList{"192.168.99.1/24", "fd00:99::1/64"}}},
+				"net0": {Vmnet: &Vmnet{}},
+				"net1": {Tap: &Tap{IP: IPList{"192.168.98.1/24"}}},
+			},
+			want: &network.DNSServer{
+				Domain: "hkmgr.test",
+				Listen: []network.DNSListener{
+					{Addr: "192.168.99.1:53", Network: "lan"},
+					{Addr: "[fd00:99::1]:53", Network: "lan"},
+					{Addr: "192.168.98.1:53", Network: "net1"},
+				},
+				Upstream: []string{"1.1.1.1:53", "10.0.0.1:5353", "[2606:4700:4700::1111]:53"},
+			},
+		},
+		{
+			name:    "domain and port",
+			dns:     &DNS{Domain: "Lab.Test.", Port: 5353, Upstream: []string{"1.1.1.1"}},
+			network: Network{"lan": {Tap: &Tap{IP: IPList{"192.168.99.1/24"}}}},
+			want: &network.DNSServer{
+				Domain:   "lab.test",
+				Listen:   []network.DNSListener{{Addr: "192.168.99.1:5353", Network: "lan"}},
+				Upstream: []string{"1.1.1.1:53"},
+			},
+		},
+		{
+			name:    "no tap ip",
+			dns:     &DNS{Upstream: []string{"1.1.1.1"}},
+			network: Network{"lan": {Tap: &Tap{}}},
+			wantErr: true,
+		},
+		{
+			name:    "bad upstream",
+			dns:     &DNS{Upstream: []string{"dns.example.com"}},
+			network: Network{"lan": {Tap: &Tap{IP: IPList{"192.168.99.1/24"}}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{DNS: tt.dns, Network: tt.network}
+			got, err := c.DNSServer()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.DNSServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.DNSServer() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_DNSRecords(t *testing.T) {
+	c := &Config{
+		DNS: &DNS{},
+		VM: VM{
+			"db": {Network: []*NetConf{
+				{MemberOf: "lan", IP: "192.168.99.2/24"},
+				{MemberOf: "net0", DiscoveredIP: "192.168.64.5"},
+				{MemberOf: "net1"},
+			}},
+			"Web-0": {
+				Network: []*NetConf{{MemberOf: "lan", IP: "192.168.99.3/24"}},
+				Replica: &Replica{Of: "Web", Index: 0, Count: 2},
+			},
+			"Web-1": {
+				Network: []*NetConf{{MemberOf: "lan", IP: "192.168.99.4/24"}},
+				Replica: &Replica{Of: "Web", Index: 1, Count: 2},
+			},
+		},
+	}
+	want := []network.DNSRecord{
+		{Name: "web-0.hkmgr.test", Network: "lan", IP: net.ParseIP("192.168.99.3")},
+		{Name: "web.hkmgr.test", Network: "lan", IP: net.ParseIP("192.168.99.3")},
+		{Name: "web-1.hkmgr.test", Network: "lan", IP: net.ParseIP("192.168.99.4")},
+		{Name: "web.hkmgr.test", Network: "lan", IP: net.ParseIP("192.168.99.4")},
+		{Name: "db.hkmgr.test", Network: "lan", IP: net.ParseIP("192.168.99.2")},
+		{Name: "db.hkmgr.test", Network: "net0", IP: net.ParseIP("192.168.64.5")},
+	}
+	if got := c.DNSRecords(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Config.DNSRecords() = %v, want %v", got, want)
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
+	"syscall"
 
 	"github.com/google/uuid"
+	"github.com/mitchellh/go-ps"
 )
 
 func pidFile(path string) (int, error) {
@@ -27,6 +30,26 @@ func pidFile(path string) (int, error) {
 	return pid, nil
 }
 
+// stopPidFile stops the hkmgr process in a pid file with a SIGTERM, if it's
+// running, and removes the pid file.
+func stopPidFile(path string) error {
+	pid, err := pidFile(path)
+	if err != nil {
+		return nil
+	}
+	defer os.Remove(path)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	proc, err := ps.FindProcess(pid)
+	if err != nil || proc == nil || proc.Executable() != filepath.Base(exe) {
+		return nil
+	}
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
 func uuidFile(path string) (uuid.UUID, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return [16]byte{}, fmt.Errorf("uuid file not found")
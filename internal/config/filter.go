@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// Allow allows traffic to a VM on isolated networks from peer VMs, or from
+// the host with "host". Names of VMs with a count refer to all replicas.
+type Allow struct {
+	From  []string `toml:"from" json:"from"`
+	Proto string   `toml:"proto,omitempty" json:"proto,omitempty"` // tcp or udp, any protocol if empty
+	Ports []int    `toml:"ports,omitempty" json:"ports,omitempty"`
+}
+
+// Filters sets the filter of each tap network, which isolates the VMs on
+// the network from each other when isolate is set, except for traffic
+// allowed by the VMs. Networks without isolate get a filter that removes
+// rules left from when they were isolated.
+func (c *Config) Filters() error {
+	for name, nt := range c.Network {
+		if nt.Tap == nil {
+			continue
+		}
+		f, err := c.filter(name, nt.Tap)
+		if err != nil {
+			return fmt.Errorf("network %s, %v", name, err)
+		}
+		nt.Tap.FilterDev = f
+	}
+	return nil
+}
+
+func (c *Config) filter(netName string, tap *Tap) (*network.Filter, error) {
+	f := &network.Filter{Bridge: tap.Bridge, Isolate: tap.Isolate}
+	if !tap.Isolate {
+		return f, nil
+	}
+	if err := tap.Discover(); err != nil {
+		return nil, err
+	}
+	for _, addr := range tap.BridgeDev.Addrs {
+		f.HostIPs = append(f.HostIPs, addr.IP)
+	}
+
+	var vmNames []string
+	for name := range c.VM {
+		vmNames = append(vmNames, name)
+	}
+	sort.Strings(vmNames)
+
+	// Only peers on this network are allowed by it, the VM may also be on
+	// networks the peers aren't.
+	onNet := map[string]bool{}
+	for _, vmName := range vmNames {
+		for _, n := range c.VM[vmName].Network {
+			if n.MemberOf == netName && n.Driver == "virtio-tap" && n.Device != "" {
+				onNet[vmName] = true
+			}
+		}
+	}
+
+	for _, vmName := range vmNames {
+		vm := c.VM[vmName]
+		for _, n := range vm.Network {
+			if n.MemberOf != netName || n.Driver != "virtio-tap" || n.Device == "" {
+				continue
+			}
+			m := network.FilterMember{Name: vmName, Interface: n.Device}
+			if ip := net.ParseIP(n.address()); ip != nil {
+				m.IPs = append(m.IPs, ip)
+			}
+			for _, a := range vm.Allow {
+				allow := network.FilterAllow{Proto: a.Proto, Ports: a.Ports}
+				for _, from := range a.From {
+					if from == network.FilterHost {
+						allow.From = append(allow.From, from)
+						continue
+					}
+					peers, err := c.VM.Select(from)
+					if err != nil {
+						return nil, fmt.Errorf("vm %s allow, %v", vmName, err)
+					}
+					for peer := range peers {
+						if onNet[peer] {
+							allow.From = append(allow.From, peer)
+						}
+					}
+				}
+				sort.Strings(allow.From)
+				m.Allow = append(m.Allow, allow)
+			}
+			f.Members = append(f.Members, m)
+		}
+	}
+
+	// Check the rules compile, eg. that peers have an address.
+	if _, err := f.Rules(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+func TestConfig_Filters(t *testing.T) {
+	tests := []struct {
+		name    string
+		isolate bool
+		vm      VM
+		want    *network.Filter
+		wantErr bool
+	}{
+		{
+			name: "not isolated",
+			vm: VM{
+				"web": {Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap0", IP: "192.168.99.2/24"}}},
+			},
+			want: &network.Filter{Bridge: "bridge1"},
+		},
+		{
+			name:    "isolated",
+			isolate: true,
+			vm: VM{
+				"db": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap1", IP: "192.168.99.3/24"}},
+					Allow:   []*Allow{{From: []string{"web", "host", "other"}, Proto: "tcp", Ports: []int{5432}}},
+				},
+				"web": {Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap0", IP: "192.168.99.2/24"}}},
+				"other": {Network: []*NetConf{
+					{MemberOf: "other", Driver: "virtio-tap", Device: "tap2", IP: "192.168.98.2/24"},
+					{MemberOf: "lan", Driver: "virtio-net"},
+				}},
+			},
+			want: &network.Filter{
+				Bridge:  "bridge1",
+				Isolate: true,
+				HostIPs: []net.IP{net.ParseIP("192.168.99.1").To4()},
+				Members: []network.FilterMember{
+					{
+						Name:      "db",
+						Interface: "tap1",
+						IPs:       []net.IP{net.ParseIP("192.168.99.3")},
+						Allow:     []network.FilterAllow{{From: []string{"host", "web"}, Proto: "tcp", Ports: []int{5432}}},
+					},
+					{Name: "web", Interface: "tap0", IPs: []net.IP{net.ParseIP("192.168.99.2")}},
+				},
+			},
+		},
+		{
+			name:    "replicas",
+			isolate: true,
+			vm: VM{
+				"db": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap0", IP: "192.168.99.2/24"}},
+					Allow:   []*Allow{{From: []string{"web"}}},
+				},
+				"web-0": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap1", IP: "192.168.99.3/24"}},
+					Replica: &Replica{Of: "web", Index: 0},
+				},
+				"web-1": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap2", IP: "192.168.99.4/24"}},
+					Replica: &Replica{Of: "web", Index: 1},
+				},
+			},
+			want: &network.Filter{
+				Bridge:  "bridge1",
+				Isolate: true,
+				HostIPs: []net.IP{net.ParseIP("192.168.99.1").To4()},
+				Members: []network.FilterMember{
+					{
+						Name:      "db",
+						Interface: "tap0",
+						IPs:       []net.IP{net.ParseIP("192.168.99.2")},
+						Allow:     []network.FilterAllow{{From: []string{"web-0", "web-1"}}},
+					},
+					{Name: "web-0", Interface: "tap1", IPs: []net.IP{net.ParseIP("192.168.99.3")}},
+					{Name: "web-1", Interface: "tap2", IPs: []net.IP{net.ParseIP("192.168.99.4")}},
+				},
+			},
+		},
+		{
+			name:    "unknown peer",
+			isolate: true,
+			vm: VM{
+				"db": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap0", IP: "192.168.99.2/24"}},
+					Allow:   []*Allow{{From: []string{"web"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "peer without ip",
+			isolate: true,
+			vm: VM{
+				"db": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap0", IP: "192.168.99.2/24"}},
+					Allow:   []*Allow{{From: []string{"web"}}},
+				},
+				"web": {Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap1"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "ports without proto",
+			isolate: true,
+			vm: VM{
+				"db": {
+					Network: []*NetConf{{MemberOf: "lan", Driver: "virtio-tap", Device: "tap0", IP: "192.168.99.2/24"}},
+					Allow:   []*Allow{{From: []string{"host"}, Ports: []int{22}}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tap := &Tap{Bridge: "bridge1", IP: IPList{"192.168.99.1/24"}, Isolate: tt.isolate}
+			c := &Config{
+				Network: Network{"lan": {Tap: tap}},
+				VM:      tt.vm,
+			}
+			err := c.Filters()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.Filters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(tap.FilterDev, tt.want) {
+				t.Errorf("Config.Filters() = %+v, want %+v", tap.FilterDev, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// Forwards returns the port forwards of a VM. Guests given as only a port
+// target the configured or discovered IP of the first network interface of
+// the VM with one.
+func (v *VMConfig) Forwards() ([]network.Forward, error) {
+	var fwds []network.Forward
+	for _, f := range v.Forward {
+		guest := f.Guest
+		if !strings.Contains(guest, ":") {
+			ip := v.guestIP()
+			if ip == "" {
+				return nil, fmt.Errorf("forward %s requires a network with an ip, or an ip in guest", f.Host)
+			}
+			guest = net.JoinHostPort(ip, guest)
+		}
+		fwd, err := (&Forward{Proto: f.Proto, Host: f.Host, Guest: guest}).parse()
+		if err != nil {
+			return nil, err
+		}
+		fwds = append(fwds, fwd)
+	}
+	return fwds, nil
+}
+
+// guestIP returns the address of the first network interface with a
+// configured or discovered IP.
+func (v *VMConfig) guestIP() string {
+	if ips := v.IPs(); len(ips) > 0 {
+		return ips[0]
+	}
+	return ""
+}
+
+// validateForwards checks the forwards can be parsed. The guest IP isn't
+// required, as it may only be known once the VM is running.
+func (v *VMConfig) validateForwards() error {
+	for _, f := range v.Forward {
+		guest := f.Guest
+		if !strings.Contains(guest, ":") {
+			guest = net.JoinHostPort("0.0.0.0", guest)
+		}
+		if _, err := (&Forward{Proto: f.Proto, Host: f.Host, Guest: guest}).parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForwardPidFile is the pid file of the process serving the forwards of a VM.
+func (v *VMConfig) ForwardPidFile() string {
+	return filepath.Join(v.RunDir, "forward.pid")
+}
+
+// StopForwards stops the process serving the forwards of a VM, if running.
+func (v *VMConfig) StopForwards() error {
+	return stopPidFile(v.ForwardPidFile())
+}
+
+// offsetPort returns host with its port increased by n, eg. for replicas.
+func offsetPort(host string, n int) (string, error) {
+	addr, portTxt := "", host
+	if strings.Contains(host, ":") {
+		var err error
+		if addr, portTxt, err = net.SplitHostPort(host); err != nil {
+			return "", err
+		}
+	}
+	port, err := strconv.Atoi(portTxt)
+	if err != nil {
+		return "", fmt.Errorf("could not parse port: %s", portTxt)
+	}
+	if addr == "" && !strings.Contains(host, ":") {
+		return strconv.Itoa(port + n), nil
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(port+n)), nil
+}
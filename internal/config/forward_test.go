@@ -0,0 +1,82 @@
+package config
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+func TestVMConfig_Forwards(t *testing.T) {
+	tests := []struct {
+		name    string
+		vm      *VMConfig
+		want    []network.Forward
+		wantErr bool
+	}{
+		{
+			name: "guest port uses network ip",
+			vm: &VMConfig{
+				Network: []*NetConf{{Driver: "virtio-net"}, {Driver: "virtio-tap", IP: "192.168.99.10/24"}},
+				Forward: []*Forward{{Host: "8080", Guest: "80"}},
+			},
+			want: []network.Forward{{Proto: "tcp", HostIP: net.ParseIP("127.0.0.1"), HostPort: 8080, GuestIP: net.ParseIP("192.168.99.10"), GuestPort: 80}},
+		},
+		{
+			name: "guest address",
+			vm: &VMConfig{
+				Forward: []*Forward{{Proto: "udp", Host: "0.0.0.0:5353", Guest: "10.0.0.2:53"}},
+			},
+			want: []network.Forward{{Proto: "udp", HostIP: net.ParseIP("0.0.0.0"), HostPort: 5353, GuestIP: net.ParseIP("10.0.0.2"), GuestPort: 53}},
+		},
+		{
+			name: "no network ip",
+			vm: &VMConfig{
+				Network: []*NetConf{{Driver: "virtio-net"}},
+				Forward: []*Forward{{Host: "8080", Guest: "80"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.vm.Forwards()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VMConfig.Forwards() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VMConfig.Forwards() = %#v, want %#v", got, tt.want)
+			}
+			if err := tt.vm.validateForwards(); err != nil {
+				t.Errorf("VMConfig.validateForwards() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_offsetPort(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "8080", want: "8082"},
+		{host: "127.0.0.1:8080", want: "127.0.0.1:8082"},
+		{host: "[::1]:8080", want: "[::1]:8082"},
+		{host: "http", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := offsetPort(tt.host, 2)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("offsetPort() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("offsetPort() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
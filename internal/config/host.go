@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// Host is configuration of the host, applied by up and reverted by down
+// --networks.
+type Host struct {
+	Routes []*Route               `toml:"routes,omitempty" json:"routes,omitempty"`
+	Sysctl map[string]SysctlValue `toml:"sysctl,omitempty" json:"sysctl,omitempty"`
+}
+
+// Route is a route on the host to dest, eg. a network behind a VM, via the
+// bridge of a network or a gateway.
+type Route struct {
+	Dest    string `toml:"dest" json:"dest"`
+	Network string `toml:"network,omitempty" json:"network,omitempty"`
+	Gateway string `toml:"gateway,omitempty" json:"gateway,omitempty"`
+}
+
+// SysctlValue is the value of a sysctl, given as a string, integer or
+// boolean, eg. "net.inet.ip.forwarding" = 1.
+type SysctlValue string
+
+// UnmarshalTOML accepts a string, integer or boolean.
+func (s *SysctlValue) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		*s = SysctlValue(v)
+	case int64:
+		*s = SysctlValue(strconv.FormatInt(v, 10))
+	case bool:
+		if v {
+			*s = "1"
+		} else {
+			*s = "0"
+		}
+	default:
+		return fmt.Errorf("sysctl value must be a string, integer or boolean")
+	}
+	return nil
+}
+
+// HostStateFile records the changes made to the host by up.
+func (c *Config) HostStateFile() string {
+	return filepath.Join(filepath.Dir(c.Path), ".run/host/changes.json")
+}
+
+// HostConfig returns the host configuration to apply. Without a [host] table
+// it has no routes or sysctls, so applying it reverts previous changes.
+func (c *Config) HostConfig() (*network.HostConfig, error) {
+	h := &network.HostConfig{StateFile: c.HostStateFile()}
+	if c.Host == nil {
+		return h, nil
+	}
+
+	for _, r := range c.Host.Routes {
+		route, err := c.route(r)
+		if err != nil {
+			return nil, err
+		}
+		h.Routes = append(h.Routes, route)
+	}
+
+	var names []string
+	for name := range c.Host.Sysctl {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Sysctls = append(h.Sysctls, network.Sysctl{Name: name, Value: string(c.Host.Sysctl[name])})
+	}
+	return h, nil
+}
+
+// route resolves a route via a network to the bridge of the network.
+func (c *Config) route(r *Route) (network.Route, error) {
+	route := network.Route{}
+	dest := r.Dest
+	if _, _, err := net.ParseCIDR(dest); err != nil {
+		ip := net.ParseIP(dest)
+		if ip == nil {
+			return route, fmt.Errorf("route dest must be an address or network, eg. 10.1.0.0/16: %s", r.Dest)
+		}
+		if ip.To4() != nil {
+			dest += "/32"
+		} else {
+			dest += "/128"
+		}
+	}
+	_, route.Dest, _ = net.ParseCIDR(dest)
+
+	switch {
+	case r.Gateway != "" && r.Network != "":
+		return route, fmt.Errorf("route to %s must have either a gateway or a network, not both", r.Dest)
+	case r.Gateway != "":
+		if route.Gateway = net.ParseIP(r.Gateway); route.Gateway == nil {
+			return route, fmt.Errorf("route to %s, could not parse gateway: %s", r.Dest, r.Gateway)
+		}
+	case r.Network != "":
+		nt, ok := c.Network[r.Network]
+		if !ok {
+			return route, fmt.Errorf("route to %s, network %s not found", r.Dest, r.Network)
+		}
+		switch {
+		case nt.Tap != nil && nt.Tap.Bridge != "":
+			route.Interface = nt.Tap.Bridge
+		case nt.Vmnet != nil && nt.Vmnet.Bridge != "":
+			route.Interface = nt.Vmnet.Bridge
+		default:
+			return route, fmt.Errorf("route to %s, network %s has no bridge", r.Dest, r.Network)
+		}
+	default:
+		return route, fmt.Errorf("route to %s requires a gateway or a network", r.Dest)
+	}
+	return route, nil
+}
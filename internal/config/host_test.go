@@ -0,0 +1,103 @@
+package config
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+func TestConfig_HostConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *network.HostConfig
+		wantErr bool
+	}{
+		{
+			name:  "no host",
+			input: ``,
+			want:  &network.HostConfig{StateFile: "/hkmgr/.run/host/changes.json"},
+		},
+		{
+			name: "routes and sysctls",
+			input: `
+[network.net1.tap]
+bridge = "bridge1"
+
+[[host.routes]]
+dest = "10.1.0.0/16"
+network = "net1"
+
+[[host.routes]]
+dest = "10.2.0.1"
+gateway = "192.168.99.2"
+
+[host.sysctl]
+"net.inet.ip.forwarding" = 1
+"net.inet6.ip6.forwarding" = true
+"kern.hostname" = "hkmgr"
+`,
+			want: &network.HostConfig{
+				Routes: []network.Route{
+					{Dest: &net.IPNet{IP: net.IP{10, 1, 0, 0}, Mask: net.CIDRMask(16, 32)}, Interface: "bridge1"},
+					{Dest: &net.IPNet{IP: net.IP{10, 2, 0, 1}, Mask: net.CIDRMask(32, 32)}, Gateway: net.ParseIP("192.168.99.2")},
+				},
+				Sysctls: []network.Sysctl{
+					{Name: "kern.hostname", Value: "hkmgr"},
+					{Name: "net.inet.ip.forwarding", Value: "1"},
+					{Name: "net.inet6.ip6.forwarding", Value: "1"},
+				},
+				StateFile: "/hkmgr/.run/host/changes.json",
+			},
+		},
+		{
+			name: "unknown network",
+			input: `
+[[host.routes]]
+dest = "10.1.0.0/16"
+network = "net1"
+`,
+			wantErr: true,
+		},
+		{
+			name: "gateway and network",
+			input: `
+[network.net1.tap]
+bridge = "bridge1"
+
+[[host.routes]]
+dest = "10.1.0.0/16"
+network = "net1"
+gateway = "192.168.99.2"
+`,
+			wantErr: true,
+		},
+		{
+			name: "no gateway or network",
+			input: `
+[[host.routes]]
+dest = "10.1.0.0/16"
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Path: "/hkmgr/hkmgr.toml"}
+			if _, err := toml.Decode(tt.input, &cfg); err != nil {
+				t.Fatal(err)
+			}
+			got, err := cfg.HostConfig()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Config.HostConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.HostConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
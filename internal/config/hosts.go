@@ -0,0 +1,45 @@
+package config
+
+import (
+	"net"
+	"sort"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// Hosts configures the block of VM names kept in a hosts file by hkmgr hosts
+// sync.
+type Hosts struct {
+	File string `toml:"file,omitempty" json:"file,omitempty"` // /etc/hosts if empty
+	Auto bool   `toml:"auto,omitempty" json:"auto,omitempty"` // Sync on up and down
+}
+
+// HostsFile returns the block of the configuration in the hosts file, mapping
+// the name of each running VM, except those in stopping, to the configured
+// or discovered IP of its first network interface with one.
+func (c *Config) HostsFile(stopping VM) *network.HostsFile {
+	h := &network.HostsFile{ID: c.Path}
+	if c.Hosts != nil {
+		h.Path = c.Hosts.File
+	}
+
+	var names []string
+	for name := range c.VM {
+		if _, ok := stopping[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vm := c.VM[name]
+		if vm.Status() != Running {
+			continue
+		}
+		vm.DiscoverIPs()
+		if ip := net.ParseIP(vm.guestIP()); ip != nil {
+			h.Entries = append(h.Entries, network.HostsEntry{IP: ip, Name: name})
+		}
+	}
+	return h
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// argField is a field of a comma separated hyperkit argument.
+type argField struct {
+	Name  string // Config key of the field, used in errors
+	Value string
+}
+
+// validate reports values that would corrupt a comma separated hyperkit
+// argument. When last is true the field is the remainder of the argument, so
+// commas are allowed.
+func (f argField) validate(device string, last bool) error {
+	if !last && strings.Contains(f.Value, ",") {
+		return fmt.Errorf("%s %s %q cannot contain a comma", device, f.Name, f.Value)
+	}
+	if strings.IndexFunc(f.Value, func(r rune) bool { return r < 0x20 || r == 0x7f }) != -1 {
+		return fmt.Errorf("%s %s %q cannot contain control characters", device, f.Name, f.Value)
+	}
+	return nil
+}
+
+// PCIDevice is a hyperkit PCI device, rendered as the argument of -s, eg.
+// 2:0,virtio-tap,tap0,mac=aa:bb:cc:dd:00:01.
+type PCIDevice struct {
+	Name      string // Name of the device in the configuration, eg. "network 0"
+	Slot      PCISlot
+	Emulation string
+	Config    []argField
+}
+
+// Arg renders the argument of -s.
+func (d PCIDevice) Arg() string {
+	fields := []string{d.Slot.String(), d.Emulation}
+	for _, f := range d.Config {
+		fields = append(fields, f.Value)
+	}
+	return strings.Join(fields, ",")
+}
+
+// Validate reports values hyperkit can't represent in the argument.
+func (d PCIDevice) Validate() error {
+	if d.Emulation == "" {
+		return fmt.Errorf("%s driver not specified", d.Name)
+	}
+	if err := (argField{Name: "driver", Value: d.Emulation}).validate(d.Name, false); err != nil {
+		return err
+	}
+	for _, f := range d.Config {
+		if err := f.validate(d.Name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LPCDevice is a hyperkit LPC device, rendered as the argument of -l, eg.
+// com1,autopty=<run_dir>/tty,log=<run_dir>/console.log.
+type LPCDevice struct {
+	Name   string // Name of the device, eg. com1
+	Config []argField
+}
+
+// Arg renders the argument of -l.
+func (d LPCDevice) Arg() string {
+	fields := []string{d.Name}
+	for _, f := range d.Config {
+		fields = append(fields, f.Value)
+	}
+	return strings.Join(fields, ",")
+}
+
+// Validate reports values hyperkit can't represent in the argument.
+func (d LPCDevice) Validate() error {
+	for _, f := range d.Config {
+		if err := f.validate(d.Name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BootOption is a hyperkit boot method, rendered as the argument of -f, eg.
+// kexec,<kernel>,<initrd>,<cmdline>. hyperkit splits the argument into the
+// method and three fields, where the last field is the remainder of the
+// argument and may contain commas.
+type BootOption struct {
+	Method string
+	Fields [3]argField
+}
+
+// Arg renders the argument of -f.
+func (b BootOption) Arg() string {
+	return strings.Join([]string{b.Method, b.Fields[0].Value, b.Fields[1].Value, b.Fields[2].Value}, ",")
+}
+
+// Validate reports values hyperkit can't represent in the argument.
+func (b BootOption) Validate() error {
+	for i, f := range b.Fields {
+		if err := f.validate("boot."+b.Method, i == len(b.Fields)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellJoin joins args for display, quoting those a shell would split or
+// interpret, so the printed command can be run as is.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
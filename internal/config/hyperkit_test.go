@@ -0,0 +1,81 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPCIDevice_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		dev     PCIDevice
+		wantErr string
+	}{
+		{
+			name: "valid",
+			dev:  (&HDD{Driver: "virtio-blk", Path: "/Users/me/VirtualBox VMs/hdd.img"}).device("hdd 0", PCISlot{3, 0}),
+		},
+		{
+			name:    "comma in path",
+			dev:     (&HDD{Driver: "virtio-blk", Path: "/vms/a,b.img"}).device("hdd 0", PCISlot{3, 0}),
+			wantErr: `hdd 0 path "/vms/a,b.img" cannot contain a comma`,
+		},
+		{
+			name:    "comma in device",
+			dev:     (&NetConf{Driver: "virtio-tap", Device: "tap0,mac=x"}).device("network 1", PCISlot{2, 0}, "", 1),
+			wantErr: `network 1 device "tap0,mac=x" cannot contain a comma`,
+		},
+		{
+			name:    "newline in path",
+			dev:     (&CDROM{Driver: "ahci-cd", Path: "/vms/disk\n.iso"}).device("cdrom 0", PCISlot{4, 0}),
+			wantErr: `cdrom 0 path "/vms/disk\n.iso" cannot contain control characters`,
+		},
+		{
+			name:    "missing driver",
+			dev:     (&CDROM{Path: "/vms/disk.iso"}).device("cdrom 0", PCISlot{4, 0}),
+			wantErr: "cdrom 0 driver not specified",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.dev.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("PCIDevice.Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("PCIDevice.Validate() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBootOption(t *testing.T) {
+	kexec := (&Kexec{Kernel: "/vms/vmlinuz", Initrd: "/vms/initrd", Cmdline: "console=ttyS0,115200 quiet"}).option()
+	if err := kexec.Validate(); err != nil {
+		t.Errorf("BootOption.Validate() error = %v", err)
+	}
+	if want := "kexec,/vms/vmlinuz,/vms/initrd,console=ttyS0,115200 quiet"; kexec.Arg() != want {
+		t.Errorf("BootOption.Arg() = %s, want %s", kexec.Arg(), want)
+	}
+
+	kexec = (&Kexec{Kernel: "/vms/a,b/vmlinuz", Initrd: "/vms/initrd"}).option()
+	if err := kexec.Validate(); err == nil || !strings.Contains(err.Error(), "boot.kexec kernel") {
+		t.Errorf("BootOption.Validate() error = %v, want an error naming boot.kexec kernel", err)
+	}
+
+	firmware := (&Firmware{Path: "/vms/UEFI.fd"}).option()
+	if want := "bootrom,/vms/UEFI.fd,,"; firmware.Arg() != want {
+		t.Errorf("BootOption.Arg() = %s, want %s", firmware.Arg(), want)
+	}
+}
+
+func Test_shellJoin(t *testing.T) {
+	got := shellJoin([]string{"-s", "2:0,virtio-net,,", "-f", "kexec,/vms/vmlinuz,/vms/initrd,console=ttyS0 it's", ""})
+	want := `-s 2:0,virtio-net,, -f 'kexec,/vms/vmlinuz,/vms/initrd,console=ttyS0 it'\''s' ''`
+	if got != want {
+		t.Errorf("shellJoin() = %s, want %s", got, want)
+	}
+}
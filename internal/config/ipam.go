@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// allocateIPs assigns addresses from the subnet of the first IPv4 bridge
+// address of tap networks, or of the first address of IPv6 only bridges, to
+// VM interfaces on them without an IP. An allocation is persisted as
+// <memberOf>_ip in the run dir of the VM, next to the generated MAC, and kept
+// on later runs unless another interface has since been configured with the
+// address. Configured addresses used by more than one interface are reported.
+func (c *Config) allocateIPs() error {
+	var vmNames []string
+	for name := range c.VM {
+		vmNames = append(vmNames, name)
+	}
+	sort.Strings(vmNames)
+
+	var netNames []string
+	for name, nt := range c.Network {
+		if nt.Tap != nil && len(nt.Tap.IP) > 0 {
+			netNames = append(netNames, name)
+		}
+	}
+	sort.Strings(netNames)
+
+	for _, netName := range netNames {
+		tap := c.Network[netName].Tap
+		if err := tap.Discover(); err != nil {
+			return err
+		}
+		first := tap.BridgeDev.Addrs[0]
+		for _, addr := range tap.BridgeDev.Addrs {
+			if addr.IP.To4() != nil {
+				first = addr
+				break
+			}
+		}
+		subnet := &net.IPNet{IP: first.IP.Mask(first.Mask), Mask: first.Mask}
+		prefix, _ := subnet.Mask.Size()
+
+		used := map[string]string{}
+		for _, addr := range tap.BridgeDev.Addrs {
+			used[addr.IP.String()] = "bridge " + tap.Bridge
+		}
+		var unset []*NetConf
+		var unsetRunDirs []string
+
+		for _, vmName := range vmNames {
+			vm := c.VM[vmName]
+			for _, n := range vm.Network {
+				if n.MemberOf != netName || n.Driver != "virtio-tap" {
+					continue
+				}
+				if n.IP == "" {
+					unset = append(unset, n)
+					unsetRunDirs = append(unsetRunDirs, vm.RunDir)
+					continue
+				}
+				ip := net.ParseIP(n.address())
+				if ip == nil {
+					return fmt.Errorf("vm %s: could not parse IP address: %s", vmName, n.IP)
+				}
+				if owner, ok := used[ip.String()]; ok {
+					return fmt.Errorf("vm %s: ip %s on network %s is already used by %s", vmName, ip, netName, owner)
+				}
+				used[ip.String()] = "vm " + vmName
+			}
+		}
+
+		// Keep previous allocations which are still free, then allocate the
+		// rest.
+		var pending []int
+		for i, n := range unset {
+			ip := allocatedIP(filepath.Join(unsetRunDirs[i], netName+"_ip"))
+			if ip == nil || !subnet.Contains(ip) || used[ip.String()] != "" {
+				pending = append(pending, i)
+				continue
+			}
+			used[ip.String()] = "allocation"
+			n.IP = fmt.Sprintf("%s/%d", ip, prefix)
+		}
+		for _, i := range pending {
+			ip, err := nextFreeIP(subnet, used)
+			if err != nil {
+				return fmt.Errorf("network %s: %v", netName, err)
+			}
+			used[ip.String()] = "allocation"
+			unset[i].IP = fmt.Sprintf("%s/%d", ip, prefix)
+			if c.NoPersist {
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(unsetRunDirs[i], netName+"_ip"), []byte(unset[i].IP), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// allocatedIP reads a persisted allocation, returning nil if there isn't one.
+func allocatedIP(path string) net.IP {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	ip, _, err := net.ParseCIDR(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil
+	}
+	return ip
+}
+
+// nextFreeIP returns the lowest address in subnet which isn't used, skipping
+// the network and broadcast addresses.
+func nextFreeIP(subnet *net.IPNet, used map[string]string) (net.IP, error) {
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("subnet %s is too small to allocate addresses from", subnet)
+	}
+	for ip := ipAdd(subnet.IP, 1); subnet.Contains(ip); ip = ipAdd(ip, 1) {
+		if bits == 32 && !subnet.Contains(ipAdd(ip, 1)) {
+			break // broadcast
+		}
+		if used[ip.String()] == "" {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no free addresses left in subnet %s", subnet)
+}
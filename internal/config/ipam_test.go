@@ -0,0 +1,115 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig_allocateIPs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// vm3 was allocated .10 on a previous run.
+	if err := os.MkdirAll(filepath.Join(dir, ".run/vm/vm3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".run/vm/vm3/net1_ip"), []byte("192.168.99.10/24"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tapNIC := func(device, ip string) []*NetConf {
+		return []*NetConf{{Driver: "virtio-tap", Device: device, MemberOf: "net1", IP: ip}}
+	}
+	cfg := &Config{
+		Path:    filepath.Join(dir, "hkmgr.toml"),
+		Network: Network{"net1": NetTypes{Tap: &Tap{Bridge: "bridge1", IP: IPList{"192.168.99.1/24"}}}},
+		VM: VM{
+			"vm1": &VMConfig{Network: tapNIC("tap0", "192.168.99.2/24")},
+			"vm2": &VMConfig{Network: tapNIC("tap1", ""), Boot: Boot{Kexec: &Kexec{Cmdline: "ip={{(index .Network 0).IP}}"}}},
+			"vm3": &VMConfig{Network: tapNIC("tap2", "")},
+		},
+	}
+	if err := cfg.Defaults(); err != nil {
+		t.Fatalf("Config.Defaults() error = %v", err)
+	}
+
+	for name, want := range map[string]string{"vm1": "192.168.99.2/24", "vm2": "192.168.99.3/24", "vm3": "192.168.99.10/24"} {
+		if got := cfg.VM[name].Network[0].IP; got != want {
+			t.Errorf("Config.allocateIPs() %s ip = %s, want %s", name, got, want)
+		}
+	}
+	if got, want := cfg.VM["vm2"].Boot.Kexec.Cmdline, "ip=192.168.99.3/24"; got != want {
+		t.Errorf("Config.Defaults() cmdline = %s, want %s", got, want)
+	}
+	if got := allocatedIP(filepath.Join(dir, ".run/vm/vm2/net1_ip")); !got.Equal(net.ParseIP("192.168.99.3")) {
+		t.Errorf("Config.allocateIPs() persisted %s, want 192.168.99.3", got)
+	}
+
+	// A configured address conflicting with the bridge is reported.
+	cfg.VM["vm3"].Network[0].IP = "192.168.99.1/24"
+	if err := cfg.allocateIPs(); err == nil {
+		t.Errorf("Config.allocateIPs() error = nil, want conflict with bridge")
+	}
+}
+
+func TestConfig_allocateIPsIPv4First(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Addresses are allocated from the IPv4 subnet, even when an IPv6
+	// address is listed first.
+	cfg := &Config{
+		Path:    filepath.Join(dir, "hkmgr.toml"),
+		Network: Network{"net1": NetTypes{Tap: &Tap{Bridge: "bridge1", IP: IPList{"fd00::1/64", "192.168.99.1/24"}}}},
+		VM: VM{
+			"vm1": &VMConfig{RunDir: dir, Network: []*NetConf{{Driver: "virtio-tap", Device: "tap0", MemberOf: "net1"}}},
+		},
+	}
+	if err := cfg.allocateIPs(); err != nil {
+		t.Fatalf("Config.allocateIPs() error = %v", err)
+	}
+	if got, want := cfg.VM["vm1"].Network[0].IP, "192.168.99.2/24"; got != want {
+		t.Errorf("Config.allocateIPs() ip = %s, want %s", got, want)
+	}
+}
+
+func Test_nextFreeIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		used    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "first", subnet: "10.0.0.0/24", used: []string{"10.0.0.1"}, want: "10.0.0.2"},
+		{name: "skips broadcast", subnet: "10.0.0.0/30", used: []string{"10.0.0.1", "10.0.0.2"}, wantErr: true},
+		{name: "too small", subnet: "10.0.0.0/31", wantErr: true},
+		{name: "ipv6", subnet: "fd00::/64", used: []string{"fd00::1"}, want: "fd00::2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			used := map[string]string{}
+			for _, u := range tt.used {
+				used[net.ParseIP(u).String()] = "test"
+			}
+			got, err := nextFreeIP(subnet, used)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("nextFreeIP() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("nextFreeIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"fmt"
 	"net"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bensallen/hkmgr/internal/network"
 )
@@ -12,9 +15,9 @@ import (
 type Network map[string]NetTypes
 
 type NetTypes struct {
-	Vmnet  *Vmnet  `toml:"vmnet"`
-	Tap    *Tap    `toml:"tap"`
-	VPNKit *VPNKit `toml:"vpnkit"`
+	Vmnet  *Vmnet  `toml:"vmnet" json:"vmnet,omitempty"`
+	Tap    *Tap    `toml:"tap" json:"tap,omitempty"`
+	VPNKit *VPNKit `toml:"vpnkit" json:"vpnkit,omitempty"`
 }
 
 //NetType asdf
@@ -38,15 +41,54 @@ type NetType interface {
 }
 
 type Vmnet struct {
-	Bridge string `toml:"bridge"`
-	IP     string `toml:"ip"`
+	Bridge   string         `toml:"bridge,omitempty" json:"bridge,omitempty"`
+	IP       string         `toml:"ip,omitempty" json:"ip,omitempty"` // Address of the host on the shared network, eg. 192.168.64.1/24
+	Gateway  string         `toml:"-" json:"-"`                       // Effective address of the host, set by Discover
+	VmnetDev *network.Vmnet `toml:"-" json:"-"`
 }
 
+// Discover reads the effective address of the host on the shared network,
+// which is the gateway of VMs on it, from the vmnet plist. A configured ip
+// that differs is only used by macOS once up has written it to the plist and
+// all VMs on the shared network have stopped, which is warned about.
 func (v *Vmnet) Discover() error {
+	if v.VmnetDev == nil {
+		if err := v.toVmnet(); err != nil {
+			return err
+		}
+	}
+	gw, err := v.VmnetDev.Gateway()
+	if err != nil {
+		return err
+	}
+	if v.Gateway == "" && v.VmnetDev.Addr != nil && !v.VmnetDev.Addr.IP.Equal(gw.IP) {
+		fmt.Printf("warning: vmnet gateway is %s, the configured ip %s is used once written by up and all VMs on the shared network have stopped\n", gw.IP, v.VmnetDev.Addr.IP)
+	}
+	v.Gateway = gw.IP.String()
 	return nil
 }
 
+// Up configures the address of the shared network in the vmnet plist.
 func (v *Vmnet) Up() error {
+	if err := v.Discover(); err != nil {
+		return err
+	}
+	return v.VmnetDev.Up()
+}
+
+func (v *Vmnet) toVmnet() error {
+	dev := network.Vmnet{}
+	if v.IP != "" {
+		addr, err := parseAddr(v.IP)
+		if err != nil {
+			return err
+		}
+		if addr.IP.To4() == nil {
+			return fmt.Errorf("vmnet ip must be an IPv4 address: %s", v.IP)
+		}
+		dev.Addr = addr
+	}
+	v.VmnetDev = &dev
 	return nil
 }
 
@@ -55,13 +97,20 @@ func (v *Vmnet) Destroy() error {
 }
 
 type Tap struct {
-	Nat       bool     `toml:"nat"`
-	DHCP      bool     `toml:"dhcp"`
-	Bridge    string   `toml:"bridge"`
-	IP        string   `toml:"ip"`
-	NatIf     string   `toml:"nat_if"`
-	PfRules   []string `toml:"pf_rules"`
-	BridgeDev *network.Bridge
+	Nat           bool            `toml:"nat,omitempty" json:"nat,omitempty"`
+	DHCP          bool            `toml:"dhcp,omitempty" json:"dhcp,omitempty"`
+	Bridge        string          `toml:"bridge" json:"bridge"`
+	IP            IPList          `toml:"ip,omitempty" json:"ip,omitempty"`
+	PruneIPs      bool            `toml:"prune_ips,omitempty" json:"prune_ips,omitempty"`
+	NatIf         string          `toml:"nat_if,omitempty" json:"nat_if,omitempty"`
+	PfRules       []string        `toml:"pf_rules,omitempty" json:"pf_rules,omitempty"`
+	MemberTimeout string          `toml:"member_timeout,omitempty" json:"member_timeout,omitempty"` // eg. "30s", defaults to 10s
+	MTU           int             `toml:"mtu,omitzero" json:"mtu,omitempty"`
+	STP           *bool           `toml:"stp,omitempty" json:"stp,omitempty"`
+	MemberFlags   map[string]bool `toml:"member_flags,omitempty" json:"member_flags,omitempty"` // eg. { private = true, learning = false }
+	Isolate       bool            `toml:"isolate,omitempty" json:"isolate,omitempty"`
+	BridgeDev     *network.Bridge `toml:"-" json:"-"`
+	FilterDev     *network.Filter `toml:"-" json:"-"` // Set by Config.Filters
 }
 
 func (t *Tap) Discover() error {
@@ -79,32 +128,57 @@ func (t *Tap) Up() error {
 		return err
 	}
 
-	return t.BridgeDev.Up()
+	// The filter is applied to the members that were added, even if others
+	// couldn't be.
+	err := t.BridgeDev.Up()
+	if _, ok := err.(*network.MembersError); err != nil && !ok {
+		return err
+	}
+	if t.FilterDev != nil {
+		if err := t.FilterDev.Up(); err != nil {
+			return err
+		}
+	}
+	return err
 }
 
 func (t *Tap) toBridge() error {
-	bridge := network.Bridge{}
-	if t.IP != "" {
-		var ip net.IP
-		var mask []byte
-		if strings.Contains(t.IP, "/") {
-			var err error
-			var cidr *net.IPNet
-			ip, cidr, err = net.ParseCIDR(t.IP)
-			if err != nil {
-				return err
-			}
-			mask = cidr.Mask
-		} else {
-			ip = net.ParseIP(t.IP)
-			if ip == nil {
-				return fmt.Errorf("could not parse IP address: %s", t.IP)
-			}
-			mask = ip.DefaultMask()
+	bridge := network.Bridge{Device: t.Bridge, PruneAddrs: t.PruneIPs}
+	for _, ip := range t.IP {
+		addr, err := parseAddr(ip)
+		if err != nil {
+			return err
+		}
+		bridge.Addrs = append(bridge.Addrs, addr)
+	}
+
+	if t.MemberTimeout != "" {
+		timeout, err := time.ParseDuration(t.MemberTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("member_timeout must be a positive duration, eg. 30s: %s", t.MemberTimeout)
 		}
-		bridge = network.Bridge{Device: t.Bridge, IP: ip, Netmask: mask}
-	} else {
-		bridge = network.Bridge{Device: t.Bridge}
+		bridge.MemberTimeout = timeout
+	}
+
+	if t.MTU != 0 && (t.MTU < 576 || t.MTU > 65535) {
+		return fmt.Errorf("mtu must be between 576 and 65535: %d", t.MTU)
+	}
+	bridge.MTU = t.MTU
+
+	for name, set := range t.MemberFlags {
+		if !network.IsMemberFlag(name) {
+			return fmt.Errorf("member flag %s not supported: learning, discover, stp, edge, autoedge, ptp, autoptp, and private are supported", name)
+		}
+		if bridge.MemberFlags == nil {
+			bridge.MemberFlags = map[string]bool{}
+		}
+		bridge.MemberFlags[name] = set
+	}
+	if t.STP != nil {
+		if bridge.MemberFlags == nil {
+			bridge.MemberFlags = map[string]bool{}
+		}
+		bridge.MemberFlags["stp"] = *t.STP
 	}
 
 	t.BridgeDev = &bridge
@@ -116,21 +190,181 @@ func (t *Tap) Destroy() error {
 	return nil
 }
 
+// IPList is a list of addresses, given as a single string or an array of
+// strings, eg. "192.168.99.1/24" or ["192.168.99.1/24", "fd00:99::1/64"].
+type IPList []string
+
+// UnmarshalTOML accepts a string or an array of strings.
+func (l *IPList) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		*l = IPList{v}
+	case []interface{}:
+		list := IPList{}
+		for _, ip := range v {
+			s, ok := ip.(string)
+			if !ok {
+				return fmt.Errorf("ip must be a string or an array of strings")
+			}
+			list = append(list, s)
+		}
+		*l = list
+	default:
+		return fmt.Errorf("ip must be a string or an array of strings")
+	}
+	return nil
+}
+
+// parseAddr parses an IPv4 or IPv6 address with a prefix length, eg.
+// 192.168.99.1/24 or fd00:99::1/64. IPv4 addresses without one use the mask
+// of their class, IPv6 addresses require one.
+func parseAddr(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		ip, cidr, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return &net.IPNet{IP: ip, Mask: cidr.Mask}, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("could not parse IP address: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: ip4.DefaultMask()}, nil
+	}
+	return nil, fmt.Errorf("IPv6 address %s requires a prefix length, eg. %s/64", s, s)
+}
+
+// VPNKit is a NAT network provided by a vpnkit process, which hkmgr starts
+// with its sockets in the run dir of the network.
 type VPNKit struct {
+	RunDir    string          `toml:"run_dir,omitempty" json:"run_dir,omitempty"`
+	Forward   []*Forward      `toml:"forward,omitempty" json:"forward,omitempty"`
+	VPNKitDev *network.VPNKit `toml:"-" json:"-"`
+}
+
+// Forward is a port forward from a host port to a guest port, eg. host
+// "127.0.0.1:8080" to guest "192.168.65.2:80". The host address defaults to
+// 127.0.0.1 when only a port is given.
+type Forward struct {
+	Proto string `toml:"proto,omitempty" json:"proto,omitempty"`
+	Host  string `toml:"host" json:"host"`
+	Guest string `toml:"guest" json:"guest"`
 }
 
 func (v *VPNKit) Discover() error {
+	if v.VPNKitDev == nil {
+		return v.toVPNKit()
+	}
 	return nil
 }
 
 func (v *VPNKit) Up() error {
-	return nil
+	if err := v.Discover(); err != nil {
+		return err
+	}
+
+	return v.VPNKitDev.Up()
 }
 
+// Destroy stops the supervisor, so it doesn't start vpnkit again, and then
+// vpnkit.
 func (v *VPNKit) Destroy() error {
+	if err := v.Discover(); err != nil {
+		return err
+	}
+	if err := v.StopSupervisor(); err != nil {
+		return err
+	}
+
+	return v.VPNKitDev.Destroy()
+}
+
+// SupervisorPidFile is the pid file of the process keeping vpnkit running.
+func (v *VPNKit) SupervisorPidFile() string {
+	return filepath.Join(v.RunDir, "supervisor.pid")
+}
+
+// StopSupervisor stops the process keeping vpnkit running, if running, which
+// leaves vpnkit running.
+func (v *VPNKit) StopSupervisor() error {
+	return stopPidFile(v.SupervisorPidFile())
+}
+
+func (v *VPNKit) toVPNKit() error {
+	dev := network.VPNKit{RunDir: v.RunDir}
+	for _, f := range v.Forward {
+		fwd, err := f.parse()
+		if err != nil {
+			return err
+		}
+		dev.Forwards = append(dev.Forwards, fwd)
+	}
+	v.VPNKitDev = &dev
 	return nil
 }
 
+// EthernetSocket is the socket VM network interfaces of the network connect to.
+func (v *VPNKit) EthernetSocket() string {
+	return (&network.VPNKit{RunDir: v.RunDir}).EthernetSocket()
+}
+
+// defaults sets the run dir, relative to the config, before VM network
+// interfaces are pointed at the ethernet socket in it.
+func (v *VPNKit) defaults(configDir string, name string) {
+	if v.RunDir == "" {
+		v.RunDir = filepath.Join(configDir, ".run/network", name)
+	} else if v.RunDir[:1] != "/" {
+		v.RunDir = filepath.Join(configDir, v.RunDir)
+	}
+}
+
+func (f *Forward) parse() (network.Forward, error) {
+	fwd := network.Forward{Proto: f.Proto}
+	switch f.Proto {
+	case "":
+		fwd.Proto = "tcp"
+	case "tcp", "udp":
+	default:
+		return fwd, fmt.Errorf("forward %s proto %s not supported: tcp and udp are supported", f.Host, f.Proto)
+	}
+
+	host := f.Host
+	if !strings.Contains(host, ":") {
+		host = "127.0.0.1:" + host
+	}
+	var err error
+	if fwd.HostIP, fwd.HostPort, err = splitHostPort(host); err != nil {
+		return fwd, fmt.Errorf("forward host %s, %v", f.Host, err)
+	}
+	if fwd.GuestIP, fwd.GuestPort, err = splitHostPort(f.Guest); err != nil {
+		return fwd, fmt.Errorf("forward guest %s, %v", f.Guest, err)
+	}
+	return fwd, nil
+}
+
+// splitHostPort parses an IP and port, eg. 127.0.0.1:8080 or [::1]:8080.
+func splitHostPort(addr string) (net.IP, int, error) {
+	host, portTxt, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("could not parse IP address: %s", host)
+	}
+	port, err := strconv.Atoi(portTxt)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, 0, fmt.Errorf("port must be between 1 and 65535: %s", portTxt)
+	}
+	return ip, port, nil
+}
+
 // genMAC creates a random 6 byte hardware address, eg. MAC address.
 // The address generated has the locally administered bit set and
 // is a unicast address.
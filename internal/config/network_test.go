@@ -1,22 +1,32 @@
 package config
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/bensallen/hkmgr/internal/network"
 )
 
 func TestTap_toBridge(t *testing.T) {
+	stp := true
 	type fields struct {
-		Nat       bool
-		DHCP      bool
-		Bridge    string
-		IP        string
-		NatIf     string
-		PfRules   []string
-		BridgeDev *network.Bridge
+		Nat         bool
+		DHCP        bool
+		Bridge      string
+		IP          IPList
+		PruneIPs    bool
+		NatIf       string
+		PfRules     []string
+		MTU         int
+		STP         *bool
+		MemberFlags map[string]bool
+		BridgeDev   *network.Bridge
 	}
 	tests := []struct {
 		name    string
@@ -35,29 +45,79 @@ func TestTap_toBridge(t *testing.T) {
 			name: "bridge0 192.168.0.1",
 			fields: fields{
 				Bridge: "bridge0",
-				IP:     "192.168.0.1",
+				IP:     IPList{"192.168.0.1"},
 			},
-			want: &network.Bridge{Device: "bridge0", IP: net.ParseIP("192.168.0.1"), Netmask: net.IPMask{255, 255, 255, 0}},
+			want: &network.Bridge{Device: "bridge0", Addrs: []*net.IPNet{{IP: net.ParseIP("192.168.0.1").To4(), Mask: net.IPMask{255, 255, 255, 0}}}},
 		},
 		{
 			name: "bridge0 10.0.0.1/16",
 			fields: fields{
 				Bridge: "bridge0",
-				IP:     "10.0.0.1/16",
+				IP:     IPList{"10.0.0.1/16"},
 			},
-			want: &network.Bridge{Device: "bridge0", IP: net.ParseIP("10.0.0.1"), Netmask: net.IPMask{255, 255, 0, 0}},
+			want: &network.Bridge{Device: "bridge0", Addrs: []*net.IPNet{{IP: net.ParseIP("10.0.0.1").To4(), Mask: net.IPMask{255, 255, 0, 0}}}},
+		},
+		{
+			name: "bridge1 ipv4 and ipv6, pruned",
+			fields: fields{
+				Bridge:   "bridge1",
+				IP:       IPList{"192.168.99.1/24", "fd00:99::1/64"},
+				PruneIPs: true,
+			},
+			want: &network.Bridge{Device: "bridge1", PruneAddrs: true, Addrs: []*net.IPNet{
+				{IP: net.ParseIP("192.168.99.1").To4(), Mask: net.CIDRMask(24, 32)},
+				{IP: net.ParseIP("fd00:99::1"), Mask: net.CIDRMask(64, 128)},
+			}},
+		},
+		{
+			name: "ipv6 without prefix",
+			fields: fields{
+				Bridge: "bridge1",
+				IP:     IPList{"fd00:99::1"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "jumbo frames, stp and private members",
+			fields: fields{
+				Bridge:      "bridge2",
+				MTU:         9000,
+				STP:         &stp,
+				MemberFlags: map[string]bool{"private": true, "learning": false},
+			},
+			want: &network.Bridge{Device: "bridge2", MTU: 9000, MemberFlags: map[string]bool{"stp": true, "private": true, "learning": false}},
+		},
+		{
+			name: "mtu too small",
+			fields: fields{
+				Bridge: "bridge2",
+				MTU:    100,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown member flag",
+			fields: fields{
+				Bridge:      "bridge2",
+				MemberFlags: map[string]bool{"sticky": true},
+			},
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tap := &Tap{
-				Bridge:    tt.fields.Bridge,
-				IP:        tt.fields.IP,
-				Nat:       tt.fields.Nat,
-				NatIf:     tt.fields.NatIf,
-				PfRules:   tt.fields.PfRules,
-				DHCP:      tt.fields.DHCP,
-				BridgeDev: tt.fields.BridgeDev,
+				Bridge:      tt.fields.Bridge,
+				IP:          tt.fields.IP,
+				PruneIPs:    tt.fields.PruneIPs,
+				Nat:         tt.fields.Nat,
+				NatIf:       tt.fields.NatIf,
+				PfRules:     tt.fields.PfRules,
+				DHCP:        tt.fields.DHCP,
+				MTU:         tt.fields.MTU,
+				STP:         tt.fields.STP,
+				MemberFlags: tt.fields.MemberFlags,
+				BridgeDev:   tt.fields.BridgeDev,
 			}
 			err := tap.toBridge()
 			if (err != nil) != tt.wantErr {
@@ -70,3 +130,211 @@ func TestTap_toBridge(t *testing.T) {
 		})
 	}
 }
+
+func TestVPNKit_toVPNKit(t *testing.T) {
+	tests := []struct {
+		name    string
+		forward []*Forward
+		want    []network.Forward
+		wantErr bool
+	}{
+		{
+			name:    "port only",
+			forward: []*Forward{{Host: "8080", Guest: "192.168.65.2:80"}},
+			want:    []network.Forward{{Proto: "tcp", HostIP: net.ParseIP("127.0.0.1"), HostPort: 8080, GuestIP: net.ParseIP("192.168.65.2"), GuestPort: 80}},
+		},
+		{
+			name:    "udp ipv6",
+			forward: []*Forward{{Proto: "udp", Host: "[::1]:5353", Guest: "192.168.65.2:53"}},
+			want:    []network.Forward{{Proto: "udp", HostIP: net.ParseIP("::1"), HostPort: 5353, GuestIP: net.ParseIP("192.168.65.2"), GuestPort: 53}},
+		},
+		{
+			name:    "bad proto",
+			forward: []*Forward{{Proto: "sctp", Host: "8080", Guest: "192.168.65.2:80"}},
+			wantErr: true,
+		},
+		{
+			name:    "guest without ip",
+			forward: []*Forward{{Host: "8080", Guest: "80"}},
+			wantErr: true,
+		},
+		{
+			name:    "port out of range",
+			forward: []*Forward{{Host: "70000", Guest: "192.168.65.2:80"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &VPNKit{RunDir: "/vms/.run/network/net1", Forward: tt.forward}
+			err := v.toVPNKit()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VPNKit.toVPNKit() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			want := &network.VPNKit{RunDir: v.RunDir, Forwards: tt.want}
+			if !reflect.DeepEqual(v.VPNKitDev, want) {
+				t.Errorf("VPNKit.toVPNKit() = %#v, want %#v", v.VPNKitDev, want)
+			}
+		})
+	}
+}
+
+func TestConfig_DefaultsVPNKit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{
+		Path:    filepath.Join(dir, "hkmgr.toml"),
+		Network: Network{"net1": NetTypes{VPNKit: &VPNKit{}}},
+		VM: VM{"vm1": &VMConfig{
+			UUID:    "8ba1ad92-9f3d-4c6b-a4fd-f3b7d8a3a2e4",
+			Network: []*NetConf{{Driver: "virtio-vpnkit", MemberOf: "net1"}, {Driver: "virtio-vpnkit", MemberOf: "net1"}},
+		}},
+	}
+	if err := cfg.Defaults(); err != nil {
+		t.Fatalf("Config.Defaults() error = %v", err)
+	}
+
+	sock := filepath.Join(dir, ".run/network/net1/vpnkit.eth.sock")
+	if got := cfg.VM["vm1"].Network[0].Device; got != sock {
+		t.Errorf("Config.Defaults() device = %s, want %s", got, sock)
+	}
+
+	want := "2:0,virtio-vpnkit,path=" + sock + ",uuid=d6059fc0-f87e-5c9d-802f-99a293cef8a8"
+	if got := cfg.VM["vm1"].Network[0].device("network 0", PCISlot{2, 0}, cfg.VM["vm1"].UUID, 0).Arg(); got != want {
+		t.Errorf("NetConf.device() = %s, want %s", got, want)
+	}
+	// Each interface on the network gets its own MAC and IP address.
+	want = "2:1,virtio-vpnkit,path=" + sock + ",uuid=24d99a0b-af3f-5030-88b9-3f48db877be6"
+	if got := cfg.VM["vm1"].Network[1].device("network 1", PCISlot{2, 1}, cfg.VM["vm1"].UUID, 1).Arg(); got != want {
+		t.Errorf("NetConf.device() = %s, want %s", got, want)
+	}
+}
+
+func TestConfig_DefaultsVmnet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origPlist := network.VmnetPlist
+	defer func() { network.VmnetPlist = origPlist }()
+	network.VmnetPlist = filepath.Join(dir, "com.apple.vmnet.plist")
+
+	// The gateway is the address in the plist, which up sets to the
+	// configured ip.
+	tests := []struct {
+		name  string
+		ip    string
+		plist string
+		want  string
+	}{
+		{name: "default", want: "gw=192.168.64.1"},
+		{name: "configured", ip: "192.168.99.1/24", want: "gw=192.168.64.1"},
+		{name: "configured and written", ip: "192.168.99.1/24", plist: "192.168.99.1/24", want: "gw=192.168.99.1"},
+		{name: "written", plist: "192.168.98.1/24", want: "gw=192.168.98.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(network.VmnetPlist)
+			if tt.plist != "" {
+				addr, err := parseAddr(tt.plist)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := (&network.Vmnet{Addr: addr}).Up(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := &Config{
+				Path:    filepath.Join(dir, "hkmgr.toml"),
+				Network: Network{"net1": NetTypes{Vmnet: &Vmnet{IP: tt.ip}}},
+				VM: VM{"vm1": &VMConfig{
+					UUID:    "8ba1ad92-9f3d-4c6b-a4fd-f3b7d8a3a2e4",
+					Boot:    Boot{Kexec: &Kexec{Cmdline: "gw={{(index .Network 0).Gateway}}"}},
+					Network: []*NetConf{{Driver: "virtio-net", MemberOf: "net1"}},
+				}},
+			}
+			if err := cfg.Defaults(); err != nil {
+				t.Fatalf("Config.Defaults() error = %v", err)
+			}
+			if got := cfg.VM["vm1"].Boot.Kexec.Cmdline; got != tt.want {
+				t.Errorf("Config.Defaults() cmdline = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTap_toBridgeMemberTimeout(t *testing.T) {
+	tap := &Tap{Bridge: "bridge1", MemberTimeout: "30s"}
+	if err := tap.toBridge(); err != nil {
+		t.Fatalf("Tap.toBridge() error = %v", err)
+	}
+	if tap.BridgeDev.MemberTimeout != 30*time.Second {
+		t.Errorf("Tap.toBridge() member timeout = %s, want 30s", tap.BridgeDev.MemberTimeout)
+	}
+
+	for _, timeout := range []string{"30", "-1s"} {
+		tap := &Tap{Bridge: "bridge1", MemberTimeout: timeout}
+		if err := tap.toBridge(); err == nil {
+			t.Errorf("Tap.toBridge() member_timeout %s error = nil, want error", timeout)
+		}
+	}
+}
+
+func TestIPList_UnmarshalTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    IPList
+		wantErr bool
+	}{
+		{name: "string", input: `ip = "192.168.99.1/24"`, want: IPList{"192.168.99.1/24"}},
+		{name: "array", input: `ip = ["192.168.99.1/24", "fd00:99::1/64"]`, want: IPList{"192.168.99.1/24", "fd00:99::1/64"}},
+		{name: "number", input: `ip = 1`, wantErr: true},
+		{name: "array of numbers", input: `ip = [1]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tap Tap
+			_, err := toml.Decode(tt.input, &tap)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IPList.UnmarshalTOML() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tap.IP, tt.want) {
+				t.Errorf("IPList.UnmarshalTOML() = %v, want %v", tap.IP, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "192.168.99.2", want: true},
+		{ip: "192.168.99.2/24", want: true},
+		{ip: "fd00:99::2", want: true},
+		{ip: "fd00:99::2/64", want: true},
+		{ip: "192.168.99.256", want: false},
+		{ip: "fd00:99::2/129", want: false},
+		{ip: "vm1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := validIP(tt.ip); got != tt.want {
+				t.Errorf("validIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
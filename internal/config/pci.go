@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PCI bus limits of hyperkit.
+const (
+	maxPCISlot     = 31
+	maxPCIFunction = 7
+)
+
+// reservedPCISlots are used by the devices hkmgr always adds: the hostbridge,
+// virtio-rnd, and lpc.
+var reservedPCISlots = map[int]string{
+	0:  "hostbridge",
+	1:  "virtio-rnd",
+	31: "lpc",
+}
+
+// PCISlot is a hyperkit PCI slot and function.
+type PCISlot struct {
+	Slot     int
+	Function int
+}
+
+// ParsePCISlot parses a PCI slot in the form "slot:function", eg. "5:0", or
+// "slot" for function 0.
+func ParsePCISlot(s string) (PCISlot, error) {
+	slotStr, funcStr := s, "0"
+	if i := strings.Index(s, ":"); i != -1 {
+		slotStr, funcStr = s[:i], s[i+1:]
+	}
+
+	slot, err := strconv.Atoi(slotStr)
+	if err != nil || slot < 0 || slot > maxPCISlot {
+		return PCISlot{}, fmt.Errorf("invalid pci slot %q: slot must be between 0 and %d", s, maxPCISlot)
+	}
+	function, err := strconv.Atoi(funcStr)
+	if err != nil || function < 0 || function > maxPCIFunction {
+		return PCISlot{}, fmt.Errorf("invalid pci slot %q: function must be between 0 and %d", s, maxPCIFunction)
+	}
+	return PCISlot{Slot: slot, Function: function}, nil
+}
+
+func (p PCISlot) String() string {
+	return fmt.Sprintf("%d:%d", p.Slot, p.Function)
+}
+
+// slotRequest is a request for the PCI slot of a device.
+type slotRequest struct {
+	name string // Name used in errors, eg. "network 0"
+	slot string // Requested slot, or empty to allocate one
+}
+
+// allocatePCISlots assigns a PCI slot to each device, returned in the same
+// order as devs. Requested slots are used as is, while the remaining devices
+// are spread across free slots at function 0, and once no free slots remain,
+// across the free functions of slots in use.
+func allocatePCISlots(devs []slotRequest) ([]PCISlot, error) {
+	slots := make([]PCISlot, len(devs))
+	used := map[PCISlot]string{}
+	var auto []int
+
+	for i, dev := range devs {
+		if dev.slot == "" {
+			auto = append(auto, i)
+			continue
+		}
+		slot, err := ParsePCISlot(dev.slot)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", dev.name, err)
+		}
+		if reserved, ok := reservedPCISlots[slot.Slot]; ok {
+			return nil, fmt.Errorf("%s: pci slot %d is reserved for %s", dev.name, slot.Slot, reserved)
+		}
+		if other, ok := used[slot]; ok {
+			return nil, fmt.Errorf("%s: pci slot %s is already used by %s", dev.name, slot, other)
+		}
+		used[slot] = dev.name
+		slots[i] = slot
+	}
+
+	slotUsed := func(s int) bool {
+		for f := 0; f <= maxPCIFunction; f++ {
+			if _, ok := used[PCISlot{s, f}]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	next := 0
+	assign := func(slot PCISlot) {
+		used[slot] = devs[auto[next]].name
+		slots[auto[next]] = slot
+		next++
+	}
+
+	for s := 0; s <= maxPCISlot && next < len(auto); s++ {
+		if _, ok := reservedPCISlots[s]; ok || slotUsed(s) {
+			continue
+		}
+		assign(PCISlot{s, 0})
+	}
+	for s := 0; s <= maxPCISlot && next < len(auto); s++ {
+		if _, ok := reservedPCISlots[s]; ok {
+			continue
+		}
+		for f := 0; f <= maxPCIFunction && next < len(auto); f++ {
+			if _, ok := used[PCISlot{s, f}]; !ok {
+				assign(PCISlot{s, f})
+			}
+		}
+	}
+	if next < len(auto) {
+		return nil, fmt.Errorf("%s: no free pci slots, %d devices exceeds the pci bus limit", devs[auto[next]].name, len(devs))
+	}
+
+	// Guests only discover the functions of a slot with a device at function 0.
+	for slot, name := range used {
+		if slot.Function != 0 {
+			if _, ok := used[PCISlot{slot.Slot, 0}]; !ok {
+				return nil, fmt.Errorf("%s: pci slot %s requires a device at function 0 of slot %d", name, slot, slot.Slot)
+			}
+		}
+	}
+
+	return slots, nil
+}
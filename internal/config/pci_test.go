@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_allocatePCISlots(t *testing.T) {
+	devs := func(slots ...string) []slotRequest {
+		var d []slotRequest
+		for i, s := range slots {
+			d = append(d, slotRequest{name: fmt.Sprintf("dev %d", i), slot: s})
+		}
+		return d
+	}
+	many := make([]string, 40)
+
+	tests := []struct {
+		name    string
+		devs    []slotRequest
+		want    []PCISlot
+		wantErr bool
+	}{
+		{
+			name: "spread across slots",
+			devs: devs("", "", ""),
+			want: []PCISlot{{2, 0}, {3, 0}, {4, 0}},
+		},
+		{
+			name: "requested slots are skipped",
+			devs: devs("", "3", "", "5:0"),
+			want: []PCISlot{{2, 0}, {3, 0}, {4, 0}, {5, 0}},
+		},
+		{
+			name: "functions used when slots run out",
+			devs: devs(many...),
+			want: func() []PCISlot {
+				var want []PCISlot
+				for s := 2; s <= 30; s++ {
+					want = append(want, PCISlot{s, 0})
+				}
+				for f := 1; f <= 7 && len(want) < 40; f++ {
+					want = append(want, PCISlot{2, f})
+				}
+				for f := 1; len(want) < 40; f++ {
+					want = append(want, PCISlot{3, f})
+				}
+				return want
+			}(),
+		},
+		{
+			name: "functions of a requested slot",
+			devs: devs("10:0", "10:1"),
+			want: []PCISlot{{10, 0}, {10, 1}},
+		},
+		{
+			name:    "collision",
+			devs:    devs("5:0", "5"),
+			wantErr: true,
+		},
+		{
+			name:    "reserved slot",
+			devs:    devs("31:0"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid function",
+			devs:    devs("5:8"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid slot",
+			devs:    devs("x:0"),
+			wantErr: true,
+		},
+		{
+			name:    "missing function 0",
+			devs:    devs("5:1"),
+			wantErr: true,
+		},
+		{
+			name:    "bus limit exceeded",
+			devs:    devs(make([]string, 29*8+1)...),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := allocatePCISlots(tt.devs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("allocatePCISlots() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocatePCISlots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVMConfig_Cli(t *testing.T) {
+	vm := &VMConfig{
+		Cores:  1,
+		Memory: GiB,
+		RunDir: "/vms/vm1",
+		Network: []*NetConf{
+			{Driver: "virtio-tap", Device: "tap0", MAC: "aa:bb:cc:dd:00:01"},
+			{Driver: "virtio-net", Slot: "2:0"},
+		},
+		HDD:   []*HDD{{Driver: "virtio-blk", Path: "/vms/hdd.img"}},
+		CDROM: []*CDROM{{Driver: "ahci-cd", Path: "/vms/disk.iso"}},
+		Boot:  Boot{Firmware: &Firmware{Path: "/vms/UEFI.fd"}},
+	}
+	got, err := vm.Cli()
+	if err != nil {
+		t.Fatalf("VMConfig.Cli() error = %v", err)
+	}
+	want := []string{
+		"-c", "1", "-m", "1024M", "-A", "-s", "0:0,hostbridge", "-s", "31,lpc", "-s", "1,virtio-rnd",
+		"-l", "com1,autopty=/vms/vm1/tty,log=/vms/vm1/console.log",
+		"-s", "3:0,virtio-tap,tap0,mac=aa:bb:cc:dd:00:01",
+		"-s", "2:0,virtio-net,,",
+		"-s", "4:0,virtio-blk,/vms/hdd.img",
+		"-s", "5:0,ahci-cd,/vms/disk.iso",
+		"-f", "bootrom,/vms/UEFI.fd,,",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VMConfig.Cli() = %v, want %v", got, want)
+	}
+}
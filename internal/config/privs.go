@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// SudoUser is the user who ran hkmgr with sudo, from SUDO_UID and SUDO_GID.
+type SudoUser struct {
+	UID    int
+	GID    int
+	Groups []int // Supplementary groups of the user
+}
+
+// sudoUser returns the user who ran hkmgr with sudo, or nil when hkmgr isn't
+// running as root by sudo.
+var sudoUser = func() *SudoUser {
+	u := parseSudoUser(os.Geteuid(), os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID"))
+	if u != nil {
+		u.Groups = groupIDs(u.UID)
+	}
+	return u
+}
+
+// groupIDs returns the groups the user with uid is a member of.
+func groupIDs(uid int) []int {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil
+	}
+	var groups []int
+	for _, id := range ids {
+		if gid, err := strconv.Atoi(id); err == nil {
+			groups = append(groups, gid)
+		}
+	}
+	return groups
+}
+
+func parseSudoUser(euid int, uidTxt string, gidTxt string) *SudoUser {
+	if euid != 0 || uidTxt == "" || gidTxt == "" {
+		return nil
+	}
+	uid, err := strconv.Atoi(uidTxt)
+	if err != nil || uid == 0 {
+		return nil
+	}
+	gid, err := strconv.Atoi(gidTxt)
+	if err != nil {
+		return nil
+	}
+	return &SudoUser{UID: uid, GID: gid}
+}
+
+// credential returns the credential hyperkit runs as, or nil to run it as
+// root. VMs on vmnet networks need hyperkit to run as root, as the vmnet
+// framework requires it. vpnkit runs as the same user, so VMs on vpnkit
+// networks can connect to its sockets.
+func (v *VMConfig) credential() *syscall.Credential {
+	u := sudoUser()
+	if u == nil {
+		return nil
+	}
+	for _, n := range v.Network {
+		switch n.Driver {
+		case "virtio-net":
+			fmt.Printf("warning: running hyperkit as root, vmnet network %s requires it\n", n.MemberOf)
+			return nil
+		}
+	}
+	return u.credential()
+}
+
+// credential returns the credential to run a process as the user, with its
+// supplementary groups.
+func (u *SudoUser) credential() *syscall.Credential {
+	groups := []uint32{uint32(u.GID)}
+	for _, gid := range u.Groups {
+		if gid != u.GID {
+			groups = append(groups, uint32(gid))
+		}
+	}
+	return &syscall.Credential{Uid: uint32(u.UID), Gid: uint32(u.GID), Groups: groups}
+}
+
+// SudoCredential returns the credential to run a process as the user who ran
+// hkmgr with sudo, or nil when hkmgr isn't run with sudo.
+func SudoCredential() *syscall.Credential {
+	u := sudoUser()
+	if u == nil {
+		return nil
+	}
+	return u.credential()
+}
+
+// ChownRunDir gives the run dir of the VM, and its tap devices, to the user
+// who ran hkmgr with sudo, so hyperkit can run as that user and files like the
+// pid, uuid, and generated MAC aren't left owned by root. It does nothing when
+// hkmgr isn't run with sudo.
+func (v *VMConfig) ChownRunDir() error {
+	u := sudoUser()
+	if u == nil {
+		return nil
+	}
+
+	if err := chownDir(v.RunDir, u); err != nil {
+		return err
+	}
+
+	for _, n := range v.Network {
+		if n.Driver != "virtio-tap" || n.Device == "" {
+			continue
+		}
+		if err := os.Chown(n.devicePath(), u.UID, u.GID); err != nil {
+			return fmt.Errorf("changing owner of tap device %s, %v", n.Device, err)
+		}
+	}
+	return nil
+}
+
+// ChownRunDir gives the run dir of the network, and the sockets and files of
+// a previous vpnkit in it, to the user who ran hkmgr with sudo, so vpnkit can
+// run as that user. It does nothing when hkmgr isn't run with sudo.
+func (v *VPNKit) ChownRunDir() error {
+	u := sudoUser()
+	if u == nil {
+		return nil
+	}
+	return chownDir(v.RunDir, u)
+}
+
+// chownDir changes the owner of dir and everything in it to the user.
+func chownDir(dir string, u *SudoUser) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, u.UID, u.GID)
+	})
+	if err != nil {
+		return fmt.Errorf("changing owner of run dir %s, %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func Test_parseSudoUser(t *testing.T) {
+	tests := []struct {
+		name string
+		euid int
+		uid  string
+		gid  string
+		want *SudoUser
+	}{
+		{name: "sudo", euid: 0, uid: "501", gid: "20", want: &SudoUser{UID: 501, GID: 20}},
+		{name: "not root", euid: 501, uid: "501", gid: "20"},
+		{name: "root without sudo", euid: 0},
+		{name: "sudo from root", euid: 0, uid: "0", gid: "0"},
+		{name: "invalid uid", euid: 0, uid: "staff", gid: "20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSudoUser(tt.euid, tt.uid, tt.gid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSudoUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVMConfig_credential(t *testing.T) {
+	origSudoUser := sudoUser
+	defer func() { sudoUser = origSudoUser }()
+
+	tests := []struct {
+		name    string
+		user    *SudoUser
+		network []*NetConf
+		want    *syscall.Credential
+	}{
+		{
+			name:    "not sudo",
+			network: []*NetConf{{Driver: "virtio-tap"}},
+		},
+		{
+			name:    "tap",
+			user:    &SudoUser{UID: 501, GID: 20},
+			network: []*NetConf{{Driver: "virtio-tap"}},
+			want:    &syscall.Credential{Uid: 501, Gid: 20, Groups: []uint32{20}},
+		},
+		{
+			name:    "supplementary groups",
+			user:    &SudoUser{UID: 501, GID: 20, Groups: []int{20, 12, 80}},
+			network: []*NetConf{{Driver: "virtio-tap"}},
+			want:    &syscall.Credential{Uid: 501, Gid: 20, Groups: []uint32{20, 12, 80}},
+		},
+		{
+			name:    "vpnkit",
+			user:    &SudoUser{UID: 501, GID: 20},
+			network: []*NetConf{{Driver: "virtio-tap"}, {Driver: "virtio-vpnkit"}},
+			want:    &syscall.Credential{Uid: 501, Gid: 20, Groups: []uint32{20}},
+		},
+		{
+			name:    "vmnet",
+			user:    &SudoUser{UID: 501, GID: 20},
+			network: []*NetConf{{Driver: "virtio-tap"}, {Driver: "virtio-net"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sudoUser = func() *SudoUser { return tt.user }
+			v := &VMConfig{Network: tt.network}
+			if got := v.credential(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VMConfig.credential() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVMConfig_ChownRunDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "privs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runDir := filepath.Join(dir, "vm")
+	if err := os.MkdirAll(filepath.Join(runDir, "vsock"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pid", "uuid", "net0_mac", "tap0"} {
+		if err := ioutil.WriteFile(filepath.Join(runDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origSudoUser := sudoUser
+	defer func() { sudoUser = origSudoUser }()
+	// Changing the owner to the current user works without root.
+	sudoUser = func() *SudoUser { return &SudoUser{UID: os.Getuid(), GID: os.Getgid()} }
+
+	v := &VMConfig{
+		RunDir:  runDir,
+		Network: []*NetConf{{Driver: "virtio-tap", Device: filepath.Join(runDir, "tap0")}, {Driver: "virtio-net"}},
+	}
+	if err := v.ChownRunDir(); err != nil {
+		t.Errorf("VMConfig.ChownRunDir() error = %v", err)
+	}
+
+	v.Network = []*NetConf{{Driver: "virtio-tap", Device: filepath.Join(dir, "missing")}}
+	if err := v.ChownRunDir(); err == nil {
+		t.Errorf("VMConfig.ChownRunDir() of a missing tap device succeeded")
+	}
+}
+
+func TestVPNKit_ChownRunDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "privs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "vpnkit.pid"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origSudoUser := sudoUser
+	defer func() { sudoUser = origSudoUser }()
+	sudoUser = func() *SudoUser { return &SudoUser{UID: os.Getuid(), GID: os.Getgid()} }
+
+	if err := (&VPNKit{RunDir: dir}).ChownRunDir(); err != nil {
+		t.Errorf("VPNKit.ChownRunDir() error = %v", err)
+	}
+	if err := (&VPNKit{RunDir: filepath.Join(dir, "missing")}).ChownRunDir(); err == nil {
+		t.Errorf("VPNKit.ChownRunDir() of a missing run dir succeeded")
+	}
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Replica identifies a VM expanded from a VM definition with a count.
+type Replica struct {
+	Of    string // Name of the VM definition the replica was expanded from
+	Index int    // Index of the replica, from 0 to Count-1
+	Count int    // Total number of replicas
+}
+
+// templateFuncs are the functions available to templated config values.
+var templateFuncs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}
+
+// ExpandReplicas replaces each VM with a count with replicas named
+// <name>-0 to <name>-<count-1>. Each replica gets its own run dir, network
+// IPs are rendered as templates or, when not templated, offset by the index of
+// the replica, and forward host ports are offset by the index of the replica.
+func (c *Config) ExpandReplicas() error {
+	var names []string
+	for name, vm := range c.VM {
+		if vm.Count != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vm := c.VM[name]
+		if vm.Count < 0 {
+			return fmt.Errorf("vm %s: count must be greater than 0", name)
+		}
+		if vm.UUID != "" {
+			return fmt.Errorf("vm %s: uuid cannot be set with count", name)
+		}
+		for _, net := range vm.Network {
+			if net.MAC != "" {
+				return fmt.Errorf("vm %s: network mac cannot be set with count", name)
+			}
+			if net.Driver == "virtio-tap" && net.Device != "" {
+				return fmt.Errorf("vm %s: network device cannot be set with count, tap devices are allocated to each replica", name)
+			}
+		}
+
+		delete(c.VM, name)
+
+		for i := 0; i < vm.Count; i++ {
+			replicaName := fmt.Sprintf("%s-%d", name, i)
+			if _, ok := c.VM[replicaName]; ok {
+				return fmt.Errorf("vm %s: replica %s conflicts with an existing VM", name, replicaName)
+			}
+
+			r := vm.clone()
+			r.Count = 0
+			r.Replica = &Replica{Of: name, Index: i, Count: vm.Count}
+
+			if vm.RunDir != "" {
+				r.RunDir = filepath.Join(vm.RunDir, strconv.Itoa(i))
+			}
+
+			for _, net := range r.Network {
+				ip, err := replicaIP(net.IP, replicaName, r.Replica)
+				if err != nil {
+					return fmt.Errorf("vm %s: %v", replicaName, err)
+				}
+				net.IP = ip
+			}
+			for _, fwd := range r.Forward {
+				host, err := offsetPort(fwd.Host, i)
+				if err != nil {
+					return fmt.Errorf("vm %s: forward host %s, %v", replicaName, fwd.Host, err)
+				}
+				fwd.Host = host
+			}
+			c.VM[replicaName] = r
+		}
+	}
+	return nil
+}
+
+// Select returns the VM with the given name, or if there isn't one, all of
+// the replicas expanded from the VM definition with that name.
+func (vms VM) Select(name string) (VM, error) {
+	if vm, ok := vms[name]; ok {
+		return VM{name: vm}, nil
+	}
+
+	selected := VM{}
+	for n, vm := range vms {
+		if vm.Replica != nil && vm.Replica.Of == name {
+			selected[n] = vm
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%s not found in the configuration", name)
+	}
+	return selected, nil
+}
+
+// replicaIP renders ip for a replica. IPs containing template actions are
+// executed as a template, otherwise the address is offset by the index of the
+// replica, eg. 192.168.99.10/24 becomes 192.168.99.11/24 for the second
+// replica.
+func replicaIP(ip string, name string, r *Replica) (string, error) {
+	if ip == "" {
+		return "", nil
+	}
+
+	if strings.Contains(ip, "{{") {
+		return renderTemplate("ip", ip, templateData{Name: name, Replica: *r})
+	}
+
+	addr, prefix := ip, ""
+	if i := strings.Index(ip, "/"); i != -1 {
+		addr, prefix = ip[:i], ip[i:]
+	}
+	parsed := net.ParseIP(addr)
+	if parsed == nil {
+		return "", fmt.Errorf("could not parse IP address: %s", ip)
+	}
+	return ipAdd(parsed, r.Index).String() + prefix, nil
+}
+
+// ipAdd returns the IP address n addresses after ip.
+func ipAdd(ip net.IP, n int) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	i := new(big.Int).SetBytes(ip)
+	i.Add(i, big.NewInt(int64(n)))
+
+	b := i.Bytes()
+	out := make(net.IP, len(ip))
+	if len(b) > len(out) {
+		b = b[len(b)-len(out):]
+	}
+	copy(out[len(out)-len(b):], b)
+	return out
+}
+
+// templateData is the data available to templated config values, eg. the
+// kexec cmdline.
+type templateData struct {
+	Name    string     // Name of the VM
+	Domain  string     // Domain of VM names, when dns is configured
+	Network []*NetConf // Network configuration of the VM
+	Shares  []*Share   // Shared directories of the VM
+	Replica            // Replica details, zero when the VM isn't a replica
+}
+
+// renderTemplate executes text as a template with data.
+func renderTemplate(name string, text string, data templateData) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering %s template: %v", name, err)
+	}
+	return buf.String(), nil
+}
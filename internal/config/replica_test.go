@@ -0,0 +1,138 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestConfig_ExpandReplicas(t *testing.T) {
+	c := &Config{
+		VM: VM{
+			"worker": {
+				Count:  2,
+				RunDir: ".run/vm/worker",
+				Network: []*NetConf{
+					{Driver: "virtio-tap", MemberOf: "net1", IP: "192.168.99.10/24"},
+					{Driver: "virtio-tap", MemberOf: "net2", IP: "10.0.{{.Index}}.{{add 100 .Index}}/16"},
+				},
+			},
+			"server": {},
+		},
+	}
+	if err := c.ExpandReplicas(); err != nil {
+		t.Fatalf("Config.ExpandReplicas() error = %v", err)
+	}
+
+	var names []string
+	for name := range c.VM {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"server", "worker-0", "worker-1"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Config.ExpandReplicas() VMs = %v, want %v", names, want)
+	}
+
+	w1 := c.VM["worker-1"]
+	if w1.RunDir != ".run/vm/worker/1" {
+		t.Errorf("Config.ExpandReplicas() RunDir = %s, want .run/vm/worker/1", w1.RunDir)
+	}
+	if want := (&Replica{Of: "worker", Index: 1, Count: 2}); !reflect.DeepEqual(w1.Replica, want) {
+		t.Errorf("Config.ExpandReplicas() Replica = %#v, want %#v", w1.Replica, want)
+	}
+	if w1.Network[0].IP != "192.168.99.11/24" {
+		t.Errorf("Config.ExpandReplicas() IP = %s, want 192.168.99.11/24", w1.Network[0].IP)
+	}
+	if w1.Network[1].IP != "10.0.1.101/16" {
+		t.Errorf("Config.ExpandReplicas() IP = %s, want 10.0.1.101/16", w1.Network[1].IP)
+	}
+	if c.VM["worker-0"].Network[0].IP != "192.168.99.10/24" {
+		t.Errorf("Config.ExpandReplicas() IP = %s, want 192.168.99.10/24", c.VM["worker-0"].Network[0].IP)
+	}
+}
+
+func TestConfig_ExpandReplicasErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		vm   VM
+	}{
+		{
+			name: "uuid set",
+			vm:   VM{"worker": {Count: 2, UUID: "9445CA7C-F976-456E-9061-B932194D8166"}},
+		},
+		{
+			name: "mac set",
+			vm:   VM{"worker": {Count: 2, Network: []*NetConf{{MAC: "AA:BB:CC:DD:00:01"}}}},
+		},
+		{
+			name: "tap device set",
+			vm:   VM{"worker": {Count: 2, Network: []*NetConf{{Driver: "virtio-tap", Device: "tap1"}}}},
+		},
+		{
+			name: "conflicting name",
+			vm:   VM{"worker": {Count: 2}, "worker-1": {}},
+		},
+		{
+			name: "negative count",
+			vm:   VM{"worker": {Count: -1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{VM: tt.vm}
+			if err := c.ExpandReplicas(); err == nil {
+				t.Errorf("Config.ExpandReplicas() expected an error")
+			}
+		})
+	}
+}
+
+func TestVM_Select(t *testing.T) {
+	vms := VM{
+		"worker-0": {Replica: &Replica{Of: "worker", Index: 0, Count: 2}},
+		"worker-1": {Replica: &Replica{Of: "worker", Index: 1, Count: 2}},
+		"server":   {},
+	}
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "worker", want: []string{"worker-0", "worker-1"}},
+		{name: "worker-1", want: []string{"worker-1"}},
+		{name: "server", want: []string{"server"}},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := vms.Select(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VM.Select() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var names []string
+			for name := range got {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("VM.Select() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderTemplate(t *testing.T) {
+	data := templateData{
+		Name:    "worker-1",
+		Network: []*NetConf{{IP: "192.168.99.11/24"}},
+		Replica: Replica{Of: "worker", Index: 1, Count: 2},
+	}
+	got, err := renderTemplate("cmdline", "hostname={{.Name}} ip={{(index .Network 0).IP}} node={{add .Index 1}}/{{.Count}}", data)
+	if err != nil {
+		t.Fatalf("renderTemplate() error = %v", err)
+	}
+	if want := "hostname=worker-1 ip=192.168.99.11/24 node=2/2"; got != want {
+		t.Errorf("renderTemplate() = %s, want %s", got, want)
+	}
+}
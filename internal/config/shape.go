@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// Shapings returns the shaping of the network interfaces of a VM with a
+// bandwidth, delay or loss.
+func (v *VMConfig) Shapings() ([]*network.Shaping, error) {
+	var shapings []*network.Shaping
+	for _, n := range v.Network {
+		s, err := n.shaping()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			shapings = append(shapings, s)
+		}
+	}
+	return shapings, nil
+}
+
+// shaping returns the shaping of the interface, or nil if it has none.
+// Shaping is applied to the tap device of the interface, so it's only
+// supported on tap interfaces.
+func (n *NetConf) shaping() (*network.Shaping, error) {
+	if n.Bandwidth == "" && n.Delay == "" && n.Loss == "" {
+		return nil, nil
+	}
+	if n.Driver != "virtio-tap" {
+		return nil, fmt.Errorf("bandwidth, delay and loss are only supported on virtio-tap interfaces")
+	}
+
+	s := &network.Shaping{Interface: n.Device}
+	var err error
+	if n.Bandwidth != "" {
+		if s.Bandwidth, err = network.ParseBandwidth(n.Bandwidth); err != nil {
+			return nil, err
+		}
+	}
+	if n.Delay != "" {
+		if s.Delay, err = time.ParseDuration(n.Delay); err != nil || s.Delay < 0 {
+			return nil, fmt.Errorf("delay must be a duration, eg. 50ms: %s", n.Delay)
+		}
+	}
+	if n.Loss != "" {
+		if s.Loss, err = network.ParseLoss(n.Loss); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+func TestVMConfig_Shapings(t *testing.T) {
+	tests := []struct {
+		name    string
+		network []*NetConf
+		want    []*network.Shaping
+		wantErr bool
+	}{
+		{
+			name:    "no shaping",
+			network: []*NetConf{{Driver: "virtio-tap", Device: "tap0"}},
+		},
+		{
+			name: "tap",
+			network: []*NetConf{
+				{Driver: "virtio-net"},
+				{Driver: "virtio-tap", Device: "tap1", Bandwidth: "10Mbit", Delay: "50ms", Loss: "1%"},
+			},
+			want: []*network.Shaping{{Interface: "tap1", Bandwidth: 10000000, Delay: 50 * time.Millisecond, Loss: 0.01}},
+		},
+		{
+			name:    "vmnet",
+			network: []*NetConf{{Driver: "virtio-net", Delay: "50ms"}},
+			wantErr: true,
+		},
+		{
+			name:    "bad delay",
+			network: []*NetConf{{Driver: "virtio-tap", Device: "tap1", Delay: "50"}},
+			wantErr: true,
+		},
+		{
+			name:    "bad loss",
+			network: []*NetConf{{Driver: "virtio-tap", Device: "tap1", Loss: "0.01"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &VMConfig{Network: tt.network}
+			got, err := vm.Shapings()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VMConfig.Shapings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VMConfig.Shapings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// Share is a host directory shared with a VM via virtio-9p. The guest mounts
+// it by its tag, eg. mount -t 9p -o trans=virtio <tag> /mnt. hyperkit doesn't
+// serve the directory itself, it connects the device to a 9P server on a unix
+// socket, run by up in the background.
+type Share struct {
+	Path     string `toml:"path" json:"path"`
+	Tag      string `toml:"tag,omitempty" json:"tag,omitempty"`
+	ReadOnly bool   `toml:"read_only,omitempty" json:"read_only,omitempty"`
+	Slot     string `toml:"slot,omitempty" json:"slot,omitempty"`
+}
+
+// defaults sets the tag to the base name of the path if not set.
+func (s *Share) defaults() {
+	if s.Tag == "" && s.Path != "" {
+		s.Tag = filepath.Base(s.Path)
+	}
+}
+
+func (s *Share) validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("share path not specified")
+	}
+	if !dirExists(s.Path) {
+		return fmt.Errorf("share directory not found: %s", s.Path)
+	}
+	if s.Tag == "" {
+		return fmt.Errorf("share %s tag not specified", s.Path)
+	}
+	return nil
+}
+
+// device connects the share to the 9P server listening on socket.
+func (s *Share) device(name string, slot PCISlot, socket string) PCIDevice {
+	return PCIDevice{Name: name, Slot: slot, Emulation: "virtio-9p", Config: []argField{
+		{Name: "path", Value: "path=" + socket},
+		{Name: "tag", Value: "tag=" + s.Tag},
+	}}
+}
+
+// updateRelativePaths makes the path relative to the config file absolute.
+func (s *Share) updateRelativePaths(configDir string) {
+	if s.Path != "" && !filepath.IsAbs(s.Path) {
+		s.Path = filepath.Join(configDir, s.Path)
+	}
+}
+
+// ShareSocket is the unix socket the 9P server of share i of a VM listens on.
+func (v *VMConfig) ShareSocket(i int) string {
+	return filepath.Join(v.RunDir, fmt.Sprintf("share%d.sock", i))
+}
+
+// Shares returns the 9P servers of the shares of a VM.
+func (v *VMConfig) Shares() []*network.Share {
+	var shares []*network.Share
+	for i, s := range v.Share {
+		shares = append(shares, &network.Share{Path: s.Path, Socket: v.ShareSocket(i), ReadOnly: s.ReadOnly})
+	}
+	return shares
+}
+
+// SharePidFile is the pid file of the process serving the shares of a VM.
+func (v *VMConfig) SharePidFile() string {
+	return filepath.Join(v.RunDir, "share.pid")
+}
+
+// StopShares stops the process serving the shares of a VM, if running.
+func (v *VMConfig) StopShares() error {
+	return stopPidFile(v.SharePidFile())
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr-share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	share := &Share{Path: "src", ReadOnly: true}
+	share.defaults()
+	share.updateRelativePaths(dir)
+
+	if share.Tag != "src" {
+		t.Errorf("Share.defaults() tag = %s, want src", share.Tag)
+	}
+	if err := share.validate(); err != nil {
+		t.Errorf("Share.validate() error = %v", err)
+	}
+	socket := filepath.Join(dir, "share0.sock")
+	want := "5:0,virtio-9p,path=" + socket + ",tag=src"
+	if got := share.device("share 0", PCISlot{5, 0}, socket).Arg(); got != want {
+		t.Errorf("Share.device() = %s, want %s", got, want)
+	}
+
+	missing := &Share{Path: filepath.Join(dir, "missing"), Tag: "missing"}
+	if err := missing.validate(); err == nil {
+		t.Errorf("Share.validate() expected an error for a missing directory")
+	}
+}
+
+// TestVMConfig_Shares checks hyperkit is connected to the sockets the 9P
+// servers of the shares listen on.
+func TestVMConfig_Shares(t *testing.T) {
+	vm := &VMConfig{
+		RunDir: "/run/vm1",
+		Share:  []*Share{{Path: "/src", Tag: "src"}, {Path: "/data", Tag: "data", ReadOnly: true}},
+	}
+	devs, err := vm.devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares := vm.Shares()
+	if len(shares) != 2 || len(devs) != 2 {
+		t.Fatalf("VMConfig.Shares() = %d servers for %d devices, want 2", len(shares), len(devs))
+	}
+	for i, s := range shares {
+		if want := fmt.Sprintf("/run/vm1/share%d.sock", i); s.Socket != want {
+			t.Errorf("share %d socket = %s, want %s", i, s.Socket, want)
+		}
+		if s.Path != vm.Share[i].Path || s.ReadOnly != vm.Share[i].ReadOnly {
+			t.Errorf("share %d server = %+v, want path %s and read only %v", i, s, vm.Share[i].Path, vm.Share[i].ReadOnly)
+		}
+		if !strings.Contains(devs[i].Arg(), ",virtio-9p,path="+s.Socket+",") {
+			t.Errorf("share %d device = %s, want path=%s", i, devs[i].Arg(), s.Socket)
+		}
+	}
+}
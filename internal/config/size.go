@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a memory or disk size in bytes. In the configuration a size is a
+// number followed by an optional unit, eg. "4GiB", "4 GB", or "512M". Decimal
+// (KB, MB, GB, TB) and binary (KiB, MiB, GiB, TiB) units are supported, as are
+// the single letter units K, M, G, and T, which are binary as with hyperkit.
+// Units are case insensitive and numbers without a unit are in MiB, eg. 4096
+// is 4GiB. hyperkit is given memory in whole MiB, see Hyperkit.
+type Size uint64
+
+// Size units
+const (
+	Byte Size = 1
+	KiB       = 1024 * Byte
+	MiB       = 1024 * KiB
+	GiB       = 1024 * MiB
+	TiB       = 1024 * GiB
+	KB        = 1000 * Byte
+	MB        = 1000 * KB
+	GB        = 1000 * MB
+	TB        = 1000 * GB
+)
+
+// Limits of memory and disk sizes.
+const (
+	MinMemory   = 16 * MiB
+	MaxMemory   = 1 * TiB
+	MinDiskSize = 1 * MiB
+	MaxDiskSize = 64 * TiB
+)
+
+var sizeUnits = map[string]Size{
+	"":    MiB,
+	"b":   Byte,
+	"k":   KiB,
+	"kib": KiB,
+	"kb":  KB,
+	"m":   MiB,
+	"mib": MiB,
+	"mb":  MB,
+	"g":   GiB,
+	"gib": GiB,
+	"gb":  GB,
+	"t":   TiB,
+	"tib": TiB,
+	"tb":  TB,
+}
+
+// ParseSize parses a size with an optional unit, eg. "4GiB", "4 GB", or 4096.
+func ParseSize(s string) (Size, error) {
+	str := strings.TrimSpace(s)
+
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(str)
+	}
+	num, unit := str[:i], strings.ToLower(strings.TrimSpace(str[i:]))
+
+	if num == "" {
+		return 0, fmt.Errorf("invalid size %q: missing number", s)
+	}
+	mult, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %s", s, str[i:])
+	}
+
+	if !strings.Contains(num, ".") {
+		n, err := strconv.ParseUint(num, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q: %v", s, err)
+		}
+		if n > math.MaxUint64/uint64(mult) {
+			return 0, fmt.Errorf("invalid size %q: size is too large", s)
+		}
+		return Size(n) * mult, nil
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	bytes := f * float64(mult)
+	if bytes >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid size %q: size is too large", s)
+	}
+	return Size(math.Round(bytes)), nil
+}
+
+// UnmarshalText parses a size from the configuration.
+func (s *Size) UnmarshalText(text []byte) error {
+	size, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// MarshalText formats a size using the largest unit that represents it
+// exactly, so that it parses back to the same size.
+func (s Size) MarshalText() ([]byte, error) {
+	for _, u := range []struct {
+		size Size
+		name string
+	}{
+		{TiB, "TiB"}, {GiB, "GiB"}, {MiB, "MiB"}, {TB, "TB"}, {GB, "GB"}, {MB, "MB"}, {KiB, "KiB"}, {KB, "KB"},
+	} {
+		if s >= u.size && s%u.size == 0 {
+			return []byte(fmt.Sprintf("%d%s", s/u.size, u.name)), nil
+		}
+	}
+	return []byte(fmt.Sprintf("%dB", s)), nil
+}
+
+// String formats a size for display using binary units, eg. "4 GiB" or
+// "1.5 GiB".
+func (s Size) String() string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	f := float64(s)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return strconv.FormatFloat(math.Round(f*10)/10, 'f', -1, 64) + " " + units[i]
+}
+
+// Hyperkit formats a size as accepted by hyperkit's -m flag, which takes
+// whole MiB, as its M unit is binary. Sizes that aren't a whole MiB, eg. 4GB,
+// are rounded up to the next MiB rather than losing memory.
+func (s Size) Hyperkit() string {
+	mib := s / MiB
+	if s%MiB != 0 {
+		mib++
+	}
+	return fmt.Sprintf("%dM", mib)
+}
+
+// validate checks that a size is within min and max.
+func (s Size) validate(name string, min, max Size) error {
+	if s < min {
+		return fmt.Errorf("%s %s is less than the minimum of %s", name, s, min)
+	}
+	if s > max {
+		return fmt.Errorf("%s %s is more than the maximum of %s", name, s, max)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Size
+		wantErr bool
+	}{
+		{in: "4096", want: 4 * GiB},
+		{in: "4G", want: 4 * GiB},
+		{in: "4gib", want: 4 * GiB},
+		{in: "4 GiB", want: 4 * GiB},
+		{in: "4 GB", want: 4 * GB},
+		{in: "4GB", want: 4000000000},
+		{in: "512M", want: 512 * MiB},
+		{in: "1.5GiB", want: 1536 * MiB},
+		{in: "100kb", want: 100000},
+		{in: "2T", want: 2 * TiB},
+		{in: "1024B", want: KiB},
+		{in: "", wantErr: true},
+		{in: "GB", wantErr: true},
+		{in: "4 PB", wantErr: true},
+		{in: "-1G", wantErr: true},
+		{in: "1.2.3G", wantErr: true},
+		{in: "99999999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSize_Format(t *testing.T) {
+	tests := []struct {
+		size     Size
+		text     string
+		str      string
+		hyperkit string
+	}{
+		{size: 4 * GiB, text: "4GiB", str: "4 GiB", hyperkit: "4096M"},
+		{size: 1536 * MiB, text: "1536MiB", str: "1.5 GiB", hyperkit: "1536M"},
+		{size: 4 * GB, text: "4GB", str: "3.7 GiB", hyperkit: "3815M"},
+		{size: 1500 * KiB, text: "1500KiB", str: "1.5 MiB", hyperkit: "2M"},
+		{size: 100, text: "100B", str: "100 B", hyperkit: "1M"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			text, err := tt.size.MarshalText()
+			if err != nil {
+				t.Fatalf("Size.MarshalText() error = %v", err)
+			}
+			if string(text) != tt.text {
+				t.Errorf("Size.MarshalText() = %s, want %s", text, tt.text)
+			}
+			if parsed, _ := ParseSize(string(text)); parsed != tt.size {
+				t.Errorf("ParseSize(Size.MarshalText()) = %d, want %d", parsed, tt.size)
+			}
+			if tt.size.String() != tt.str {
+				t.Errorf("Size.String() = %s, want %s", tt.size.String(), tt.str)
+			}
+			if tt.size.Hyperkit() != tt.hyperkit {
+				t.Errorf("Size.Hyperkit() = %s, want %s", tt.size.Hyperkit(), tt.hyperkit)
+			}
+		})
+	}
+}
+
+func TestSize_UnmarshalText(t *testing.T) {
+	var cfg struct {
+		VM VM `toml:"vm"`
+	}
+	blob := `
+[vm.a]
+memory = "4 GB"
+
+[vm.b]
+memory = 2048
+
+[[vm.b.hdd]]
+path = "hdd1.qcow2"
+size = "32GiB"
+`
+	if _, err := toml.Decode(blob, &cfg); err != nil {
+		t.Fatalf("toml.Decode() error = %v", err)
+	}
+	if cfg.VM["a"].Memory != 4*GB {
+		t.Errorf("memory = %d, want %d", cfg.VM["a"].Memory, 4*GB)
+	}
+	if cfg.VM["b"].Memory != 2*GiB {
+		t.Errorf("memory = %d, want %d", cfg.VM["b"].Memory, 2*GiB)
+	}
+	if cfg.VM["b"].HDD[0].Size != 32*GiB {
+		t.Errorf("hdd size = %d, want %d", cfg.VM["b"].HDD[0].Size, 32*GiB)
+	}
+
+	if _, err := toml.Decode(`memory = "4 PB"`, &struct{ Memory Size }{}); err == nil {
+		t.Errorf("toml.Decode() expected an error for an unknown unit")
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// tapDir is where tuntaposx creates the tap devices.
+var tapDir = "/dev"
+
+// maxTaps is the number of tap devices created by tuntaposx.
+const maxTaps = 16
+
+// allocateTaps assigns tap devices to virtio-tap interfaces without a
+// device. The choice is persisted as <memberOf>_tap in the run dir of the VM
+// and kept on later runs unless another interface has since been configured
+// with it. Otherwise, with AllocateTaps, the lowest numbered tap device that
+// can be opened for writing, eg. isn't in use by a running VM, and isn't
+// claimed by another interface is chosen. If there isn't one the device is
+// left unset. A device configured on more than one interface is an error.
+func (c *Config) allocateTaps() error {
+	var vmNames []string
+	for name := range c.VM {
+		vmNames = append(vmNames, name)
+	}
+	sort.Strings(vmNames)
+
+	claimed := map[string]string{}
+	var unset []*NetConf
+	var unsetVMs []string
+	for _, vmName := range vmNames {
+		for _, n := range c.VM[vmName].Network {
+			if n.Driver != "virtio-tap" {
+				continue
+			}
+			if n.Device == "" {
+				unset = append(unset, n)
+				unsetVMs = append(unsetVMs, vmName)
+				continue
+			}
+			name := tapName(n.Device)
+			if other, ok := claimed[name]; ok {
+				return fmt.Errorf("tap device %s is used by vm %s and vm %s", name, other, vmName)
+			}
+			claimed[name] = vmName
+		}
+	}
+
+	var pending []int
+	for i, n := range unset {
+		dev, err := ioutil.ReadFile(n.tapFile(c.VM[unsetVMs[i]].RunDir))
+		name := strings.TrimSpace(string(dev))
+		if err != nil || name == "" || claimed[name] != "" {
+			pending = append(pending, i)
+			continue
+		}
+		claimed[name] = unsetVMs[i]
+		n.Device = name
+	}
+	if !c.AllocateTaps {
+		return nil
+	}
+
+	for _, i := range pending {
+		n := unset[i]
+		for t := 0; t < maxTaps; t++ {
+			name := fmt.Sprintf("tap%d", t)
+			if claimed[name] != "" || !tapFree(name) {
+				continue
+			}
+			claimed[name] = unsetVMs[i]
+			n.Device = name
+			break
+		}
+		if n.Device == "" {
+			// Reported by validate, so commands not using the device still work.
+			continue
+		}
+		if err := ioutil.WriteFile(n.tapFile(c.VM[unsetVMs[i]].RunDir), []byte(n.Device), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *NetConf) tapFile(runDir string) string {
+	return filepath.Join(runDir, n.MemberOf+"_tap")
+}
+
+// tapName returns the device name of a tap device path, eg. tap0 for
+// /dev/tap0.
+func tapName(device string) string {
+	return strings.TrimPrefix(device, "/dev/")
+}
+
+// tapFree reports if a tap device can be opened for writing, which fails
+// while it's open by hyperkit.
+func tapFree(name string) bool {
+	dev, err := os.OpenFile(filepath.Join(tapDir, name), os.O_WRONLY, 0666)
+	if err != nil {
+		return false
+	}
+	dev.Close()
+	return true
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig_allocateTaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	devDir := filepath.Join(dir, "dev")
+	if err := os.Mkdir(devDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// tap2 is missing, so it can't be opened as when it's in use.
+	for _, name := range []string{"tap0", "tap1", "tap3"} {
+		if err := ioutil.WriteFile(filepath.Join(devDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tapDir = devDir
+	defer func() { tapDir = "/dev" }()
+
+	runDir := func(name string) string {
+		d := filepath.Join(dir, name)
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// vm4 chose tap1 on a previous run, but vm1 is now configured with it.
+	if err := ioutil.WriteFile(filepath.Join(runDir("vm4"), "net1_tap"), []byte("tap1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// vm3 chose tap5 on a previous run, and keeps it.
+	if err := ioutil.WriteFile(filepath.Join(runDir("vm3"), "net1_tap"), []byte("tap5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nic := func(device string) []*NetConf {
+		return []*NetConf{{Driver: "virtio-tap", Device: device, MemberOf: "net1"}}
+	}
+	newConfig := func() *Config {
+		return &Config{VM: VM{
+			"vm1": &VMConfig{RunDir: runDir("vm1"), Network: nic("/dev/tap1")},
+			"vm2": &VMConfig{RunDir: runDir("vm2"), Network: nic("")},
+			"vm3": &VMConfig{RunDir: runDir("vm3"), Network: nic("")},
+			"vm4": &VMConfig{RunDir: runDir("vm4"), Network: nic("")},
+			"vm5": &VMConfig{RunDir: runDir("vm5"), Network: nic("")},
+		}}
+	}
+
+	// Without AllocateTaps previous choices are only read.
+	cfg := newConfig()
+	if err := cfg.allocateTaps(); err != nil {
+		t.Fatalf("Config.allocateTaps() error = %v", err)
+	}
+	for name, want := range map[string]string{"vm1": "/dev/tap1", "vm2": "", "vm3": "tap5", "vm4": ""} {
+		if got := cfg.VM[name].Network[0].Device; got != want {
+			t.Errorf("Config.allocateTaps() %s device = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vm2", "net1_tap")); !os.IsNotExist(err) {
+		t.Errorf("Config.allocateTaps() persisted a choice without AllocateTaps, %v", err)
+	}
+
+	cfg = newConfig()
+	cfg.AllocateTaps = true
+	if err := cfg.allocateTaps(); err != nil {
+		t.Fatalf("Config.allocateTaps() error = %v", err)
+	}
+
+	for name, want := range map[string]string{"vm1": "/dev/tap1", "vm2": "tap0", "vm3": "tap5", "vm4": "tap3", "vm5": ""} {
+		if got := cfg.VM[name].Network[0].Device; got != want {
+			t.Errorf("Config.allocateTaps() %s device = %q, want %q", name, got, want)
+		}
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "vm4", "net1_tap"))
+	if err != nil || string(got) != "tap3" {
+		t.Errorf("Config.allocateTaps() persisted %q, %v, want tap3", got, err)
+	}
+}
+
+func TestConfig_allocateTapsShared(t *testing.T) {
+	cfg := &Config{VM: VM{
+		"vm1": &VMConfig{Network: []*NetConf{{Driver: "virtio-tap", Device: "tap1", MemberOf: "net1"}}},
+		"vm2": &VMConfig{Network: []*NetConf{{Driver: "virtio-tap", Device: "/dev/tap1", MemberOf: "net1"}}},
+	}}
+	if err := cfg.allocateTaps(); err == nil {
+		t.Errorf("Config.allocateTaps() expected an error")
+	}
+}
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpandTemplates merges each VM that sets extends with its chain of
+// templates. Values set on a VM override those of the template it extends:
+//
+//   - Scalars (memory, cores, ssh_key, etc.) are inherited when unset.
+//     vsock set to false overrides a template setting it to true.
+//   - A boot method set on the VM replaces the boot method of the template.
+//   - Lists (before, after, requires, network, hdd, cdrom, share, forward,
+//     allow) are replaced when set. Listing network, hdd, cdrom, share,
+//     forward, or allow in append instead appends the entries of the VM
+//     after those of the template.
+//
+// Templates may themselves extend other templates, where the same rules apply.
+func (c *Config) ExpandTemplates() error {
+	for name, tmpl := range c.Template {
+		if err := tmpl.validateTemplate(); err != nil {
+			return fmt.Errorf("template %s: %v", name, err)
+		}
+		if _, err := c.resolveTemplate(name, nil); err != nil {
+			return err
+		}
+	}
+
+	for name, vm := range c.VM {
+		if vm.Extends == "" {
+			continue
+		}
+		base, err := c.resolveTemplate(vm.Extends, nil)
+		if err != nil {
+			return fmt.Errorf("vm %s: %v", name, err)
+		}
+		merged, err := vm.inherit(base)
+		if err != nil {
+			return fmt.Errorf("vm %s: %v", name, err)
+		}
+		c.VM[name] = merged
+	}
+	return nil
+}
+
+// resolveTemplate returns the named template merged with the templates it
+// extends. chain holds the template names already visited and is used to
+// detect inheritance cycles.
+func (c *Config) resolveTemplate(name string, chain []string) (*VMConfig, error) {
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("template inheritance cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+
+	tmpl, ok := c.Template[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s not found in the configuration", name)
+	}
+
+	if tmpl.Extends == "" {
+		return tmpl.clone(), nil
+	}
+
+	base, err := c.resolveTemplate(tmpl.Extends, append(chain, name))
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.inherit(base)
+}
+
+// validateTemplate checks that a template doesn't set values which must be
+// unique to each VM.
+func (v *VMConfig) validateTemplate() error {
+	if v.UUID != "" {
+		return fmt.Errorf("uuid cannot be set in a template")
+	}
+	if v.RunDir != "" {
+		return fmt.Errorf("run_dir cannot be set in a template")
+	}
+	for _, net := range v.Network {
+		if net.MAC != "" {
+			return fmt.Errorf("network mac cannot be set in a template")
+		}
+	}
+	return nil
+}
+
+// inherit returns a copy of base with the values set in v applied over it.
+func (v *VMConfig) inherit(base *VMConfig) (*VMConfig, error) {
+	appendTo := map[string]bool{}
+	for _, a := range v.Append {
+		switch a {
+		case "network", "hdd", "cdrom", "share", "forward", "allow":
+			appendTo[a] = true
+		default:
+			return nil, fmt.Errorf("append of %s not supported: network, hdd, cdrom, share, forward, and allow are supported", a)
+		}
+	}
+
+	m := base.clone()
+	m.Extends = ""
+	m.Append = nil
+
+	if v.Count != 0 {
+		m.Count = v.Count
+	}
+	if v.Memory != 0 {
+		m.Memory = v.Memory
+	}
+	if v.Cores != 0 {
+		m.Cores = v.Cores
+	}
+	if v.UUID != "" {
+		m.UUID = v.UUID
+	}
+	if v.SSHKey != "" {
+		m.SSHKey = v.SSHKey
+	}
+	if v.ProvisionPre != "" {
+		m.ProvisionPre = v.ProvisionPre
+	}
+	if v.ProvisionPost != "" {
+		m.ProvisionPost = v.ProvisionPost
+	}
+	if v.RunDir != "" {
+		m.RunDir = v.RunDir
+	}
+	if v.Vsock != nil {
+		vsock := *v.Vsock
+		m.Vsock = &vsock
+	}
+	if v.VsockCID != 0 {
+		m.VsockCID = v.VsockCID
+	}
+	if v.Before != nil {
+		m.Before = append([]string{}, v.Before...)
+	}
+	if v.After != nil {
+		m.After = append([]string{}, v.After...)
+	}
+	if v.Requires != nil {
+		m.Requires = append([]string{}, v.Requires...)
+	}
+	if (Boot{}) != v.Boot {
+		m.Boot = v.Boot.clone()
+	}
+
+	c := v.clone()
+	if c.Network != nil {
+		if appendTo["network"] {
+			m.Network = append(m.Network, c.Network...)
+		} else {
+			m.Network = c.Network
+		}
+	}
+	if c.HDD != nil {
+		if appendTo["hdd"] {
+			m.HDD = append(m.HDD, c.HDD...)
+		} else {
+			m.HDD = c.HDD
+		}
+	}
+	if c.CDROM != nil {
+		if appendTo["cdrom"] {
+			m.CDROM = append(m.CDROM, c.CDROM...)
+		} else {
+			m.CDROM = c.CDROM
+		}
+	}
+	if c.Share != nil {
+		if appendTo["share"] {
+			m.Share = append(m.Share, c.Share...)
+		} else {
+			m.Share = c.Share
+		}
+	}
+	if c.Forward != nil {
+		if appendTo["forward"] {
+			m.Forward = append(m.Forward, c.Forward...)
+		} else {
+			m.Forward = c.Forward
+		}
+	}
+	if c.Allow != nil {
+		if appendTo["allow"] {
+			m.Allow = append(m.Allow, c.Allow...)
+		} else {
+			m.Allow = c.Allow
+		}
+	}
+
+	return m, nil
+}
+
+// clone returns a deep copy of a VMConfig, so that VMs sharing a template
+// don't share network, hdd, cdrom, share, forward, or allow entries.
+func (v *VMConfig) clone() *VMConfig {
+	c := *v
+
+	if v.Before != nil {
+		c.Before = append([]string{}, v.Before...)
+	}
+	if v.After != nil {
+		c.After = append([]string{}, v.After...)
+	}
+	if v.Requires != nil {
+		c.Requires = append([]string{}, v.Requires...)
+	}
+	if v.Append != nil {
+		c.Append = append([]string{}, v.Append...)
+	}
+	if v.Vsock != nil {
+		vsock := *v.Vsock
+		c.Vsock = &vsock
+	}
+	if v.Network != nil {
+		c.Network = make([]*NetConf, len(v.Network))
+		for i, net := range v.Network {
+			n := *net
+			c.Network[i] = &n
+		}
+	}
+	if v.HDD != nil {
+		c.HDD = make([]*HDD, len(v.HDD))
+		for i, hdd := range v.HDD {
+			h := *hdd
+			c.HDD[i] = &h
+		}
+	}
+	if v.CDROM != nil {
+		c.CDROM = make([]*CDROM, len(v.CDROM))
+		for i, cd := range v.CDROM {
+			cdrom := *cd
+			c.CDROM[i] = &cdrom
+		}
+	}
+	if v.Share != nil {
+		c.Share = make([]*Share, len(v.Share))
+		for i, share := range v.Share {
+			s := *share
+			c.Share[i] = &s
+		}
+	}
+	if v.Forward != nil {
+		c.Forward = make([]*Forward, len(v.Forward))
+		for i, fwd := range v.Forward {
+			f := *fwd
+			c.Forward[i] = &f
+		}
+	}
+	if v.Allow != nil {
+		c.Allow = make([]*Allow, len(v.Allow))
+		for i, allow := range v.Allow {
+			a := *allow
+			a.From = append([]string{}, allow.From...)
+			a.Ports = append([]int{}, allow.Ports...)
+			c.Allow[i] = &a
+		}
+	}
+	c.Boot = v.Boot.clone()
+	return &c
+}
+
+// clone returns a deep copy of a Boot.
+func (b Boot) clone() Boot {
+	if b.Kexec != nil {
+		k := *b.Kexec
+		b.Kexec = &k
+	}
+	if b.Firmware != nil {
+		f := *b.Firmware
+		b.Firmware = &f
+	}
+	if b.FBSD != nil {
+		f := *b.FBSD
+		b.FBSD = &f
+	}
+	return b
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_ExpandTemplates(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		template VM
+		vm       VM
+		want     VM
+		wantErr  bool
+	}{
+		{
+			name: "inherit scalars",
+			template: VM{
+				"base": {Memory: GiB, Cores: 2, Boot: Boot{Kexec: &Kexec{Kernel: "vmlinuz", Initrd: "initrd"}}},
+			},
+			vm: VM{
+				"vm1": {Extends: "base", Cores: 4},
+			},
+			want: VM{
+				"vm1": {Memory: GiB, Cores: 4, Boot: Boot{Kexec: &Kexec{Kernel: "vmlinuz", Initrd: "initrd"}}},
+			},
+		},
+		{
+			name: "vsock inherited",
+			template: VM{
+				"base": {Vsock: &yes},
+			},
+			vm: VM{
+				"vm1": {Extends: "base"},
+			},
+			want: VM{
+				"vm1": {Vsock: &yes},
+			},
+		},
+		{
+			name: "vsock disabled over template",
+			template: VM{
+				"base": {Vsock: &yes},
+			},
+			vm: VM{
+				"vm1": {Extends: "base", Vsock: &no},
+			},
+			want: VM{
+				"vm1": {Vsock: &no},
+			},
+		},
+		{
+			name: "boot method replaced",
+			template: VM{
+				"base": {Boot: Boot{Kexec: &Kexec{Kernel: "vmlinuz", Initrd: "initrd"}}},
+			},
+			vm: VM{
+				"vm1": {Extends: "base", Boot: Boot{Firmware: &Firmware{Path: "UEFI.fd"}}},
+			},
+			want: VM{
+				"vm1": {Boot: Boot{Firmware: &Firmware{Path: "UEFI.fd"}}},
+			},
+		},
+		{
+			name: "chained templates",
+			template: VM{
+				"base":  {Memory: GiB, Cores: 1},
+				"large": {Extends: "base", Memory: 8 * GiB},
+			},
+			vm: VM{
+				"vm1": {Extends: "large"},
+			},
+			want: VM{
+				"vm1": {Memory: 8 * GiB, Cores: 1},
+			},
+		},
+		{
+			name: "replace network",
+			template: VM{
+				"base": {Network: []*NetConf{{Driver: "virtio-net", MemberOf: "net1"}}},
+			},
+			vm: VM{
+				"vm1": {Extends: "base", Network: []*NetConf{{Driver: "virtio-tap", MemberOf: "net2"}}},
+			},
+			want: VM{
+				"vm1": {Network: []*NetConf{{Driver: "virtio-tap", MemberOf: "net2"}}},
+			},
+		},
+		{
+			name: "append network and hdd",
+			template: VM{
+				"base": {
+					Network: []*NetConf{{Driver: "virtio-net", MemberOf: "net1"}},
+					HDD:     []*HDD{{Path: "hdd1.qcow2"}},
+				},
+			},
+			vm: VM{
+				"vm1": {
+					Extends: "base",
+					Append:  []string{"network", "hdd"},
+					Network: []*NetConf{{Driver: "virtio-tap", MemberOf: "net2"}},
+					HDD:     []*HDD{{Path: "hdd2.qcow2"}},
+				},
+			},
+			want: VM{
+				"vm1": {
+					Network: []*NetConf{{Driver: "virtio-net", MemberOf: "net1"}, {Driver: "virtio-tap", MemberOf: "net2"}},
+					HDD:     []*HDD{{Path: "hdd1.qcow2"}, {Path: "hdd2.qcow2"}},
+				},
+			},
+		},
+		{
+			name: "unsupported append",
+			template: VM{
+				"base": {},
+			},
+			vm: VM{
+				"vm1": {Extends: "base", Append: []string{"before"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "template not found",
+			vm: VM{
+				"vm1": {Extends: "base"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "inheritance cycle",
+			template: VM{
+				"a": {Extends: "b"},
+				"b": {Extends: "c"},
+				"c": {Extends: "a"},
+			},
+			vm:      VM{},
+			wantErr: true,
+		},
+		{
+			name: "uuid in template",
+			template: VM{
+				"base": {UUID: "9445CA7C-F976-456E-9061-B932194D8166"},
+			},
+			vm: VM{
+				"vm1": {Extends: "base"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Template: tt.template, VM: tt.vm}
+			err := c.ExpandTemplates()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Config.ExpandTemplates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(c.VM, tt.want) {
+				t.Errorf("Config.ExpandTemplates() = %#v, want %#v", c.VM, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ExpandTemplatesCopies(t *testing.T) {
+	c := &Config{
+		Template: VM{
+			"base": {
+				Network: []*NetConf{{Driver: "virtio-tap", MemberOf: "net1"}},
+				Boot:    Boot{Kexec: &Kexec{Cmdline: "hostname={{.Name}}"}},
+			},
+		},
+		VM: VM{
+			"vm1": {Extends: "base"},
+			"vm2": {Extends: "base"},
+		},
+	}
+	if err := c.ExpandTemplates(); err != nil {
+		t.Fatalf("Config.ExpandTemplates() error = %v", err)
+	}
+	c.VM["vm1"].Network[0].MAC = "AA:BB:CC:DD:00:01"
+	if c.VM["vm2"].Network[0].MAC != "" || c.Template["base"].Network[0].MAC != "" {
+		t.Errorf("Config.ExpandTemplates() network entries are shared between VMs")
+	}
+	c.VM["vm1"].Boot.Kexec.Cmdline = "hostname=vm1"
+	if c.VM["vm2"].Boot.Kexec.Cmdline != "hostname={{.Name}}" {
+		t.Errorf("Config.ExpandTemplates() boot entries are shared between VMs")
+	}
+}
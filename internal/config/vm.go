@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,21 +21,30 @@ var hyperkitPath = "hyperkit"
 type VM map[string]*VMConfig
 
 type VMConfig struct {
-	Memory        string     `toml:"memory"`
-	Cores         int        `toml:"cores"`
-	UUID          string     `toml:"uuid"`
-	SSHKey        string     `toml:"ssh_key"`
-	ProvisionPre  string     `toml:"provision_pre"`
-	ProvisionPost string     `toml:"provision_post"`
-	Before        []string   `toml:"before"`
-	After         []string   `toml:"after"`
-	Requires      []string   `toml:"requires"`
-	RunDir        string     `toml:"run_dir"`
-	Network       []*NetConf `toml:"network"`
-	Boot          Boot       `toml:"boot"`
-	HDD           []*HDD     `toml:"hdd"`
-	CDROM         []*CDROM   `toml:"cdrom"`
-	PID           int
+	Extends       string     `toml:"extends,omitempty" json:"extends,omitempty"`
+	Append        []string   `toml:"append,omitempty" json:"append,omitempty"`
+	Count         int        `toml:"count,omitzero" json:"count,omitempty"`
+	Memory        Size       `toml:"memory,omitzero" json:"memory,omitempty"`
+	Cores         int        `toml:"cores,omitzero" json:"cores,omitempty"`
+	UUID          string     `toml:"uuid,omitempty" json:"uuid,omitempty"`
+	SSHKey        string     `toml:"ssh_key,omitempty" json:"ssh_key,omitempty"`
+	ProvisionPre  string     `toml:"provision_pre,omitempty" json:"provision_pre,omitempty"`
+	ProvisionPost string     `toml:"provision_post,omitempty" json:"provision_post,omitempty"`
+	Before        []string   `toml:"before,omitempty" json:"before,omitempty"`
+	After         []string   `toml:"after,omitempty" json:"after,omitempty"`
+	Requires      []string   `toml:"requires,omitempty" json:"requires,omitempty"`
+	RunDir        string     `toml:"run_dir,omitempty" json:"run_dir,omitempty"`
+	Network       []*NetConf `toml:"network,omitempty" json:"network,omitempty"`
+	Boot          Boot       `toml:"boot" json:"boot"`
+	HDD           []*HDD     `toml:"hdd,omitempty" json:"hdd,omitempty"`
+	CDROM         []*CDROM   `toml:"cdrom,omitempty" json:"cdrom,omitempty"`
+	Share         []*Share   `toml:"share,omitempty" json:"share,omitempty"`
+	Forward       []*Forward `toml:"forward,omitempty" json:"forward,omitempty"`
+	Allow         []*Allow   `toml:"allow,omitempty" json:"allow,omitempty"`
+	Vsock         *bool      `toml:"vsock,omitempty" json:"vsock,omitempty"`
+	VsockCID      int        `toml:"vsock_cid,omitzero" json:"vsock_cid,omitempty"`
+	PID           int        `toml:"-" json:"-"`
+	Replica       *Replica   `toml:"-" json:"-"` // Set when the VM was expanded from a VM definition with a count
 }
 
 // Status is the status of a VM process
@@ -68,13 +78,34 @@ func (v *VMConfig) Up() error {
 		return nil
 	}
 
-	cmdArgs := v.Cli()
+	cmdArgs, err := v.Cli()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("cmd: %s %s\n", hyperkitPath, shellJoin(cmdArgs))
+
+	if v.vsockEnabled() {
+		if err := os.MkdirAll(v.VsockDir(), 0755); err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("cmd: %s %s\n", hyperkitPath, strings.Join(cmdArgs, " "))
+	if err := v.deriveVmnetMACs(); err != nil {
+		return err
+	}
+
+	// When run with sudo, hyperkit runs as the user who ran sudo once the run
+	// dir and tap devices are theirs.
+	if err := v.ChownRunDir(); err != nil {
+		return err
+	}
 
 	cmd := exec.Command(hyperkitPath, cmdArgs...)
-	err := cmd.Start()
-	if err != nil {
+	if cred := v.credential(); cred != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	if err := cmd.Start(); err != nil {
 		return err
 	}
 
@@ -171,7 +202,8 @@ func sigLookup(s string) (syscall.Signal, error) {
 	return sig, nil
 }
 
-func (v *VMConfig) Cli() []string {
+// Cli returns the hyperkit arguments for a VM.
+func (v *VMConfig) Cli() ([]string, error) {
 
 	var args []string
 
@@ -183,40 +215,86 @@ func (v *VMConfig) Cli() []string {
 		args = append(args, "-c", strconv.Itoa(v.Cores))
 	}
 
-	if v.Memory != "" {
-		args = append(args, "-m", v.Memory)
+	if v.Memory != 0 {
+		args = append(args, "-m", v.Memory.Hyperkit())
 	}
 
 	args = append(args, "-A", "-s", "0:0,hostbridge", "-s", "31,lpc", "-s", "1,virtio-rnd")
 
 	if v.RunDir != "" {
-		args = append(args, "-l", fmt.Sprintf("com1,autopty=%s/tty,log=%s/console.log", v.RunDir, v.RunDir))
+		args = append(args, "-l", v.console().Arg())
 	}
 
-	for i, net := range v.Network {
-		var opts string
-		if net.MAC != "" {
-			opts = fmt.Sprintf("mac=%s", net.MAC)
-		}
-		args = append(args, "-s", fmt.Sprintf("2:%d,%s,%s,%s", i, net.Driver, net.Device, opts))
+	devs, err := v.devices()
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range devs {
+		args = append(args, "-s", dev.Arg())
 	}
 
-	for i, hdd := range v.HDD {
-		switch hdd.Format {
-		case "qcow":
-			args = append(args, "-s", fmt.Sprintf("3:%d,%s,file://%s,format=qcow", i, hdd.Driver, hdd.Path))
-		case "raw", "dev", "":
-			args = append(args, "-s", fmt.Sprintf("3:%d,%s,%s", i, hdd.Driver, hdd.Path))
-		}
+	args = append(args, v.Boot.Cli()...)
+
+	return args, nil
+}
+
+// console returns the com1 LPC device, with its tty and log in the run dir.
+func (v *VMConfig) console() LPCDevice {
+	return LPCDevice{
+		Name: "com1",
+		Config: []argField{
+			{Name: "run_dir", Value: fmt.Sprintf("autopty=%s/tty", v.RunDir)},
+			{Name: "run_dir", Value: fmt.Sprintf("log=%s/console.log", v.RunDir)},
+		},
 	}
+}
 
-	for i, cd := range v.CDROM {
-		args = append(args, "-s", fmt.Sprintf("4:%d,%s,%s", i, cd.Driver, cd.Path))
+// devices returns the PCI devices of a VM with their slots allocated.
+func (v *VMConfig) devices() ([]PCIDevice, error) {
+	slots, err := allocatePCISlots(v.slotRequests())
+	if err != nil {
+		return nil, err
 	}
 
-	args = append(args, v.Boot.Cli()...)
+	var devs []PCIDevice
+	for i, net := range v.Network {
+		devs = append(devs, net.device(fmt.Sprintf("network %d", i), slots[len(devs)], v.UUID, i))
+	}
+	for i, hdd := range v.HDD {
+		devs = append(devs, hdd.device(fmt.Sprintf("hdd %d", i), slots[len(devs)]))
+	}
+	for i, cd := range v.CDROM {
+		devs = append(devs, cd.device(fmt.Sprintf("cdrom %d", i), slots[len(devs)]))
+	}
+	for i, share := range v.Share {
+		devs = append(devs, share.device(fmt.Sprintf("share %d", i), slots[len(devs)], v.ShareSocket(i)))
+	}
+	if v.vsockEnabled() {
+		devs = append(devs, v.vsockDevice(slots[len(devs)]))
+	}
+	return devs, nil
+}
 
-	return args
+// slotRequests returns the PCI slot requests of the devices of a VM, in the
+// same order as devices.
+func (v *VMConfig) slotRequests() []slotRequest {
+	var devs []slotRequest
+	for i, net := range v.Network {
+		devs = append(devs, slotRequest{name: fmt.Sprintf("network %d", i), slot: net.Slot})
+	}
+	for i, hdd := range v.HDD {
+		devs = append(devs, slotRequest{name: fmt.Sprintf("hdd %d", i), slot: hdd.Slot})
+	}
+	for i, cd := range v.CDROM {
+		devs = append(devs, slotRequest{name: fmt.Sprintf("cdrom %d", i), slot: cd.Slot})
+	}
+	for i, share := range v.Share {
+		devs = append(devs, slotRequest{name: fmt.Sprintf("share %d", i), slot: share.Slot})
+	}
+	if v.vsockEnabled() {
+		devs = append(devs, slotRequest{name: "vsock"})
+	}
+	return devs
 }
 
 func (v *VMConfig) Validate() error {
@@ -228,18 +306,64 @@ func (v *VMConfig) Validate() error {
 		return errors.New("cores not specified")
 	}
 
-	if v.Memory == "" {
+	if v.Memory == 0 {
 		return errors.New("memory not specified")
 	}
 
+	if err := v.Memory.validate("memory", MinMemory, MaxMemory); err != nil {
+		return err
+	}
+
 	if v.RunDir == "" {
 		return errors.New("RunDir not specified")
 	}
 
+	if err := v.console().Validate(); err != nil {
+		return err
+	}
+
 	if err := v.Boot.validate(); err != nil {
 		return err
 	}
 
+	for _, hdd := range v.HDD {
+		if err := hdd.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := v.validateVsock(); err != nil {
+		return err
+	}
+
+	if err := v.validateForwards(); err != nil {
+		return err
+	}
+
+	tags := map[string]bool{}
+	for i, share := range v.Share {
+		if err := share.validate(); err != nil {
+			return err
+		}
+		if path := v.ShareSocket(i); len(path) > maxUnixSocketPath {
+			return fmt.Errorf("share socket path %s is longer than the %d characters allowed, use a shorter run_dir", path, maxUnixSocketPath)
+		}
+		if tags[share.Tag] {
+			return fmt.Errorf("share tag %s is used more than once", share.Tag)
+		}
+		tags[share.Tag] = true
+	}
+
+	devs, err := v.devices()
+	if err != nil {
+		return err
+	}
+	for _, dev := range devs {
+		if err := dev.Validate(); err != nil {
+			return err
+		}
+	}
+
 	// Return here if the VM is already running, as the remaining checks will fail with a running VM.
 	if v.Status() == Running {
 		return nil
@@ -254,12 +378,14 @@ func (v *VMConfig) Validate() error {
 	return nil
 }
 
-func (v *VMConfig) defaults(configDir string, name string) error {
+// defaults sets the run dir, UUID, and MACs of a VM. Generated values are
+// stored in the run dir, created if needed, unless persist is false.
+func (v *VMConfig) defaults(configDir string, name string, persist bool) error {
 	if v.RunDir == "" {
 		v.RunDir = filepath.Join(configDir, ".run/vm/", name)
 	}
 
-	if !dirExists(v.RunDir) {
+	if persist && !dirExists(v.RunDir) {
 		err := os.MkdirAll(v.RunDir, 0755)
 		if err != nil {
 			return err
@@ -271,26 +397,50 @@ func (v *VMConfig) defaults(configDir string, name string) error {
 
 		if err != nil {
 			UUID = uuid.New()
-			w, err := os.Create(v.RunDir + "/uuid")
-			if err != nil {
-				return err
-			}
+			if persist {
+				w, err := os.Create(v.RunDir + "/uuid")
+				if err != nil {
+					return err
+				}
 
-			defer w.Close()
+				defer w.Close()
 
-			if _, err := w.WriteString(UUID.String()); err != nil {
-				return err
+				if _, err := w.WriteString(UUID.String()); err != nil {
+					return err
+				}
 			}
 		}
 		v.UUID = UUID.String()
 	}
 
 	for _, net := range v.Network {
-		if err := net.defaults(v.RunDir); err != nil {
+		if err := net.defaults(v.RunDir, persist); err != nil {
 			return err
 		}
 	}
 
+	for _, share := range v.Share {
+		share.defaults()
+	}
+
+	return nil
+}
+
+// renderCmdline executes the kexec cmdline as a template. It's run once
+// network addresses have been allocated, so they're available to it.
+func (v *VMConfig) renderCmdline(name string, domain string) error {
+	if v.Boot.Kexec != nil && v.Boot.Kexec.Cmdline != "" {
+		data := templateData{Name: name, Domain: domain, Network: v.Network, Shares: v.Share}
+		if v.Replica != nil {
+			data.Replica = *v.Replica
+		}
+		cmdline, err := renderTemplate("cmdline", v.Boot.Kexec.Cmdline, data)
+		if err != nil {
+			return err
+		}
+		v.Boot.Kexec.Cmdline = cmdline
+	}
+
 	return nil
 }
 
@@ -306,41 +456,55 @@ func (v *VMConfig) updateRelativePaths(configDir string, name string) {
 	for i := range v.CDROM {
 		v.CDROM[i].updateRelativePaths(v.RunDir)
 	}
+	for i := range v.Share {
+		v.Share[i].updateRelativePaths(configDir)
+	}
 }
 
 // Boot config
 type Boot struct {
-	Kexec    Kexec    `toml:"kexec"`
-	Firmware Firmware `toml:"firmware"`
-	FBSD     FBSD     `toml:"fbsd"`
+	Kexec    *Kexec    `toml:"kexec" json:"kexec,omitempty"`
+	Firmware *Firmware `toml:"firmware" json:"firmware,omitempty"`
+	FBSD     *FBSD     `toml:"fbsd" json:"fbsd,omitempty"`
 }
 
 func (b *Boot) Cli() []string {
-	if (Kexec{}) != b.Kexec {
-		return b.Kexec.Cli()
-	}
-
-	if (Firmware{}) != b.Firmware {
-		return b.Firmware.Cli()
+	if opt := b.option(); opt != nil {
+		return []string{"-f", opt.Arg()}
 	}
+	return []string{}
+}
 
-	if (FBSD{}) != b.FBSD {
-		return b.FBSD.Cli()
+// option returns the boot method as a hyperkit boot option, or nil if no boot
+// method is set.
+func (b *Boot) option() *BootOption {
+	switch {
+	case b.Kexec != nil:
+		return b.Kexec.option()
+	case b.Firmware != nil:
+		return b.Firmware.option()
+	case b.FBSD != nil:
+		return b.FBSD.option()
 	}
-
-	return []string{}
+	return nil
 }
 
 func (b *Boot) validate() error {
-	if (Kexec{}) != b.Kexec {
+	if opt := b.option(); opt != nil {
+		if err := opt.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if b.Kexec != nil {
 		return b.Kexec.Validate()
 	}
 
-	if (Firmware{}) != b.Firmware {
+	if b.Firmware != nil {
 		return b.Firmware.Validate()
 	}
 
-	if (FBSD{}) != b.FBSD {
+	if b.FBSD != nil {
 		return b.FBSD.Validate()
 	}
 
@@ -348,27 +512,31 @@ func (b *Boot) validate() error {
 }
 
 func (b *Boot) updateRelativePaths(configDir string) {
-	if (Kexec{}) != b.Kexec {
+	if b.Kexec != nil {
 		b.Kexec.updateRelativePaths(configDir)
 	}
 
-	if (Firmware{}) != b.Firmware {
+	if b.Firmware != nil {
 		b.Firmware.updateRelativePaths(configDir)
 	}
 
-	if (FBSD{}) != b.FBSD {
+	if b.FBSD != nil {
 		b.FBSD.updateRelativePaths(configDir)
 	}
 }
 
 type Kexec struct {
-	Kernel  string `toml:"kernel"`
-	Initrd  string `toml:"initrd"`
-	Cmdline string `toml:"cmdline"`
+	Kernel  string `toml:"kernel" json:"kernel"`
+	Initrd  string `toml:"initrd" json:"initrd"`
+	Cmdline string `toml:"cmdline,omitempty" json:"cmdline,omitempty"`
 }
 
-func (k *Kexec) Cli() []string {
-	return []string{"-f", fmt.Sprintf("kexec,%s,%s,%s", k.Kernel, k.Initrd, k.Cmdline)}
+func (k *Kexec) option() *BootOption {
+	return &BootOption{Method: "kexec", Fields: [3]argField{
+		{Name: "kernel", Value: k.Kernel},
+		{Name: "initrd", Value: k.Initrd},
+		{Name: "cmdline", Value: k.Cmdline},
+	}}
 }
 
 func (k *Kexec) Validate() error {
@@ -394,11 +562,13 @@ func (k *Kexec) updateRelativePaths(configDir string) {
 }
 
 type Firmware struct {
-	Path string `toml:"path"`
+	Path string `toml:"path" json:"path"`
 }
 
-func (f *Firmware) Cli() []string {
-	return []string{"-f", fmt.Sprintf("bootrom,%s,,", f.Path)}
+func (f *Firmware) option() *BootOption {
+	return &BootOption{Method: "bootrom", Fields: [3]argField{
+		{Name: "path", Value: f.Path},
+	}}
 }
 
 //Validate for Firmware is currently a noop, TODO.
@@ -413,13 +583,17 @@ func (f *Firmware) updateRelativePaths(configDir string) {
 }
 
 type FBSD struct {
-	Userboot   string `toml:"userboot"`
-	BootVolume string `toml:"userboot"`
-	KernelEnv  string `toml:"kernelenv"`
+	Userboot   string `toml:"userboot" json:"userboot"`
+	BootVolume string `toml:"bootvolume" json:"bootvolume"`
+	KernelEnv  string `toml:"kernelenv,omitempty" json:"kernelenv,omitempty"`
 }
 
-func (f *FBSD) Cli() []string {
-	return []string{"-f", fmt.Sprintf("fbsd,%s,%s,%s", f.Userboot, f.BootVolume, f.KernelEnv)}
+func (f *FBSD) option() *BootOption {
+	return &BootOption{Method: "fbsd", Fields: [3]argField{
+		{Name: "userboot", Value: f.Userboot},
+		{Name: "bootvolume", Value: f.BootVolume},
+		{Name: "kernelenv", Value: f.KernelEnv},
+	}}
 }
 
 //Validate for FBSD is currently a noop, TODO.
@@ -432,22 +606,50 @@ func (f *FBSD) updateRelativePaths(configDir string) {
 		f.Userboot = filepath.Join(configDir, f.Userboot)
 	}
 
-	if f.Userboot[:1] != "/" {
+	if f.BootVolume[:1] != "/" {
 		f.BootVolume = filepath.Join(configDir, f.BootVolume)
 	}
 }
 
+// validIP reports if s is an IPv4 or IPv6 address, with or without a prefix
+// length, eg. 192.168.99.2/24 or fd00:99::2.
+func validIP(s string) bool {
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
+
 // NetConf is a VM network configuration
 type NetConf struct {
-	IP       string `toml:"ip"`
-	MAC      string `toml:"mac"`
-	Device   string `toml:"device"`
-	Driver   string `toml:"driver"`
-	MemberOf string `toml:"memberOf"`
+	IP       string `toml:"ip,omitempty" json:"ip,omitempty"`
+	MAC      string `toml:"mac,omitempty" json:"mac,omitempty"`
+	Device   string `toml:"device,omitempty" json:"device,omitempty"`
+	Driver   string `toml:"driver" json:"driver"`
+	MemberOf string `toml:"memberOf,omitempty" json:"memberOf,omitempty"`
+	Slot     string `toml:"slot,omitempty" json:"slot,omitempty"`
+
+	Bandwidth string `toml:"bandwidth,omitempty" json:"bandwidth,omitempty"` // eg. "10Mbit"
+	Delay     string `toml:"delay,omitempty" json:"delay,omitempty"`         // eg. "50ms"
+	Loss      string `toml:"loss,omitempty" json:"loss,omitempty"`           // eg. "1%"
+
+	DiscoveredIP string `toml:"-" json:"-"` // Set by DiscoverIPs when IP isn't configured
+	Gateway      string `toml:"-" json:"-"` // Address of the host on vmnet networks
+	DNS          string `toml:"-" json:"-"` // Address of the DNS server on tap networks, when dns is configured
+	VmnetMAC     string `toml:"-" json:"-"` // MAC vmnet derives from the VM UUID when MAC isn't configured, set by Up
 }
 
 func (n *NetConf) validate() error {
 
+	if n.IP != "" && !validIP(n.IP) {
+		return fmt.Errorf("could not parse IP address: %s, expected IPv4 or IPv6 with an optional prefix length", n.IP)
+	}
+
+	if _, err := n.shaping(); err != nil {
+		return err
+	}
+
 	switch n.Driver {
 
 	case "virtio-tap":
@@ -455,7 +657,7 @@ func (n *NetConf) validate() error {
 			return errors.New("interface type tap requires a MAC address to be specified")
 		}
 		if n.Device == "" {
-			return errors.New("interface type tap requires a Device to be specified")
+			return errors.New("interface type tap requires a Device, and no free tap device was found to use")
 		}
 		dev, err := os.OpenFile(n.devicePath(), os.O_WRONLY, 0666)
 		dev.Close()
@@ -470,7 +672,15 @@ func (n *NetConf) validate() error {
 		}
 
 	case "virtio-vpnkit":
-		return errors.New("virtio-vpnkit support is not yet implemented")
+		if n.MAC != "" {
+			return errors.New("interface type vpnkit is assigned a MAC address by vpnkit, mac cannot be specified")
+		}
+		if n.Device == "" {
+			return fmt.Errorf("interface type vpnkit requires memberOf to be a vpnkit network or a Device socket to be specified")
+		}
+		if len(n.Device) > maxUnixSocketPath {
+			return fmt.Errorf("vpnkit socket path %s is longer than the %d characters allowed, use a shorter run_dir", n.Device, maxUnixSocketPath)
+		}
 
 	default:
 		return fmt.Errorf("network driver %s not supported: drivers virtio-tap, virtio-net, and virtio-vpnkit are supported", n.Driver)
@@ -478,7 +688,30 @@ func (n *NetConf) validate() error {
 	return nil
 }
 
-func (n *NetConf) defaults(runDir string) error {
+// device returns the PCI device of the interface. vmUUID and the index of the
+// interface seed the UUID of vpnkit interfaces, which vpnkit uses to assign
+// the same MAC and IP address each time the VM boots, and which differs
+// between interfaces of the VM on the same network.
+func (n *NetConf) device(name string, slot PCISlot, vmUUID string, index int) PCIDevice {
+	if n.Driver == "virtio-vpnkit" {
+		id := uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("%s/%s/%d", vmUUID, n.MemberOf, index)))
+		return PCIDevice{Name: name, Slot: slot, Emulation: n.Driver, Config: []argField{
+			{Name: "device", Value: "path=" + n.Device},
+			{Name: "uuid", Value: "uuid=" + id.String()},
+		}}
+	}
+
+	var opts string
+	if n.MAC != "" {
+		opts = fmt.Sprintf("mac=%s", n.MAC)
+	}
+	return PCIDevice{Name: name, Slot: slot, Emulation: n.Driver, Config: []argField{
+		{Name: "device", Value: n.Device},
+		{Name: "mac", Value: opts},
+	}}
+}
+
+func (n *NetConf) defaults(runDir string, persist bool) error {
 	switch n.Driver {
 
 	case "virtio-tap":
@@ -491,23 +724,42 @@ func (n *NetConf) defaults(runDir string) error {
 					return err
 				}
 
-				w, err := os.Create(runDir + "/" + n.MemberOf + "_mac")
-				if err != nil {
-					return err
-				}
+				if persist {
+					w, err := os.Create(runDir + "/" + n.MemberOf + "_mac")
+					if err != nil {
+						return err
+					}
 
-				defer w.Close()
+					defer w.Close()
 
-				if _, err := w.WriteString(MAC.String()); err != nil {
-					return err
+					if _, err := w.WriteString(MAC.String()); err != nil {
+						return err
+					}
 				}
 			}
 			n.MAC = MAC.String()
 		}
+
+	case "virtio-net":
+		// vmnet assigns the MAC, which Up records once it's known.
+		if n.MAC == "" {
+			if MAC, err := hwaddrFile(n.vmnetMACFile(runDir)); err == nil {
+				n.VmnetMAC = MAC.String()
+			}
+		}
 	}
 	return nil
 }
 
+// HardwareAddr returns the configured MAC of the interface, or else the MAC
+// vmnet derives for it, if known.
+func (n *NetConf) HardwareAddr() string {
+	if n.MAC != "" {
+		return n.MAC
+	}
+	return n.VmnetMAC
+}
+
 func (n *NetConf) devicePath() string {
 	if n.Device[:1] == "/" {
 		return n.Device
@@ -516,17 +768,43 @@ func (n *NetConf) devicePath() string {
 }
 
 type HDD struct {
-	Path   string
-	Format string
-	Driver string
-	Size   string
-	Create bool
+	Path   string `toml:"path" json:"path"`
+	Format string `toml:"format,omitempty" json:"format,omitempty"`
+	Driver string `toml:"driver" json:"driver"`
+	Size   Size   `toml:"size,omitzero" json:"size,omitempty"`
+	Create bool   `toml:"create,omitempty" json:"create,omitempty"`
+	Slot   string `toml:"slot,omitempty" json:"slot,omitempty"`
 }
 
 func (h *HDD) create() error {
 	return nil
 }
 
+func (h *HDD) validate() error {
+	switch h.Format {
+	case "qcow", "raw", "dev", "":
+	default:
+		return fmt.Errorf("hdd %s format %s not supported: qcow, raw, and dev are supported", h.Path, h.Format)
+	}
+
+	if h.Size != 0 {
+		return h.Size.validate(fmt.Sprintf("hdd %s size", h.Path), MinDiskSize, MaxDiskSize)
+	}
+	return nil
+}
+
+func (h *HDD) device(name string, slot PCISlot) PCIDevice {
+	if h.Format == "qcow" {
+		return PCIDevice{Name: name, Slot: slot, Emulation: h.Driver, Config: []argField{
+			{Name: "path", Value: "file://" + h.Path},
+			{Name: "format", Value: "format=qcow"},
+		}}
+	}
+	return PCIDevice{Name: name, Slot: slot, Emulation: h.Driver, Config: []argField{
+		{Name: "path", Value: h.Path},
+	}}
+}
+
 func (h *HDD) updateRelativePaths(runDir string) {
 	if h.Path[:1] != "/" {
 		h.Path = filepath.Join(runDir, h.Path)
@@ -534,9 +812,16 @@ func (h *HDD) updateRelativePaths(runDir string) {
 }
 
 type CDROM struct {
-	Path    string
-	Driver  string
-	Extract bool
+	Path    string `toml:"path" json:"path"`
+	Driver  string `toml:"driver" json:"driver"`
+	Extract bool   `toml:"extract,omitempty" json:"extract,omitempty"`
+	Slot    string `toml:"slot,omitempty" json:"slot,omitempty"`
+}
+
+func (c *CDROM) device(name string, slot PCISlot) PCIDevice {
+	return PCIDevice{Name: name, Slot: slot, Emulation: c.Driver, Config: []argField{
+		{Name: "path", Value: c.Path},
+	}}
 }
 
 func (c *CDROM) updateRelativePaths(runDir string) {
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vmnetMACOutput runs hyperkit to print the MAC vmnet derives from a VM UUID,
+// which requires root as vmnet does.
+var vmnetMACOutput = func(vmUUID string) (string, error) {
+	out, err := exec.Command(hyperkitPath, "-M", "-U", vmUUID, "-s", "0:0,hostbridge", "-s", "31,lpc", "-s", "2:0,virtio-net").Output()
+	return string(out), err
+}
+
+// vmnetMACFile is where the MAC vmnet derives for the interface is recorded.
+func (n *NetConf) vmnetMACFile(runDir string) string {
+	return filepath.Join(runDir, n.MemberOf+"_vmnet_mac")
+}
+
+// deriveVmnetMACs sets the MAC of vmnet interfaces without a configured one
+// to the MAC vmnet derives from the VM UUID, and records it in the run dir,
+// so the addresses of the interfaces can be discovered by their MAC.
+func (v *VMConfig) deriveVmnetMACs() error {
+	var mac net.HardwareAddr
+	for _, n := range v.Network {
+		if n.Driver != "virtio-net" || n.MAC != "" {
+			continue
+		}
+		if mac == nil {
+			out, err := vmnetMACOutput(v.UUID)
+			if err != nil {
+				return fmt.Errorf("getting the vmnet MAC of %s, %v", v.UUID, err)
+			}
+			if mac, err = parseVmnetMAC(out); err != nil {
+				return fmt.Errorf("getting the vmnet MAC of %s, %v", v.UUID, err)
+			}
+		}
+		n.VmnetMAC = mac.String()
+		if err := ioutil.WriteFile(n.vmnetMACFile(v.RunDir), []byte(n.VmnetMAC), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseVmnetMAC parses the output of hyperkit -M, eg. "MAC: 5a:94:ef:e4:0c:ee".
+func parseVmnetMAC(out string) (net.HardwareAddr, error) {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "MAC:") {
+			return net.ParseMAC(strings.TrimSpace(strings.TrimPrefix(line, "MAC:")))
+		}
+	}
+	return nil, fmt.Errorf("no MAC in hyperkit output: %q", out)
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+)
+
+// defaultVsockCID is the guest CID used when vsock_cid isn't set. CIDs 0 to 2
+// are reserved, with 2 being the host.
+const defaultVsockCID = 3
+
+// maxUnixSocketPath is the maximum length of a unix socket path on macOS.
+const maxUnixSocketPath = 103
+
+// VsockDir is the directory where hyperkit creates the host side unix sockets
+// of the virtio-sock device of a VM.
+func (v *VMConfig) VsockDir() string {
+	return filepath.Join(v.RunDir, "vsock")
+}
+
+// DialVsock connects to port in the guest through the host side socket of the
+// virtio-sock device. hyperkit listens on the connect socket in VsockDir and
+// expects the guest CID and port, in hex, as the first line.
+func (v *VMConfig) DialVsock(port uint32) (net.Conn, error) {
+	if !v.vsockEnabled() {
+		return nil, fmt.Errorf("vsock is not enabled")
+	}
+
+	conn, err := net.Dial("unix", filepath.Join(v.VsockDir(), "connect"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "%08x.%08x\n", v.vsockCID(), port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// vsockEnabled reports whether vsock is set to true. vsock is a pointer so
+// that a VM can set it to false over a template setting it to true.
+func (v *VMConfig) vsockEnabled() bool {
+	return v.Vsock != nil && *v.Vsock
+}
+
+func (v *VMConfig) vsockCID() int {
+	if v.VsockCID == 0 {
+		return defaultVsockCID
+	}
+	return v.VsockCID
+}
+
+func (v *VMConfig) validateVsock() error {
+	if !v.vsockEnabled() {
+		if v.VsockCID != 0 {
+			return fmt.Errorf("vsock_cid is set but vsock is not enabled")
+		}
+		return nil
+	}
+	if cid := v.vsockCID(); cid < 3 || int64(cid) >= 0xffffffff {
+		return fmt.Errorf("vsock_cid %d is invalid, use a CID between 3 and 4294967294", cid)
+	}
+	if path := filepath.Join(v.VsockDir(), "connect"); len(path) > maxUnixSocketPath {
+		return fmt.Errorf("vsock socket path %s is longer than the %d characters allowed, use a shorter run_dir", path, maxUnixSocketPath)
+	}
+	return nil
+}
+
+func (v *VMConfig) vsockDevice(slot PCISlot) PCIDevice {
+	return PCIDevice{Name: "vsock", Slot: slot, Emulation: "virtio-sock", Config: []argField{
+		{Name: "vsock_cid", Value: fmt.Sprintf("guest_cid=%d", v.vsockCID())},
+		{Name: "run_dir", Value: "path=" + v.VsockDir()},
+	}}
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+// Daemon is a hidden hkmgr subcommand run in the background, eg. "forward
+// <name>" serving the port forwards of a VM.
+type Daemon struct {
+	Args       []string            // Subcommand and its arguments
+	PidFile    string              // Written with the PID of the process
+	LogFile    string              // Output is appended to it
+	Credential *syscall.Credential // Runs the process as root if nil
+
+	// Ready reports when the process is ready, eg. listening. If nil, the
+	// process is ready once it hasn't exited right away, as it does on
+	// errors like a port in use.
+	Ready func() bool
+}
+
+// readyTimeout is how long Start waits for Ready.
+var readyTimeout = 10 * time.Second
+
+// Start runs "hkmgr <args>" with the configuration files of cfg in its own
+// session, so it outlives hkmgr, and waits for it to be ready.
+func Start(cfg *config.Config, d Daemon) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	for _, f := range cfg.Files {
+		args = append(args, "-c", f)
+	}
+	args = append(args, d.Args...)
+
+	for _, path := range []string{d.PidFile, d.LogFile} {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	log, err := os.OpenFile(d.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Credential: d.Credential}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(d.PidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	if d.Ready == nil {
+		select {
+		case err := <-exited:
+			os.Remove(d.PidFile)
+			return fmt.Errorf("hkmgr %s exited, %v, see %s", d.Args[0], err, d.LogFile)
+		case <-time.After(500 * time.Millisecond):
+		}
+		return nil
+	}
+
+	timeout := time.After(readyTimeout)
+	for {
+		select {
+		case err := <-exited:
+			os.Remove(d.PidFile)
+			return fmt.Errorf("hkmgr %s exited, %v, see %s", d.Args[0], err, d.LogFile)
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for hkmgr %s, see %s", d.Args[0], d.LogFile)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if d.Ready() {
+			return nil
+		}
+	}
+}
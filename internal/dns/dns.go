@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/config"
+	"github.com/bensallen/hkmgr/internal/daemon"
+)
+
+// pollInterval is how often the addresses of VMs are discovered again.
+var pollInterval = 5 * time.Second
+
+// Run serves the names of the VMs in the configuration until a SIGTERM or
+// SIGINT is received. It's run in the background by Start.
+func Run(cfg *config.Config) error {
+	s, err := cfg.DNSServer()
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("dns is not configured")
+	}
+
+	discover := func() {
+		for _, vm := range cfg.VM {
+			vm.DiscoverIPs()
+		}
+		s.SetRecords(cfg.DNSRecords())
+	}
+	discover()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sig:
+				s.Close()
+				return
+			case <-ticker.C:
+				discover()
+			}
+		}
+	}()
+
+	return s.ListenAndServe()
+}
+
+// Start runs "hkmgr dns" in the background to serve the names of VMs,
+// replacing any previous DNS server, and points the resolver of the host at
+// it. Output is logged to dns.log next to the pid file.
+func Start(cfg *config.Config) error {
+	if err := cfg.StopDNS(); err != nil {
+		return err
+	}
+	resolver, err := cfg.Resolver()
+	if err != nil {
+		return err
+	}
+
+	// Listen errors, eg. another DNS server on the port, make the process
+	// exit right away.
+	err = daemon.Start(cfg, daemon.Daemon{
+		Args:    []string{"dns"},
+		PidFile: cfg.DNSPidFile(),
+		LogFile: filepath.Join(filepath.Dir(cfg.DNSPidFile()), "dns.log"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return resolver.Up()
+}
+
+// Stop stops the DNS server, and removes the resolver of the host.
+func Stop(cfg *config.Config) error {
+	if err := cfg.StopDNS(); err != nil {
+		return err
+	}
+	resolver, err := cfg.Resolver()
+	if err != nil || resolver == nil {
+		return err
+	}
+	return resolver.Down()
+}
@@ -4,26 +4,76 @@ import (
 	"fmt"
 
 	"github.com/bensallen/hkmgr/internal/config"
+	"github.com/bensallen/hkmgr/internal/dns"
+	"github.com/bensallen/hkmgr/internal/network"
 )
 
-// Run stops all VMs or the specific VMs passed as "name".
-func Run(cfg *config.Config, name string, signal string) error {
+// Run stops all VMs or the specific VMs passed as "name". A name may also
+// refer to all replicas of a VM with a count. With networks, vpnkit networks
+// and the DNS server are stopped, and changes made to the host by up are
+// reverted as well.
+func Run(cfg *config.Config, name string, signal string, networks bool) error {
+	vms := cfg.VM
 	if name != "" {
-		if vm, ok := cfg.VM[name]; ok {
-			err := vm.Down(signal)
-			if err != nil {
-				fmt.Printf("Stopping VM failed, %v\n", err)
+		var err error
+		if vms, err = cfg.VM.Select(name); err != nil {
+			return err
+		}
+	}
+
+	for name, vm := range vms {
+		err := vm.Down(signal)
+		if err != nil {
+			fmt.Printf("Stopping VM %s failed, %v\n", name, err)
+		}
+		if err := vm.StopForwards(); err != nil {
+			fmt.Printf("Stopping port forwards of VM %s failed, %v\n", name, err)
+		}
+		if err := vm.StopShares(); err != nil {
+			fmt.Printf("Stopping shares of VM %s failed, %v\n", name, err)
+		}
+		shapings, err := vm.Shapings()
+		if err != nil {
+			fmt.Printf("Removing shaping of VM %s failed, %v\n", name, err)
+		}
+		for _, s := range shapings {
+			if err := s.Down(); err != nil {
+				fmt.Printf("Removing shaping of VM %s failed, %v\n", name, err)
 			}
-		} else {
-			return fmt.Errorf("%s not found in the configuration", name)
-		}
-	} else {
-		for name, vm := range cfg.VM {
-			err := vm.Down(signal)
-			if err != nil {
-				fmt.Printf("Stopping VM %s failed, %v\n", name, err)
+		}
+	}
+
+	// The VMs may not have exited yet, so they're left out explicitly.
+	if cfg.Hosts != nil && cfg.Hosts.Auto {
+		fmt.Printf("Updating hosts file\n")
+		if err := cfg.HostsFile(vms).Sync(); err != nil {
+			fmt.Printf("Updating hosts file failed, %v\n", err)
+		}
+	}
+
+	if !networks {
+		return nil
+	}
+	for netName, netTypes := range cfg.Network {
+		if netTypes.VPNKit != nil {
+			fmt.Printf("Stopping Network: %#v\n", netName)
+			if err := netTypes.VPNKit.Destroy(); err != nil {
+				fmt.Printf("Stopping network %s failed, %v\n", netName, err)
 			}
 		}
 	}
-	return nil
+	if cfg.DNS != nil {
+		fmt.Printf("Stopping DNS server\n")
+		if err := dns.Stop(cfg); err != nil {
+			fmt.Printf("Stopping dns server failed, %v\n", err)
+		}
+	}
+	fmt.Printf("Reverting Host Configuration\n")
+	host, err := cfg.HostConfig()
+	if err != nil {
+		// Changes are reverted from the record of them, which doesn't
+		// depend on the configuration.
+		host = &network.HostConfig{StateFile: cfg.HostStateFile()}
+	}
+	return host.Revert()
 }
@@ -0,0 +1,80 @@
+package forward
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/config"
+	"github.com/bensallen/hkmgr/internal/daemon"
+	"github.com/bensallen/hkmgr/internal/network"
+)
+
+// pollInterval is how often the VM is checked to still be running.
+var pollInterval = 5 * time.Second
+
+// Run serves the port forwards of VM "name" until the VM stops or a SIGTERM
+// or SIGINT is received. It's run in the background by Start.
+func Run(cfg *config.Config, name string) error {
+	vm, ok := cfg.VM[name]
+	if !ok {
+		return fmt.Errorf("%s not found in the configuration", name)
+	}
+
+	// Guests getting their address by DHCP may not have one yet.
+	vm.DiscoverIPs()
+	fwds, err := vm.Forwards()
+	for err != nil {
+		time.Sleep(pollInterval)
+		if vm.Status() != config.Running {
+			return err
+		}
+		vm.DiscoverIPs()
+		fwds, err = vm.Forwards()
+	}
+
+	p := &network.Proxy{Forwards: fwds}
+	if err := p.Listen(); err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sig:
+				p.Close()
+				return
+			case <-ticker.C:
+				if vm.Status() != config.Running {
+					p.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	p.Serve()
+	return nil
+}
+
+// Start runs "hkmgr forward <name>" in the background to serve the port
+// forwards of a VM, replacing any previous forward process of the VM. Output
+// is logged to forward.log in the run dir.
+func Start(cfg *config.Config, name string, vm *config.VMConfig) error {
+	if err := vm.StopForwards(); err != nil {
+		return err
+	}
+	// Listen errors, eg. a host port in use, make the process exit right away.
+	return daemon.Start(cfg, daemon.Daemon{
+		Args:    []string{"forward", name},
+		PidFile: vm.ForwardPidFile(),
+		LogFile: filepath.Join(vm.RunDir, "forward.log"),
+	})
+}
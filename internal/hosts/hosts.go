@@ -0,0 +1,15 @@
+package hosts
+
+import (
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+// Run syncs the block of the configuration in the hosts file, or in file if
+// given, with the names and IPs of the running VMs.
+func Run(cfg *config.Config, file string) error {
+	h := cfg.HostsFile(nil)
+	if file != "" {
+		h.Path = file
+	}
+	return h.Sync()
+}
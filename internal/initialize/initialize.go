@@ -0,0 +1,353 @@
+package initialize
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+// Options are the values used to generate a hkmgr.toml. Unset values are
+// prompted for when running interactively, otherwise defaults are used.
+type Options struct {
+	Name       string
+	Cores      int
+	Memory     string
+	Boot       string // kexec, firmware, or fbsd
+	Kernel     string
+	Initrd     string
+	Cmdline    string
+	Firmware   string
+	Userboot   string
+	BootVolume string
+	KernelEnv  string
+	Net        string // vmnet or tap
+	Bridge     string
+	Subnet     string
+	Device     string // Chosen when the config is loaded if not set
+}
+
+// Run writes a commented hkmgr.toml to path using opts, prompting for unset
+// values when interactive is true. An existing file at path is never
+// overwritten.
+func Run(path string, opts *Options, interactive bool) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists, refusing to overwrite it", path)
+	}
+
+	var p *prompter
+	if interactive {
+		p = &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	}
+
+	if err := opts.fill(p); err != nil {
+		return err
+	}
+
+	out, cfg, err := opts.render(path)
+	if err != nil {
+		return err
+	}
+	// The kernel, tap devices, or root privileges may not be in place yet,
+	// which shouldn't prevent writing the configuration.
+	for name, vm := range cfg.VM {
+		if err := vm.Validate(); err != nil {
+			fmt.Printf("warning: %s is not valid yet, %v\n", name, err)
+		}
+	}
+
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := w.WriteString(out); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s\n", path)
+	return nil
+}
+
+// IsTerminal reports if f is a terminal, eg. to decide whether to prompt.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// fill sets unset options, prompting for them if p isn't nil, and validates
+// the result.
+func (o *Options) fill(p *prompter) error {
+	p.ask(&o.Name, "VM name", "vm1")
+
+	cores := ""
+	if o.Cores != 0 {
+		cores = strconv.Itoa(o.Cores)
+	}
+	p.ask(&cores, "Cores", "1")
+	var err error
+	if o.Cores, err = strconv.Atoi(cores); err != nil || o.Cores < 1 {
+		return fmt.Errorf("cores must be a number greater than 0: %s", cores)
+	}
+
+	p.ask(&o.Memory, "Memory", "1GiB")
+	mem, err := config.ParseSize(o.Memory)
+	if err != nil {
+		return err
+	}
+	if mem < config.MinMemory || mem > config.MaxMemory {
+		return fmt.Errorf("memory must be between %s and %s", config.MinMemory, config.MaxMemory)
+	}
+
+	p.ask(&o.Boot, "Boot mode (kexec, firmware, fbsd)", "kexec")
+	switch o.Boot {
+	case "kexec":
+		p.ask(&o.Kernel, "Kernel path", "vmlinuz")
+		p.ask(&o.Initrd, "Initrd path", "initrd")
+		p.ask(&o.Cmdline, "Kernel cmdline", "console=ttyS0")
+	case "firmware":
+		p.ask(&o.Firmware, "Firmware path", "UEFI.fd")
+	case "fbsd":
+		p.ask(&o.Userboot, "Userboot path", "userboot.so")
+		p.ask(&o.BootVolume, "Boot volume path", "disk.img")
+		p.ask(&o.KernelEnv, "Kernel environment", "")
+	default:
+		return fmt.Errorf("boot mode %s not supported: kexec, firmware, and fbsd are supported", o.Boot)
+	}
+
+	p.ask(&o.Net, "Network type (vmnet, tap)", "vmnet")
+	switch o.Net {
+	case "vmnet":
+	case "tap":
+		p.ask(&o.Bridge, "Bridge", freeBridge(interfaceNames()))
+		p.ask(&o.Subnet, "Bridge IP and prefix", freeSubnet(interfaceNets()))
+		if _, _, err := net.ParseCIDR(o.Subnet); err != nil {
+			return fmt.Errorf("bridge IP must be in CIDR form, eg. 192.168.99.1/24: %v", err)
+		}
+	default:
+		return fmt.Errorf("network type %s not supported: vmnet and tap are supported", o.Net)
+	}
+
+	return nil
+}
+
+// render generates the hkmgr.toml to be written to path, and loads it as
+// hkmgr does, with defaults set and relative paths resolved against path.
+// Generated values like the UUID aren't stored, so nothing but hkmgr.toml is
+// written by init.
+func (o *Options) render(path string) (string, *config.Config, error) {
+	var buf bytes.Buffer
+	if err := cfgTemplate.Execute(&buf, o); err != nil {
+		return "", nil, err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	cfg := &config.Config{Path: absPath, Files: []string{absPath}, NoPersist: true}
+	if _, err := toml.Decode(buf.String(), cfg); err != nil {
+		return "", nil, fmt.Errorf("generated configuration is invalid: %v", err)
+	}
+	if err := cfg.Load(); err != nil {
+		return "", nil, fmt.Errorf("generated configuration is invalid: %v", err)
+	}
+	return buf.String(), cfg, nil
+}
+
+// prompter asks for values interactively. A nil prompter uses the defaults.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask sets an unset value to the response to a prompt or to def when the
+// response is empty, or when not running interactively.
+func (p *prompter) ask(value *string, question string, def string) {
+	if *value != "" {
+		return
+	}
+	*value = def
+	if p == nil {
+		return
+	}
+
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		*value = line
+	}
+}
+
+// freeBridge returns the lowest numbered bridge device that doesn't already
+// exist. bridge0 is skipped as macOS uses it for Thunderbolt bridging, as are
+// bridge100 and above which are used by vmnet.
+func freeBridge(existing []string) string {
+	used := map[string]bool{}
+	for _, name := range existing {
+		used[name] = true
+	}
+	for i := 1; i < 100; i++ {
+		name := fmt.Sprintf("bridge%d", i)
+		if !used[name] {
+			return name
+		}
+	}
+	return "bridge1"
+}
+
+// freeSubnet returns the first 192.168.N.1/24 address, starting at
+// 192.168.99.1/24, whose subnet doesn't overlap with used.
+func freeSubnet(used []*net.IPNet) string {
+	for i := 99; i < 255; i++ {
+		ip := net.IPv4(192, 168, byte(i), 1)
+		subnet := &net.IPNet{IP: ip.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+
+		overlaps := false
+		for _, u := range used {
+			if subnet.Contains(u.IP) || u.Contains(subnet.IP) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			return ip.String() + "/24"
+		}
+	}
+	return "192.168.99.1/24"
+}
+
+func interfaceNames() []string {
+	var names []string
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+	}
+	return names
+}
+
+func interfaceNets() []*net.IPNet {
+	var nets []*net.IPNet
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// quote formats s as a TOML basic string.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// key formats s as a TOML key, quoting it if it isn't a valid bare key.
+func key(s string) string {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return quote(s)
+		}
+	}
+	if s == "" {
+		return quote(s)
+	}
+	return s
+}
+
+var cfgTemplate = template.Must(template.New("hkmgr.toml").Funcs(template.FuncMap{"quote": quote, "key": key}).Parse(`# hkmgr configuration, see https://github.com/bensallen/hkmgr for details.
+{{- if eq .Net "vmnet"}}
+
+# macOS vmnet shared network, requires running hkmgr as root.
+[network.net1.vmnet]
+{{- else}}
+
+# Bridge with tap interfaces as members, requires tuntaposx.
+[network.net1.tap]
+bridge = {{quote .Bridge}}
+ip = {{quote .Subnet}}
+{{- end}}
+
+[vm.{{key .Name}}]
+# Memory in MiB or with a unit, eg. "512M" or "4GiB".
+memory = {{quote .Memory}}
+cores = {{.Cores}}
+# uuid is generated and stored in the run dir when not set.
+#uuid = ""
+# run_dir defaults to .run/vm/<name> relative to this file.
+#run_dir = ""
+
+[[vm.{{key .Name}}.network]]
+{{- if eq .Net "vmnet"}}
+driver = "virtio-net"
+{{- else}}
+driver = "virtio-tap"
+# device is the lowest free tap device, mac is generated, and ip is allocated
+# from the bridge subnet, and all are stored in the run dir, when not set.
+{{- if .Device}}
+device = {{quote .Device}}
+{{- else}}
+#device = ""
+{{- end}}
+#mac = ""
+#ip = ""
+{{- end}}
+memberOf = "net1"
+
+#[[vm.{{key .Name}}.hdd]]
+#path = "hdd1.img"
+#driver = "virtio-blk"
+#format = "raw"
+#size = "32GiB"
+{{- if eq .Boot "kexec"}}
+
+# Paths are relative to this file.
+[vm.{{key .Name}}.boot.kexec]
+kernel = {{quote .Kernel}}
+initrd = {{quote .Initrd}}
+cmdline = {{quote .Cmdline}}
+{{- else if eq .Boot "firmware"}}
+
+# Paths are relative to this file.
+[vm.{{key .Name}}.boot.firmware]
+path = {{quote .Firmware}}
+{{- else}}
+
+# Paths are relative to this file.
+[vm.{{key .Name}}.boot.fbsd]
+userboot = {{quote .Userboot}}
+bootvolume = {{quote .BootVolume}}
+kernelenv = {{quote .KernelEnv}}
+{{- end}}
+`))
@@ -0,0 +1,127 @@
+package initialize
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+func TestOptions_render(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    func(cfg *config.Config) bool
+		wantErr bool
+	}{
+		{
+			name: "kexec and tap",
+			opts: Options{
+				Name: "vm1", Cores: 2, Memory: "2GiB", Boot: "kexec", Kernel: "vmlinuz", Initrd: "initrd",
+				Cmdline: `console=ttyS0 quote="x"`, Net: "tap", Bridge: "bridge1", Subnet: "192.168.99.1/24", Device: "tap0",
+			},
+			want: func(cfg *config.Config) bool {
+				vm := cfg.VM["vm1"]
+				return vm.Cores == 2 && vm.Memory == 2*config.GiB && vm.UUID != "" &&
+					vm.Boot.Kexec.Kernel == filepath.Join(dir, "vmlinuz") &&
+					vm.Boot.Kexec.Cmdline == `console=ttyS0 quote="x"` &&
+					vm.Network[0].Driver == "virtio-tap" && vm.Network[0].Device == "tap0" && vm.Network[0].MAC != "" &&
+					cfg.Network["net1"].Tap.Bridge == "bridge1" && reflect.DeepEqual(cfg.Network["net1"].Tap.IP, config.IPList{"192.168.99.1/24"})
+			},
+		},
+		{
+			name: "firmware and vmnet",
+			opts: Options{Name: "my vm", Cores: 1, Memory: "512M", Boot: "firmware", Firmware: "UEFI.fd", Net: "vmnet"},
+			want: func(cfg *config.Config) bool {
+				vm := cfg.VM["my vm"]
+				return vm.Boot.Firmware.Path == filepath.Join(dir, "UEFI.fd") && vm.Network[0].Driver == "virtio-net" &&
+					cfg.Network["net1"].Vmnet != nil
+			},
+		},
+		{
+			name: "fbsd",
+			opts: Options{Name: "bsd", Cores: 1, Memory: "1G", Boot: "fbsd", Userboot: "userboot.so", BootVolume: "disk.img", Net: "vmnet"},
+			want: func(cfg *config.Config) bool {
+				boot := cfg.VM["bsd"].Boot.FBSD
+				return boot.Userboot == filepath.Join(dir, "userboot.so") && boot.BootVolume == filepath.Join(dir, "disk.img")
+			},
+		},
+		{
+			name: "invalid cmdline template",
+			opts: Options{
+				Name: "vm1", Cores: 1, Memory: "1GiB", Boot: "kexec", Kernel: "vmlinuz", Initrd: "initrd",
+				Cmdline: "ip={{.IP", Net: "vmnet",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, cfg, err := tt.opts.render(filepath.Join(dir, "hkmgr.toml"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Options.render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !tt.want(cfg) {
+				t.Errorf("Options.render() = %s", out)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".run")); !os.IsNotExist(err) {
+				t.Errorf("Options.render() created the run dir, error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOptions_fill(t *testing.T) {
+	p := &prompter{
+		in:  bufio.NewReader(strings.NewReader("web\n4GiB\nfirmware\n\nvmnet\n")),
+		out: ioutil.Discard,
+	}
+	opts := Options{Cores: 2}
+	if err := opts.fill(p); err != nil {
+		t.Fatalf("Options.fill() error = %v", err)
+	}
+	want := Options{Name: "web", Cores: 2, Memory: "4GiB", Boot: "firmware", Firmware: "UEFI.fd", Net: "vmnet"}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("Options.fill() = %#v, want %#v", opts, want)
+	}
+
+	for _, opts := range []Options{
+		{Boot: "pxe"},
+		{Net: "bridged"},
+		{Memory: "1MiB"},
+		{Net: "tap", Subnet: "192.168.99.1"},
+	} {
+		if err := opts.fill(nil); err == nil {
+			t.Errorf("Options.fill() expected an error for %#v", opts)
+		}
+	}
+}
+
+func Test_freeBridge(t *testing.T) {
+	if got := freeBridge([]string{"lo0", "bridge0", "bridge1", "bridge100"}); got != "bridge2" {
+		t.Errorf("freeBridge() = %s, want bridge2", got)
+	}
+}
+
+func Test_freeSubnet(t *testing.T) {
+	_, used1, _ := net.ParseCIDR("192.168.99.5/24")
+	_, used2, _ := net.ParseCIDR("192.168.100.0/22")
+	if got := freeSubnet([]*net.IPNet{used1, used2}); got != "192.168.104.1/24" {
+		t.Errorf("freeSubnet() = %s, want 192.168.104.1/24", got)
+	}
+}
@@ -5,54 +5,95 @@ import (
 	"fmt"
 	"net"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 //Bridge is a Darwin/BSD network bridge device
 type Bridge struct {
-	Device  string
-	IP      net.IP
-	Netmask net.IPMask
-	Members []string
+	Device        string
+	Addrs         []*net.IPNet // Addresses of the bridge, IP is the address of the bridge rather than the network
+	PruneAddrs    bool         // Remove addresses not in Addrs, except IPv6 link-local addresses
+	Members       []string
+	MemberTimeout time.Duration       // How long to wait for members to appear, DefaultMemberTimeout if 0
+	MTU           int                 // MTU of the bridge and its members, left as is if 0
+	MemberFlags   map[string]bool     // Flags set (true) or cleared (false) on Members, eg. stp, private, learning
+	Flags         []string            // Interface flags parsed from ifconfig, eg. UP
+	MemberState   map[string][]string // Flags of each member parsed from ifconfig, eg. LEARNING
 }
 
-//Up brings the defined bridge interface up in an idempotent fashion
+// memberFlag is the ifconfig option setting a member flag, and the flag
+// shown by ifconfig when it's set.
+type memberFlag struct {
+	option string
+	flag   string
+}
+
+// memberFlags are the supported member flags by name.
+var memberFlags = map[string]memberFlag{
+	"learning": {option: "learn", flag: "LEARNING"},
+	"discover": {option: "discover", flag: "DISCOVER"},
+	"stp":      {option: "stp", flag: "STP"},
+	"edge":     {option: "edge", flag: "EDGE"},
+	"autoedge": {option: "autoedge", flag: "AUTOEDGE"},
+	"ptp":      {option: "ptp", flag: "PTP"},
+	"autoptp":  {option: "autoptp", flag: "AUTOPTP"},
+	"private":  {option: "private", flag: "PRIVATE"},
+}
+
+// IsMemberFlag reports if name is a supported member flag, eg. stp.
+func IsMemberFlag(name string) bool {
+	_, ok := memberFlags[name]
+	return ok
+}
+
+// interfaceMTU returns the MTU of a network interface, or 0 if it doesn't
+// exist.
+var interfaceMTU = func(name string) int {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0
+	}
+	return iface.MTU
+}
+
+// Up brings the defined bridge interface up in an idempotent fashion. If
+// members couldn't be added the bridge is still brought up with the others,
+// and a *MembersError is returned.
 func (b *Bridge) Up() error {
-	bridge, err := findBridge(b.Device)
+	var membersErr error
+	cur, err := findBridge(b.Device)
 	if err != nil {
 		if err := b.create(); err != nil {
 			return err
 		}
-		if err := b.setIP(); err != nil {
-			return err
-		}
-		if err := b.setMembers(nil, false); err != nil {
-			return err
-		}
-		if err := b.setUp(); err != nil {
-			return err
-		}
-	} else {
-		if b.IP.String() != bridge.IP.String() {
-			if err := b.setIP(); err != nil {
-				return err
-			}
-		}
-		if b.Netmask.String() != bridge.Netmask.String() {
-			if err := b.setIP(); err != nil {
-				return err
-			}
-		}
-		if err := b.setMembers(bridge.Members, false); err != nil {
-			return err
+		cur = &Bridge{}
+	}
+
+	if err := b.setAddrs(cur.Addrs); err != nil {
+		return err
+	}
+	// Members must have the MTU of the bridge to be added to it.
+	if err := b.setMTU(cur); err != nil {
+		return err
+	}
+	if membersErr = b.setMembers(cur.Members, false); membersErr != nil {
+		if _, ok := membersErr.(*MembersError); !ok {
+			return membersErr
 		}
+	}
+	if err := b.setMemberFlags(); err != nil {
+		return err
+	}
+	if !contains(cur.Flags, "UP") {
 		if err := b.setUp(); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return membersErr
 }
 
 //findBridge runs ifconfig <device> and parses output into a Bridge
@@ -70,8 +111,17 @@ func findBridge(device string) (*Bridge, error) {
 	return bridge, nil
 }
 
-// parseIfconfig parses output from ifconfig returning a *Bridge with members,
-// IP addr, and netmask populated if found.
+// parseIfconfig parses output from ifconfig returning a *Bridge with members
+// and addresses populated if found, eg.
+//
+//	inet 10.0.0.1 netmask 0xffffff00 broadcast 10.0.0.255
+//	inet6 fe80::1%bridge1 prefixlen 64 scopeid 0x9
+//	inet6 fd00::1 prefixlen 64
+//
+// The MTU and flags of the bridge and its members are read from
+//
+//	bridge1: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+//	member: tap0 flags=3<LEARNING,DISCOVER>
 func parseIfconfig(ifconfig string) *Bridge {
 	bridge := Bridge{}
 
@@ -80,16 +130,57 @@ func parseIfconfig(ifconfig string) *Bridge {
 		if strings.HasPrefix(line, "member:") {
 			kv := strings.Split(line, " ")
 			bridge.Members = append(bridge.Members, kv[1])
+			if len(kv) > 2 {
+				if bridge.MemberState == nil {
+					bridge.MemberState = map[string][]string{}
+				}
+				bridge.MemberState[kv[1]] = parseFlags(kv[2])
+			}
+		} else if strings.Contains(line, ": flags=") {
+			kv := strings.Split(line, " ")
+			bridge.Flags = parseFlags(kv[1])
+			for i := 2; i < len(kv)-1; i++ {
+				if kv[i] == "mtu" {
+					bridge.MTU, _ = strconv.Atoi(kv[i+1])
+				}
+			}
 		} else if strings.HasPrefix(line, "inet ") {
 			kv := strings.Split(line, " ")
-			bridge.IP = net.ParseIP(kv[1])
-			netmaskHex := strings.TrimPrefix(kv[3], "0x")
-			bridge.Netmask, _ = hex.DecodeString(netmaskHex)
+			if len(kv) < 4 {
+				continue
+			}
+			ip := net.ParseIP(kv[1]).To4()
+			netmask, err := hex.DecodeString(strings.TrimPrefix(kv[3], "0x"))
+			if ip == nil || err != nil {
+				continue
+			}
+			bridge.Addrs = append(bridge.Addrs, &net.IPNet{IP: ip, Mask: netmask})
+		} else if strings.HasPrefix(line, "inet6 ") {
+			kv := strings.Split(line, " ")
+			if len(kv) < 4 || kv[2] != "prefixlen" {
+				continue
+			}
+			ip := net.ParseIP(strings.SplitN(kv[1], "%", 2)[0])
+			prefix, err := strconv.Atoi(kv[3])
+			if ip == nil || err != nil {
+				continue
+			}
+			bridge.Addrs = append(bridge.Addrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, 128)})
 		}
 	}
 	return &bridge
 }
 
+// parseFlags parses flags, eg. flags=3<LEARNING,DISCOVER>.
+func parseFlags(s string) []string {
+	start := strings.Index(s, "<")
+	end := strings.LastIndex(s, ">")
+	if start == -1 || end <= start+1 {
+		return nil
+	}
+	return strings.Split(s[start+1:end], ",")
+}
+
 // create runs ifconfig bridge<N> create
 func (b *Bridge) create() error {
 	cmd := exec.Command("ifconfig", b.Device, "create")
@@ -111,25 +202,193 @@ func (b *Bridge) setUp() error {
 	return cmd.Run()
 }
 
-// setIP runs ifconfig bridge<N> <ipAddr> netmask <netmask>. Note, netmask is
-// passed in its hex form, eg. 0xffffff00. Similar to ifconfig's default output.
-func (b *Bridge) setIP() error {
-	if b.IP == nil {
+// setAddrs idempotently adds the addresses in Addrs to the bridge, and when
+// PruneAddrs is set removes addresses in cur that aren't in Addrs. A list of
+// current bridge addresses should be passed as an argument for idempotence.
+func (b *Bridge) setAddrs(cur []*net.IPNet) error {
+	add, del := addrDiff(b.Addrs, cur, b.PruneAddrs)
+	for _, addr := range del {
+		cmd := exec.Command("ifconfig", addrArgs(b.Device, addr, true)...)
+		fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+	for _, addr := range add {
+		cmd := exec.Command("ifconfig", addrArgs(b.Device, addr, false)...)
+		fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addrDiff returns the addresses in want missing from cur, and those in cur
+// to remove. An address in cur with the IP of one in want but a different
+// prefix is always removed, others only when prune is set. IPv6 link-local
+// addresses are managed by the kernel and never removed.
+func addrDiff(want, cur []*net.IPNet, prune bool) (add, del []*net.IPNet) {
+	for _, w := range want {
+		found := false
+		for _, c := range cur {
+			if addrEqual(w, c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add = append(add, w)
+		}
+	}
+
+	for _, c := range cur {
+		if c.IP.IsLinkLocalUnicast() && c.IP.To4() == nil {
+			continue
+		}
+		keep, conflicts := false, false
+		for _, w := range want {
+			if addrEqual(w, c) {
+				keep = true
+			} else if w.IP.Equal(c.IP) {
+				conflicts = true
+			}
+		}
+		if !keep && (prune || conflicts) {
+			del = append(del, c)
+		}
+	}
+	return add, del
+}
+
+func addrEqual(x, y *net.IPNet) bool {
+	xOnes, xBits := x.Mask.Size()
+	yOnes, yBits := y.Mask.Size()
+	return x.IP.Equal(y.IP) && xOnes == yOnes && xBits == yBits
+}
+
+// addrArgs returns the ifconfig arguments to add or remove an address as an
+// alias, eg. bridge1 inet 192.168.99.1 netmask 0xffffff00 alias, or
+// bridge1 inet6 fd00::1 prefixlen 64 alias. Note, IPv4 netmasks are passed in
+// their hex form, similar to ifconfig's default output.
+func addrArgs(device string, addr *net.IPNet, remove bool) []string {
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		if remove {
+			return []string{device, "inet", ip4.String(), "-alias"}
+		}
+		return []string{device, "inet", ip4.String(), "netmask", fmt.Sprintf("0x%s", addr.Mask.String()), "alias"}
+	}
+	if remove {
+		return []string{device, "inet6", addr.IP.String(), "-alias"}
+	}
+	ones, _ := addr.Mask.Size()
+	return []string{device, "inet6", addr.IP.String(), "prefixlen", strconv.Itoa(ones), "alias"}
+}
+
+// setMTU sets the MTU of the bridge and its current members and of Members
+// that exist, if it differs. Members are set first, as the MTU of a bridge can
+// only be changed to that of its members.
+func (b *Bridge) setMTU(cur *Bridge) error {
+	if b.MTU == 0 {
 		return nil
 	}
-	cmd := exec.Command("ifconfig", b.Device, b.IP.String(), "netmask", fmt.Sprintf("0x%s", b.Netmask.String()))
+	members := append([]string{}, cur.Members...)
+	add, _ := sliceDiff(b.Members, cur.Members)
+	members = append(members, sortedMembers(add)...)
+	if err := setMemberMTU(members, b.MTU); err != nil {
+		return err
+	}
+	if cur.MTU != b.MTU {
+		cmd := exec.Command("ifconfig", b.Device, "mtu", strconv.Itoa(b.MTU))
+		fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("setting mtu of %s, %v", b.Device, err)
+		}
+	}
+	return nil
+}
+
+// setMemberMTU sets the MTU of interfaces that exist with a different MTU.
+func setMemberMTU(members []string, mtu int) error {
+	for _, member := range members {
+		if cur := interfaceMTU(member); cur == 0 || cur == mtu {
+			continue
+		}
+		cmd := exec.Command("ifconfig", member, "mtu", strconv.Itoa(mtu))
+		fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("setting mtu of %s, %v", member, err)
+		}
+	}
+	return nil
+}
+
+// setMemberFlags sets and clears MemberFlags on Members that are on the
+// bridge, by comparing against the flags of the members from ifconfig.
+func (b *Bridge) setMemberFlags() error {
+	if len(b.MemberFlags) == 0 {
+		return nil
+	}
+	cur, err := findBridge(b.Device)
+	if err != nil {
+		return err
+	}
+	args := memberFlagArgs(b.Device, b.Members, b.MemberFlags, cur.MemberState)
+	if len(args) == 1 {
+		return nil
+	}
+	cmd := exec.Command("ifconfig", args...)
 	fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
 	return cmd.Run()
 }
 
+// memberFlagArgs returns the arguments to ifconfig setting or clearing flags
+// on members whose flags in state differ, eg. bridge1 stp tap0 -learn tap0.
+// Members not in state aren't on the bridge and are skipped.
+func memberFlagArgs(device string, members []string, flags map[string]bool, state map[string][]string) []string {
+	var names []string
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{device}
+	for _, member := range sortedMembers(members) {
+		cur, ok := state[member]
+		if !ok {
+			continue
+		}
+		for _, name := range names {
+			f, ok := memberFlags[name]
+			if !ok {
+				continue
+			}
+			set := contains(cur, f.flag)
+			if flags[name] && !set {
+				args = append(args, f.option, member)
+			} else if !flags[name] && set {
+				args = append(args, "-"+f.option, member)
+			}
+		}
+	}
+	return args
+}
+
 // setMembers idempotently adds member devices listed in the Members attribute to a bridge device
 // and conditionally removes any additional members passed in cur. A list of current bridge members
 // should be passsed as an argument for idempotence.
 func (b *Bridge) setMembers(cur []string, delete bool) error {
 	add, del := sliceDiff(b.Members, cur)
+	var addErr error
 	if add != nil {
-		if err := addMembers(b.Device, add); err != nil {
-			return err
+		timeout := b.MemberTimeout
+		if timeout == 0 {
+			timeout = DefaultMemberTimeout
+		}
+		if addErr = addMembers(b.Device, add, timeout, b.MTU); addErr != nil {
+			if _, ok := addErr.(*MembersError); !ok {
+				return addErr
+			}
 		}
 	}
 	if delete && del != nil {
@@ -137,38 +396,48 @@ func (b *Bridge) setMembers(cur []string, delete bool) error {
 			return err
 		}
 	}
-	return nil
+	return addErr
 }
 
-// addMembers added member devices to a bridge device by running ifconfig bridge<N> addm <dev1> addm <dev2> ...
-func addMembers(device string, members []string) error {
+// addMembers adds member devices to a bridge device by running ifconfig
+// bridge<N> addm <dev1> addm <dev2> ... Members are waited for together, eg.
+// while hyperkit brings up the tap interfaces, and those that don't appear
+// within timeout are reported in a *MembersError after adding the others. If
+// mtu isn't 0, members are set to it before being added.
+func addMembers(device string, members []string, timeout time.Duration, mtu int) error {
 	if len(members) == 0 {
 		return nil
 	}
 
+	results := waitInterfaces(sortedMembers(members), timeout)
+
 	args := []string{device}
-NextMember:
-	for _, member := range members {
+	var found []string
+	for _, r := range results {
+		if r.Err == nil {
+			args = append(args, "addm", r.Member)
+			found = append(found, r.Member)
+		}
+	}
+	if mtu != 0 {
+		if err := setMemberMTU(found, mtu); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		cmd := exec.Command("ifconfig", args...)
+		fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
 
-		// Ugly polling and timeout mechanism waiting for the hyperkit to bring up the tap interface.
-		var count int
-		for {
-			if count > 10 {
-				fmt.Printf("failed to find device %s after 10 seconds, while attempting to add it to bridge %s\n", member, device)
-				continue NextMember
-			}
-			if _, err := net.InterfaceByName(member); err != nil {
-				time.Sleep(1 * time.Second)
-				count++
-				continue
-			}
-			break
+	for _, r := range results {
+		if r.Err != nil {
+			return &MembersError{Bridge: device, Results: results}
 		}
-		args = append(args, "addm", member)
 	}
-	cmd := exec.Command("ifconfig", args...)
-	fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
-	return cmd.Run()
+	return nil
 }
 
 // delMembers deletes member devices to a bridge device by running ifconfig bridge<N> deletem <dev1> deletem <dev2> ...
@@ -3,6 +3,7 @@ package network
 import (
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -23,8 +24,62 @@ func Test_parseIfconfig(t *testing.T) {
 			args: args{ifconfig: string(ifconfigOut)},
 			want: &Bridge{
 				Members: []string{"en3", "en1", "en2", "en4"},
-				IP:      net.ParseIP("10.0.0.1"),
-				Netmask: []byte{255, 255, 255, 0},
+				Addrs:   []*net.IPNet{{IP: net.ParseIP("10.0.0.1").To4(), Mask: net.IPMask{255, 255, 255, 0}}},
+				MTU:     1500,
+				Flags:   []string{"UP", "BROADCAST", "SMART", "RUNNING", "SIMPLEX", "MULTICAST"},
+				MemberState: map[string][]string{
+					"en3": {"LEARNING", "DISCOVER"},
+					"en1": {"LEARNING", "DISCOVER"},
+					"en2": {"LEARNING", "DISCOVER"},
+					"en4": {"LEARNING", "DISCOVER"},
+				},
+			},
+		},
+		{
+			name: "bridge1 ipv6",
+			args: args{ifconfig: `bridge1: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+	options=3<RXCSUM,TXCSUM>
+	ether 6e:7e:67:c3:a1:01
+	inet 192.168.99.1 netmask 0xffffff00 broadcast 192.168.99.255
+	inet6 fe80::6c7e:67ff:fec3:a101%bridge1 prefixlen 64 scopeid 0x9
+	inet 192.168.100.1 netmask 0xffff0000 broadcast 192.168.255.255
+	inet6 fd00:99::1 prefixlen 64
+	member: tap0 flags=3<LEARNING,DISCOVER>
+	        ifmaxaddr 0 port 10 priority 0 path cost 0
+	status: active
+`},
+			want: &Bridge{
+				Members: []string{"tap0"},
+				Addrs: []*net.IPNet{
+					{IP: net.ParseIP("192.168.99.1").To4(), Mask: net.CIDRMask(24, 32)},
+					{IP: net.ParseIP("fe80::6c7e:67ff:fec3:a101"), Mask: net.CIDRMask(64, 128)},
+					{IP: net.ParseIP("192.168.100.1").To4(), Mask: net.CIDRMask(16, 32)},
+					{IP: net.ParseIP("fd00:99::1"), Mask: net.CIDRMask(64, 128)},
+				},
+				MTU:         1500,
+				Flags:       []string{"UP", "BROADCAST", "SMART", "RUNNING", "SIMPLEX", "MULTICAST"},
+				MemberState: map[string][]string{"tap0": {"LEARNING", "DISCOVER"}},
+			},
+		},
+		{
+			name: "bridge2 jumbo frames, stp and private member",
+			args: args{ifconfig: `bridge2: flags=8822<BROADCAST,SMART,SIMPLEX,MULTICAST> mtu 9000
+	options=3<RXCSUM,TXCSUM>
+	ether 6e:7e:67:c3:a1:02
+	member: tap1 flags=1e3<LEARNING,DISCOVER,STP,EDGE,AUTOEDGE>
+	        ifmaxaddr 0 port 11 priority 128 path cost 2000
+	member: tap2 flags=402<DISCOVER,PRIVATE>
+	        ifmaxaddr 0 port 12 priority 128 path cost 2000
+	status: inactive
+`},
+			want: &Bridge{
+				Members: []string{"tap1", "tap2"},
+				MTU:     9000,
+				Flags:   []string{"BROADCAST", "SMART", "SIMPLEX", "MULTICAST"},
+				MemberState: map[string][]string{
+					"tap1": {"LEARNING", "DISCOVER", "STP", "EDGE", "AUTOEDGE"},
+					"tap2": {"DISCOVER", "PRIVATE"},
+				},
 			},
 		},
 	}
@@ -38,6 +93,52 @@ func Test_parseIfconfig(t *testing.T) {
 	}
 }
 
+func Test_memberFlagArgs(t *testing.T) {
+	state := map[string][]string{
+		"tap0": {"LEARNING", "DISCOVER"},
+		"tap1": {"LEARNING", "DISCOVER", "STP", "PRIVATE"},
+		"en0":  {"LEARNING", "DISCOVER"},
+	}
+	tests := []struct {
+		name    string
+		members []string
+		flags   map[string]bool
+		want    []string
+	}{
+		{
+			name:    "stp enabled",
+			members: []string{"tap0", "tap1"},
+			flags:   map[string]bool{"stp": true},
+			want:    []string{"bridge1", "stp", "tap0"},
+		},
+		{
+			name:    "private without learning",
+			members: []string{"tap1", "tap0"},
+			flags:   map[string]bool{"private": true, "learning": false},
+			want:    []string{"bridge1", "-learn", "tap0", "private", "tap0", "-learn", "tap1"},
+		},
+		{
+			name:    "stp disabled, members not on the bridge skipped",
+			members: []string{"tap1", "tap2"},
+			flags:   map[string]bool{"stp": false},
+			want:    []string{"bridge1", "-stp", "tap1"},
+		},
+		{
+			name:    "already set",
+			members: []string{"tap0"},
+			flags:   map[string]bool{"learning": true, "discover": true},
+			want:    []string{"bridge1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := memberFlagArgs("bridge1", tt.members, tt.flags, state); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("memberFlagArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_sliceDiff(t *testing.T) {
 	type args struct {
 		x []string
@@ -156,3 +257,82 @@ func Test_sliceDiff(t *testing.T) {
 //		fmt.Printf("%s", ifconfigOut)
 //	}
 //}
+
+func mustCIDR(s string) *net.IPNet {
+	ip, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: n.Mask}
+}
+
+func Test_addrDiff(t *testing.T) {
+	want := []*net.IPNet{mustCIDR("192.168.99.1/24"), mustCIDR("fd00:99::1/64")}
+	tests := []struct {
+		name    string
+		cur     []*net.IPNet
+		prune   bool
+		wantAdd []*net.IPNet
+		wantDel []*net.IPNet
+	}{
+		{
+			name:    "new bridge",
+			wantAdd: want,
+		},
+		{
+			name: "up to date",
+			cur:  []*net.IPNet{mustCIDR("fe80::1/64"), mustCIDR("fd00:99::1/64"), mustCIDR("192.168.99.1/24")},
+		},
+		{
+			name:    "stale kept without prune",
+			cur:     []*net.IPNet{mustCIDR("192.168.99.1/24"), mustCIDR("10.0.0.1/8")},
+			wantAdd: []*net.IPNet{mustCIDR("fd00:99::1/64")},
+		},
+		{
+			name:    "stale removed with prune, link-local kept",
+			cur:     []*net.IPNet{mustCIDR("fe80::1/64"), mustCIDR("192.168.99.1/24"), mustCIDR("fd00:99::1/64"), mustCIDR("10.0.0.1/8")},
+			prune:   true,
+			wantDel: []*net.IPNet{mustCIDR("10.0.0.1/8")},
+		},
+		{
+			name:    "prefix changed",
+			cur:     []*net.IPNet{mustCIDR("192.168.99.1/16"), mustCIDR("fd00:99::1/64")},
+			wantAdd: []*net.IPNet{mustCIDR("192.168.99.1/24")},
+			wantDel: []*net.IPNet{mustCIDR("192.168.99.1/16")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			add, del := addrDiff(want, tt.cur, tt.prune)
+			if !reflect.DeepEqual(add, tt.wantAdd) {
+				t.Errorf("addrDiff() add = %v, want %v", add, tt.wantAdd)
+			}
+			if !reflect.DeepEqual(del, tt.wantDel) {
+				t.Errorf("addrDiff() del = %v, want %v", del, tt.wantDel)
+			}
+		})
+	}
+}
+
+func Test_addrArgs(t *testing.T) {
+	tests := []struct {
+		addr   string
+		remove bool
+		want   string
+	}{
+		{addr: "192.168.99.1/24", want: "bridge1 inet 192.168.99.1 netmask 0xffffff00 alias"},
+		{addr: "192.168.99.1/24", remove: true, want: "bridge1 inet 192.168.99.1 -alias"},
+		{addr: "fd00:99::1/64", want: "bridge1 inet6 fd00:99::1 prefixlen 64 alias"},
+		{addr: "fd00:99::1/64", remove: true, want: "bridge1 inet6 fd00:99::1 -alias"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := strings.Join(addrArgs("bridge1", mustCIDR(tt.addr), tt.remove), " "); got != tt.want {
+				t.Errorf("addrArgs() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
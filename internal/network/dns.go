@@ -0,0 +1,442 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS record types and response codes used by the DNS server.
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+
+	dnsFormErr  = 1
+	dnsServFail = 2
+	dnsNXDomain = 3
+	dnsNotImp   = 4
+)
+
+// dnsTTL is the TTL of answers, short as discovered addresses may change.
+const dnsTTL = 5
+
+// DNSRecord is an address of a VM on a network.
+type DNSRecord struct {
+	Name    string // eg. web-0.hkmgr.test
+	Network string
+	IP      net.IP
+}
+
+// DNSListener is an address the DNS server listens on, and the network it
+// serves.
+type DNSListener struct {
+	Addr    string // eg. 192.168.99.1:53
+	Network string
+}
+
+// DNSServer answers queries for names in Domain with the addresses of VMs,
+// and forwards other queries to Upstream. Queries received on the address of
+// a network are answered with the addresses of VMs on that network, or all
+// of their addresses if they aren't on it.
+type DNSServer struct {
+	Domain   string
+	Listen   []DNSListener
+	Upstream []string      // Upstream servers, eg. 1.1.1.1:53
+	Timeout  time.Duration // Timeout of upstream queries, 2s if zero
+
+	mu      sync.Mutex
+	records []DNSRecord
+	conns   []*net.UDPConn
+}
+
+// SetRecords replaces the records the server answers with.
+func (s *DNSServer) SetRecords(records []DNSRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = records
+}
+
+// ListenAndServe listens on the addresses of the server, and serves queries
+// until Close is called.
+func (s *DNSServer) ListenAndServe() error {
+	var conns []*net.UDPConn
+	for _, l := range s.Listen {
+		addr, err := net.ResolveUDPAddr("udp", l.Addr)
+		if err != nil {
+			return err
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return err
+		}
+		fmt.Printf("dns: listening on %s\n", l.Addr)
+		conns = append(conns, conn)
+	}
+	s.mu.Lock()
+	s.conns = conns
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(conn *net.UDPConn, network string) {
+			defer wg.Done()
+			s.serve(conn, network)
+		}(conn, s.Listen[i].Network)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Close stops the server.
+func (s *DNSServer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *DNSServer) serve(conn *net.UDPConn, network string) {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg := append([]byte{}, buf[:n]...)
+		// Forwarded queries wait on upstream, so they're handled concurrently.
+		go func() {
+			resp := s.handle(msg, network)
+			if resp != nil {
+				conn.WriteToUDP(resp, addr)
+			}
+		}()
+	}
+}
+
+// handle returns the response to a query, or nil if it can't be answered.
+func (s *DNSServer) handle(msg []byte, network string) []byte {
+	q, err := parseDNSQuery(msg)
+	if err != nil {
+		// Responses aren't answered, so servers can't loop.
+		if len(msg) < 12 || msg[2]&0x80 != 0 {
+			return nil
+		}
+		return dnsResponse(msg, nil, dnsFormErr, nil)
+	}
+
+	if !s.inDomain(q.name) {
+		resp, err := s.forward(msg)
+		if err != nil {
+			fmt.Printf("dns: forwarding %s, %v\n", q.name, err)
+			return dnsResponse(msg, q, dnsServFail, nil)
+		}
+		return resp
+	}
+
+	if q.opcode != 0 || q.qclass != dnsClassIN {
+		return dnsResponse(msg, q, dnsNotImp, nil)
+	}
+	ips, ok := s.lookup(q.name, network)
+	if !ok && q.name != strings.ToLower(s.Domain) {
+		return dnsResponse(msg, q, dnsNXDomain, nil)
+	}
+
+	var answers []net.IP
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		switch {
+		case q.qtype == dnsTypeA && ip4 != nil:
+			answers = append(answers, ip4)
+		case q.qtype == dnsTypeAAAA && ip4 == nil:
+			answers = append(answers, ip.To16())
+		}
+	}
+	return dnsResponse(msg, q, 0, answers)
+}
+
+// inDomain returns whether name is the domain or a name in it.
+func (s *DNSServer) inDomain(name string) bool {
+	domain := strings.ToLower(s.Domain)
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// lookup returns the addresses of name, preferring those on network, and
+// whether there are records of name.
+func (s *DNSServer) lookup(name string, network string) ([]net.IP, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all, onNet []net.IP
+	for _, r := range s.records {
+		if strings.ToLower(r.Name) != name {
+			continue
+		}
+		all = append(all, r.IP)
+		if r.Network == network {
+			onNet = append(onNet, r.IP)
+		}
+	}
+	if len(onNet) > 0 {
+		return onNet, true
+	}
+	return all, len(all) > 0
+}
+
+// forward sends a query to the upstream servers in turn, returning the first
+// response.
+func (s *DNSServer) forward(msg []byte) ([]byte, error) {
+	if len(s.Upstream) == 0 {
+		return nil, fmt.Errorf("no upstream servers")
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	var lastErr error
+	for _, upstream := range s.Upstream {
+		resp, err := dnsExchange(upstream, msg, timeout)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dnsExchange sends a query to a server and returns its response.
+func dnsExchange(server string, msg []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray responses to other queries.
+		if n >= 2 && bytes.Equal(buf[:2], msg[:2]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+// dnsQuery is the question of a DNS query.
+type dnsQuery struct {
+	name   string // Lower case, without the trailing dot
+	qtype  uint16
+	qclass uint16
+	opcode int
+	end    int // Offset of the end of the question
+}
+
+// parseDNSQuery parses a query with a single question.
+func parseDNSQuery(msg []byte) (*dnsQuery, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("message too short")
+	}
+	if msg[2]&0x80 != 0 {
+		return nil, fmt.Errorf("message is a response")
+	}
+	if qdcount := binary.BigEndian.Uint16(msg[4:6]); qdcount != 1 {
+		return nil, fmt.Errorf("expected 1 question, found %d", qdcount)
+	}
+
+	var labels []string
+	off := 12
+	for {
+		if off >= len(msg) {
+			return nil, fmt.Errorf("question truncated")
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 || off+l > len(msg) {
+			return nil, fmt.Errorf("invalid label in question")
+		}
+		labels = append(labels, string(msg[off:off+l]))
+		off += l
+	}
+	if off+4 > len(msg) {
+		return nil, fmt.Errorf("question truncated")
+	}
+	return &dnsQuery{
+		name:   strings.ToLower(strings.Join(labels, ".")),
+		qtype:  binary.BigEndian.Uint16(msg[off : off+2]),
+		qclass: binary.BigEndian.Uint16(msg[off+2 : off+4]),
+		opcode: int(msg[2]>>3) & 0xf,
+		end:    off + 4,
+	}, nil
+}
+
+// dnsResponse returns an authoritative response to a query with the answers,
+// which are A records for IPv4 addresses and AAAA records otherwise. The
+// question is omitted if q is nil.
+func dnsResponse(msg []byte, q *dnsQuery, rcode int, answers []net.IP) []byte {
+	var buf bytes.Buffer
+	buf.Write(msg[:2])                                  // ID
+	buf.WriteByte(0x80 | msg[2]&0x78 | 0x04 | msg[2]&1) // QR, opcode, AA, RD
+	buf.WriteByte(0x80 | byte(rcode))                   // RA, rcode
+
+	qdcount := 0
+	if q != nil {
+		qdcount = 1
+	}
+	for _, n := range []int{qdcount, len(answers), 0, 0} {
+		binary.Write(&buf, binary.BigEndian, uint16(n))
+	}
+	if q == nil {
+		return buf.Bytes()
+	}
+	buf.Write(msg[12:q.end])
+
+	for _, ip := range answers {
+		rtype := uint16(dnsTypeAAAA)
+		if len(ip) == net.IPv4len {
+			rtype = dnsTypeA
+		}
+		// The name is a pointer to the name in the question.
+		binary.Write(&buf, binary.BigEndian, []uint16{0xc00c, rtype, dnsClassIN})
+		binary.Write(&buf, binary.BigEndian, uint32(dnsTTL))
+		binary.Write(&buf, binary.BigEndian, uint16(len(ip)))
+		buf.Write(ip)
+	}
+	return buf.Bytes()
+}
+
+// ResolvConf is the resolver configuration upstream servers are read from.
+var ResolvConf = "/etc/resolv.conf"
+
+// Nameservers returns the nameservers in ResolvConf as addresses with port
+// 53, except those in exclude, eg. the addresses the DNS server listens on.
+func Nameservers(exclude []net.IP) ([]string, error) {
+	f, err := os.Open(ResolvConf)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		ip := net.ParseIP(fields[1])
+		if ip == nil || containsIP(exclude, ip) {
+			continue
+		}
+		servers = append(servers, net.JoinHostPort(ip.String(), "53"))
+	}
+	return servers, scanner.Err()
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolverHeader starts resolver files written by hkmgr.
+const resolverHeader = "# Written by hkmgr\n"
+
+// ResolverDir is where macOS reads per domain resolver configurations.
+var ResolverDir = "/etc/resolver"
+
+// Resolver is a macOS per domain resolver configuration, sending queries for
+// names in Domain to Nameservers.
+type Resolver struct {
+	Domain      string
+	Nameservers []net.IP
+	Port        int
+}
+
+func (r *Resolver) path() string {
+	return filepath.Join(ResolverDir, r.Domain)
+}
+
+// contents returns the resolver file, see resolver(5).
+func (r *Resolver) contents() string {
+	var buf bytes.Buffer
+	buf.WriteString(resolverHeader)
+	for _, ns := range r.Nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	if r.Port != 0 && r.Port != 53 {
+		fmt.Fprintf(&buf, "port %d\n", r.Port)
+	}
+	return buf.String()
+}
+
+// Up writes the resolver file if it differs. A resolver file for the domain
+// that wasn't written by hkmgr is left as is.
+func (r *Resolver) Up() error {
+	data := []byte(r.contents())
+	cur, err := ioutil.ReadFile(r.path())
+	if err == nil && bytes.Equal(cur, data) {
+		return nil
+	}
+	if err == nil && !bytes.HasPrefix(cur, []byte(resolverHeader)) {
+		return fmt.Errorf("%s wasn't written by hkmgr, leaving it as is", r.path())
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(ResolverDir, 0755); err != nil {
+		return err
+	}
+	fmt.Printf("writing %s\n", r.path())
+
+	tmp := r.path() + ".hkmgr"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.path()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Down removes the resolver file, if it was written by hkmgr.
+func (r *Resolver) Down() error {
+	cur, err := ioutil.ReadFile(r.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(cur, []byte(resolverHeader)) {
+		return fmt.Errorf("%s wasn't written by hkmgr, leaving it as is", r.path())
+	}
+	fmt.Printf("removing %s\n", r.path())
+	return os.Remove(r.path())
+}
@@ -0,0 +1,208 @@
+package network
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dnsQueryMsg returns a query for name with the record type.
+func dnsQueryMsg(name string, qtype uint16) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = append(msg, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+	return msg
+}
+
+// dnsAnswers returns the rcode and answered addresses of a response to a
+// query made by dnsQueryMsg.
+func dnsAnswers(t *testing.T, query []byte, resp []byte) (int, []string) {
+	t.Helper()
+	if len(resp) < len(query) || resp[0] != query[0] || resp[1] != query[1] {
+		t.Fatalf("response %v doesn't match query", resp)
+	}
+	if resp[2]&0x80 == 0 {
+		t.Fatalf("response %v isn't a response", resp)
+	}
+	ancount := int(binary.BigEndian.Uint16(resp[6:8]))
+	var ips []string
+	off := len(query)
+	for i := 0; i < ancount; i++ {
+		rdlen := int(binary.BigEndian.Uint16(resp[off+10 : off+12]))
+		ips = append(ips, net.IP(resp[off+12:off+12+rdlen]).String())
+		off += 12 + rdlen
+	}
+	return int(resp[3] & 0xf), ips
+}
+
+func TestDNSServer_handle(t *testing.T) {
+	s := &DNSServer{Domain: "hkmgr.test"}
+	s.SetRecords([]DNSRecord{
+		{Name: "web.hkmgr.test", Network: "lan", IP: net.ParseIP("192.168.99.2")},
+		{Name: "web.hkmgr.test", Network: "lan", IP: net.ParseIP("fd00:99::2")},
+		{Name: "web.hkmgr.test", Network: "other", IP: net.ParseIP("192.168.98.2")},
+		{Name: "db.hkmgr.test", Network: "other", IP: net.ParseIP("192.168.98.3")},
+	})
+
+	tests := []struct {
+		name      string
+		query     string
+		qtype     uint16
+		network   string
+		wantRcode int
+		want      []string
+	}{
+		{name: "A", query: "web.hkmgr.test", qtype: dnsTypeA, network: "lan", want: []string{"192.168.99.2"}},
+		{name: "AAAA", query: "web.hkmgr.test", qtype: dnsTypeAAAA, network: "lan", want: []string{"fd00:99::2"}},
+		{name: "case insensitive", query: "WEB.hkmgr.TEST", qtype: dnsTypeA, network: "other", want: []string{"192.168.98.2"}},
+		{name: "not on network", query: "db.hkmgr.test", qtype: dnsTypeA, network: "lan", want: []string{"192.168.98.3"}},
+		{name: "no AAAA", query: "db.hkmgr.test", qtype: dnsTypeAAAA, network: "lan"},
+		{name: "domain", query: "hkmgr.test", qtype: dnsTypeA, network: "lan"},
+		{name: "unknown", query: "mail.hkmgr.test", qtype: dnsTypeA, network: "lan", wantRcode: dnsNXDomain},
+		{name: "no upstream", query: "example.com", qtype: dnsTypeA, network: "lan", wantRcode: dnsServFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := dnsQueryMsg(tt.query, tt.qtype)
+			rcode, got := dnsAnswers(t, query, s.handle(query, tt.network))
+			if rcode != tt.wantRcode {
+				t.Errorf("DNSServer.handle() rcode = %d, want %d", rcode, tt.wantRcode)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DNSServer.handle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSServer_handleInvalid(t *testing.T) {
+	s := &DNSServer{Domain: "hkmgr.test"}
+
+	if resp := s.handle([]byte{0x12, 0x34}, "lan"); resp != nil {
+		t.Errorf("DNSServer.handle() of a short message = %v, want nil", resp)
+	}
+
+	resp := dnsQueryMsg("web.hkmgr.test", dnsTypeA)
+	resp[2] |= 0x80
+	if got := s.handle(resp, "lan"); got != nil {
+		t.Errorf("DNSServer.handle() of a response = %v, want nil", got)
+	}
+
+	truncated := dnsQueryMsg("web.hkmgr.test", dnsTypeA)
+	truncated = truncated[:len(truncated)-3]
+	got := s.handle(truncated, "lan")
+	if len(got) != 12 || got[3]&0xf != dnsFormErr {
+		t.Errorf("DNSServer.handle() of a truncated query = %v, want a format error", got)
+	}
+}
+
+func TestDNSServer_forward(t *testing.T) {
+	upstream, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	// The upstream answers every query with 192.0.2.1.
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			q, err := parseDNSQuery(buf[:n])
+			if err != nil {
+				continue
+			}
+			upstream.WriteToUDP(dnsResponse(buf[:n], q, 0, []net.IP{net.IPv4(192, 0, 2, 1).To4()}), addr)
+		}
+	}()
+
+	s := &DNSServer{Domain: "hkmgr.test", Upstream: []string{upstream.LocalAddr().String()}}
+	query := dnsQueryMsg("example.com", dnsTypeA)
+	rcode, got := dnsAnswers(t, query, s.handle(query, "lan"))
+	if rcode != 0 || !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+		t.Errorf("DNSServer.handle() = %d %v, want 0 [192.0.2.1]", rcode, got)
+	}
+}
+
+func TestNameservers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origResolvConf := ResolvConf
+	defer func() { ResolvConf = origResolvConf }()
+	ResolvConf = filepath.Join(dir, "resolv.conf")
+	conf := "# comment\nsearch example.com\nnameserver 192.168.99.1\nnameserver 1.1.1.1\nnameserver 2606:4700:4700::1111\n"
+	if err := ioutil.WriteFile(ResolvConf, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Nameservers([]net.IP{net.ParseIP("192.168.99.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.1.1.1:53", "[2606:4700:4700::1111]:53"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Nameservers() = %v, want %v", got, want)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origResolverDir := ResolverDir
+	defer func() { ResolverDir = origResolverDir }()
+	ResolverDir = filepath.Join(dir, "resolver")
+
+	r := &Resolver{Domain: "hkmgr.test", Nameservers: []net.IP{net.ParseIP("192.168.99.1")}, Port: 5353}
+	if err := r.Up(); err != nil {
+		t.Fatalf("Resolver.Up() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(ResolverDir, "hkmgr.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Written by hkmgr\nnameserver 192.168.99.1\nport 5353\n"
+	if string(got) != want {
+		t.Errorf("Resolver.Up() wrote %q, want %q", got, want)
+	}
+
+	if err := r.Down(); err != nil {
+		t.Fatalf("Resolver.Down() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ResolverDir, "hkmgr.test")); !os.IsNotExist(err) {
+		t.Errorf("Resolver.Down() left the resolver file, %v", err)
+	}
+
+	// Resolver files not written by hkmgr are left as is.
+	other := filepath.Join(ResolverDir, "hkmgr.test")
+	if err := ioutil.WriteFile(other, []byte("nameserver 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Up(); err == nil {
+		t.Errorf("Resolver.Up() over a file not written by hkmgr succeeded")
+	}
+	if err := r.Down(); err == nil {
+		t.Errorf("Resolver.Down() of a file not written by hkmgr succeeded")
+	}
+	if got, err := ioutil.ReadFile(other); err != nil || string(got) != "nameserver 10.0.0.1\n" {
+		t.Errorf("Resolver changed a file not written by hkmgr to %q, %v", got, err)
+	}
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter isolates VMs on a bridge from each other and from the host, except
+// for allowed traffic, with pf rules bound to their tap interfaces. Traffic
+// leaving a tap interface towards its VM is blocked unless it's allowed, a
+// reply, DHCP or IPv6 neighbor discovery. VMs can still reach the host, eg.
+// for DHCP, DNS and NAT.
+type Filter struct {
+	Bridge  string
+	Isolate bool
+	HostIPs []net.IP // Addresses of the bridge, ie. the host
+	Members []FilterMember
+}
+
+// FilterMember is a VM interface on the bridge.
+type FilterMember struct {
+	Name      string // Name of the VM
+	Interface string // Tap interface
+	IPs       []net.IP
+	Allow     []FilterAllow
+}
+
+// FilterAllow allows traffic to a member from peers.
+type FilterAllow struct {
+	From  []string // Names of peer members, or "host"
+	Proto string   // tcp, udp, or any protocol if empty
+	Ports []int    // Destination ports, any port if empty
+}
+
+// FilterHost is the name allowing traffic from the host.
+const FilterHost = "host"
+
+func (f *Filter) anchor() string {
+	return "com.apple/hkmgr.filter." + f.Bridge
+}
+
+// Up loads the rules into the anchor of the bridge. When the bridge isn't
+// isolated, rules loaded previously are removed.
+func (f *Filter) Up() error {
+	if !f.Isolate {
+		out, err := hostOutput("pfctl", "-a", f.anchor(), "-s", "rules")
+		if err != nil || strings.TrimSpace(out) == "" {
+			return nil
+		}
+		return flushAnchor(f.anchor())
+	}
+	rules, err := f.Rules()
+	if err != nil {
+		return err
+	}
+	return loadAnchor(f.anchor(), rules)
+}
+
+// Rules compiles the filter into pf rules.
+func (f *Filter) Rules() (string, error) {
+	members := map[string]*FilterMember{}
+	for i := range f.Members {
+		members[f.Members[i].Name] = &f.Members[i]
+	}
+
+	var buf bytes.Buffer
+	for _, m := range f.Members {
+		fmt.Fprintf(&buf, "# %s\n", m.Name)
+		// Traffic from the VM creates state bound to its interface, so replies
+		// pass but it isn't allowed out of other interfaces by the state.
+		fmt.Fprintf(&buf, "pass in quick on %s all keep state (if-bound)\n", m.Interface)
+		fmt.Fprintf(&buf, "pass out quick on %s inet proto udp from any port 67 to any port 68\n", m.Interface)
+		fmt.Fprintf(&buf, "pass out quick on %s inet6 proto icmp6 all icmp6-type { neighbrsol, neighbradv, routersol, routeradv }\n", m.Interface)
+
+		for _, a := range m.Allow {
+			var from []net.IP
+			for _, peer := range a.From {
+				if peer == FilterHost {
+					from = append(from, f.HostIPs...)
+					continue
+				}
+				p, ok := members[peer]
+				if !ok {
+					return "", fmt.Errorf("%s allows %s, which isn't on bridge %s", m.Name, peer, f.Bridge)
+				}
+				if len(p.IPs) == 0 {
+					return "", fmt.Errorf("%s allows %s, which has no ip on bridge %s", m.Name, peer, f.Bridge)
+				}
+				from = append(from, p.IPs...)
+			}
+			if len(from) == 0 {
+				continue
+			}
+			rule, err := allowRule(m.Interface, a, from)
+			if err != nil {
+				return "", fmt.Errorf("%s, %v", m.Name, err)
+			}
+			buf.WriteString(rule)
+		}
+		fmt.Fprintf(&buf, "block drop out quick on %s all\n", m.Interface)
+	}
+	return buf.String(), nil
+}
+
+// allowRule returns a rule passing traffic out of iface from addresses, eg.
+// pass out quick on tap1 proto tcp from { 192.168.99.3 } to any port { 5432 } keep state (if-bound)
+func allowRule(iface string, a FilterAllow, from []net.IP) (string, error) {
+	rule := "pass out quick on " + iface
+	switch a.Proto {
+	case "":
+		if len(a.Ports) > 0 {
+			return "", fmt.Errorf("allowing ports requires proto tcp or udp")
+		}
+	case "tcp", "udp":
+		rule += " proto " + a.Proto
+	default:
+		return "", fmt.Errorf("allow proto %s not supported: tcp and udp are supported", a.Proto)
+	}
+
+	var addrs []string
+	seen := map[string]bool{}
+	for _, ip := range from {
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			addrs = append(addrs, ip.String())
+		}
+	}
+	sort.Strings(addrs)
+	rule += " from { " + strings.Join(addrs, ", ") + " } to any"
+
+	if len(a.Ports) > 0 {
+		var ports []string
+		for _, port := range a.Ports {
+			if port < 1 || port > 65535 {
+				return "", fmt.Errorf("port must be between 1 and 65535: %d", port)
+			}
+			ports = append(ports, strconv.Itoa(port))
+		}
+		rule += " port { " + strings.Join(ports, ", ") + " }"
+	}
+	return rule + " keep state (if-bound)\n", nil
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFilter_Rules(t *testing.T) {
+	f := &Filter{
+		Bridge:  "bridge1",
+		Isolate: true,
+		HostIPs: []net.IP{net.ParseIP("192.168.99.1")},
+		Members: []FilterMember{
+			{
+				Name:      "web",
+				Interface: "tap0",
+				IPs:       []net.IP{net.ParseIP("192.168.99.2")},
+				Allow:     []FilterAllow{{From: []string{"host"}, Proto: "tcp", Ports: []int{80, 443}}},
+			},
+			{
+				Name:      "db",
+				Interface: "tap1",
+				IPs:       []net.IP{net.ParseIP("192.168.99.3"), net.ParseIP("fd00:99::3")},
+				Allow: []FilterAllow{
+					{From: []string{"web", "host"}, Proto: "tcp", Ports: []int{5432}},
+					{From: []string{"backup"}},
+				},
+			},
+			{
+				Name:      "backup",
+				Interface: "tap2",
+				IPs:       []net.IP{net.ParseIP("192.168.99.4")},
+			},
+		},
+	}
+
+	want := `# web
+pass in quick on tap0 all keep state (if-bound)
+pass out quick on tap0 inet proto udp from any port 67 to any port 68
+pass out quick on tap0 inet6 proto icmp6 all icmp6-type { neighbrsol, neighbradv, routersol, routeradv }
+pass out quick on tap0 proto tcp from { 192.168.99.1 } to any port { 80, 443 } keep state (if-bound)
+block drop out quick on tap0 all
+# db
+pass in quick on tap1 all keep state (if-bound)
+pass out quick on tap1 inet proto udp from any port 67 to any port 68
+pass out quick on tap1 inet6 proto icmp6 all icmp6-type { neighbrsol, neighbradv, routersol, routeradv }
+pass out quick on tap1 proto tcp from { 192.168.99.1, 192.168.99.2 } to any port { 5432 } keep state (if-bound)
+pass out quick on tap1 from { 192.168.99.4 } to any keep state (if-bound)
+block drop out quick on tap1 all
+# backup
+pass in quick on tap2 all keep state (if-bound)
+pass out quick on tap2 inet proto udp from any port 67 to any port 68
+pass out quick on tap2 inet6 proto icmp6 all icmp6-type { neighbrsol, neighbradv, routersol, routeradv }
+block drop out quick on tap2 all
+`
+	got, err := f.Rules()
+	if err != nil {
+		t.Fatalf("Filter.Rules() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Filter.Rules() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFilter_RulesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow FilterAllow
+	}{
+		{name: "unknown peer", allow: FilterAllow{From: []string{"cache"}}},
+		{name: "peer without ip", allow: FilterAllow{From: []string{"db"}}},
+		{name: "ports without proto", allow: FilterAllow{From: []string{"host"}, Ports: []int{80}}},
+		{name: "bad proto", allow: FilterAllow{From: []string{"host"}, Proto: "icmp"}},
+		{name: "bad port", allow: FilterAllow{From: []string{"host"}, Proto: "tcp", Ports: []int{70000}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Filter{
+				Bridge:  "bridge1",
+				Isolate: true,
+				HostIPs: []net.IP{net.ParseIP("192.168.99.1")},
+				Members: []FilterMember{
+					{Name: "web", Interface: "tap0", Allow: []FilterAllow{tt.allow}},
+					{Name: "db", Interface: "tap1"},
+				},
+			}
+			if _, err := f.Rules(); err == nil {
+				t.Errorf("Filter.Rules() want error")
+			}
+		})
+	}
+}
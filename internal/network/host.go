@@ -0,0 +1,330 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HostConfig is configuration of the host itself, eg. routes to networks
+// behind VMs and sysctls enabling forwarding. Changes are recorded in
+// StateFile, so only changes made by hkmgr are reverted.
+type HostConfig struct {
+	Routes    []Route
+	Sysctls   []Sysctl
+	StateFile string
+}
+
+// Route is a route to Dest via Gateway, or via Interface if Gateway is nil.
+type Route struct {
+	Dest      *net.IPNet
+	Gateway   net.IP
+	Interface string
+}
+
+// Sysctl is a sysctl variable and the value to set it to.
+type Sysctl struct {
+	Name  string
+	Value string
+}
+
+// hostState is the record of changes made to the host.
+type hostState struct {
+	Routes  []string          `json:"routes,omitempty"`  // Destinations of routes added
+	Sysctls map[string]string `json:"sysctls,omitempty"` // Values of sysctls before they were set
+}
+
+// hostOutput runs a command reading host configuration.
+var hostOutput = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	return string(out), err
+}
+
+// hostRun runs a command changing host configuration.
+var hostRun = func(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	fmt.Printf("cmd: %s\n", strings.Join(cmd.Args, " "))
+	return cmd.Run()
+}
+
+func (r Route) String() string {
+	if r.Gateway != nil {
+		return fmt.Sprintf("%s via %s", r.Dest, r.Gateway)
+	}
+	return fmt.Sprintf("%s via %s", r.Dest, r.Interface)
+}
+
+// Apply idempotently adds the routes and sets the sysctls. Routes and sysctls
+// changed by a previous Apply that are no longer configured are reverted.
+// Existing routes to a destination that weren't added by hkmgr are left as
+// is, and reported if they differ.
+func (h *HostConfig) Apply() error {
+	state, err := h.readState()
+	if err != nil {
+		return err
+	}
+
+	var routes []string
+	for _, r := range h.Routes {
+		added := contains(state.Routes, r.Dest.String())
+		cur, err := getRoute(r.Dest)
+		if err != nil {
+			return err
+		}
+		if cur != nil && routeMatches(cur, r) {
+			if added {
+				routes = append(routes, r.Dest.String())
+			}
+			continue
+		}
+		if cur != nil {
+			if !added {
+				return fmt.Errorf("route to %s already exists via %s, not replacing it with %s", r.Dest, cur.via(), r)
+			}
+			if err := hostRun("route", routeArgs("delete", r.Dest, nil, "")...); err != nil {
+				return err
+			}
+		}
+		routes = append(routes, r.Dest.String())
+		state.Routes = appendMissing(state.Routes, r.Dest.String())
+		if err := hostRun("route", routeArgs("add", r.Dest, r.Gateway, r.Interface)...); err != nil {
+			h.writeState(state)
+			return fmt.Errorf("adding route to %s, %v", r, err)
+		}
+	}
+
+	sysctls := map[string]string{}
+	for _, s := range h.Sysctls {
+		out, err := hostOutput("sysctl", "-n", s.Name)
+		if err != nil {
+			return fmt.Errorf("reading sysctl %s, %v", s.Name, err)
+		}
+		cur := strings.TrimSpace(out)
+		orig, changed := state.Sysctls[s.Name]
+		if changed {
+			sysctls[s.Name] = orig
+		}
+		if cur == s.Value {
+			continue
+		}
+		if !changed {
+			sysctls[s.Name] = cur
+			state.Sysctls[s.Name] = cur
+		}
+		if err := hostRun("sysctl", "-w", s.Name+"="+s.Value); err != nil {
+			h.writeState(state)
+			return fmt.Errorf("setting sysctl %s, %v", s.Name, err)
+		}
+	}
+
+	// Revert changes which are no longer configured.
+	for _, dest := range state.Routes {
+		if !contains(routes, dest) {
+			if err := revertRoute(dest); err != nil {
+				return err
+			}
+		}
+	}
+	for name, orig := range state.Sysctls {
+		if _, ok := sysctls[name]; !ok {
+			if err := hostRun("sysctl", "-w", name+"="+orig); err != nil {
+				return fmt.Errorf("reverting sysctl %s, %v", name, err)
+			}
+		}
+	}
+
+	return h.writeState(hostState{Routes: routes, Sysctls: sysctls})
+}
+
+// Revert deletes the routes added and restores the sysctls set by Apply,
+// regardless of the current configuration.
+func (h *HostConfig) Revert() error {
+	state, err := h.readState()
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, dest := range state.Routes {
+		if err := revertRoute(dest); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for name, orig := range state.Sysctls {
+		if err := hostRun("sysctl", "-w", name+"="+orig); err != nil {
+			errs = append(errs, fmt.Sprintf("reverting sysctl %s, %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	if err := os.Remove(h.StateFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (h *HostConfig) readState() (hostState, error) {
+	state := hostState{Sysctls: map[string]string{}}
+	data, err := ioutil.ReadFile(h.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("reading %s, %v", h.StateFile, err)
+	}
+	if state.Sysctls == nil {
+		state.Sysctls = map[string]string{}
+	}
+	return state, nil
+}
+
+func (h *HostConfig) writeState(state hostState) error {
+	if len(state.Routes) == 0 && len(state.Sysctls) == 0 {
+		if err := os.Remove(h.StateFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(h.StateFile), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(h.StateFile, data, 0644)
+}
+
+// revertRoute deletes a route added by Apply, ignoring routes that have since
+// been removed.
+func revertRoute(dest string) error {
+	_, cidr, err := net.ParseCIDR(dest)
+	if err != nil {
+		return fmt.Errorf("reverting route to %s, %v", dest, err)
+	}
+	cur, err := getRoute(cidr)
+	if err != nil {
+		return err
+	}
+	if cur == nil {
+		return nil
+	}
+	if err := hostRun("route", routeArgs("delete", cidr, nil, "")...); err != nil {
+		return fmt.Errorf("reverting route to %s, %v", dest, err)
+	}
+	return nil
+}
+
+// routeEntry is a route as reported by route get.
+type routeEntry struct {
+	Dest      string
+	Mask      net.IP
+	Gateway   string
+	Interface string
+}
+
+func (e *routeEntry) via() string {
+	if e.Gateway != "" {
+		return e.Gateway
+	}
+	return e.Interface
+}
+
+// getRoute returns the route to exactly dest, or nil if the destination is
+// only reachable by a less specific route or not at all.
+func getRoute(dest *net.IPNet) (*routeEntry, error) {
+	out, err := hostOutput("route", routeArgs("get", dest, nil, "")...)
+	if err != nil {
+		// route get fails when there's no route to the destination.
+		return nil, nil
+	}
+	e := parseRouteGet(out)
+	if e.Dest != dest.IP.String() {
+		return nil, nil
+	}
+	if e.Mask != nil && !e.Mask.Equal(net.IP(dest.Mask)) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// parseRouteGet parses output from route get, eg.
+//
+//	   route to: 10.1.0.0
+//	destination: 10.1.0.0
+//	       mask: 255.255.0.0
+//	    gateway: 192.168.99.2
+//	  interface: bridge1
+func parseRouteGet(out string) *routeEntry {
+	e := routeEntry{}
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "destination":
+			e.Dest = val
+		case "mask":
+			e.Mask = net.ParseIP(val)
+		case "gateway":
+			e.Gateway = val
+		case "interface":
+			e.Interface = val
+		}
+	}
+	return &e
+}
+
+// routeMatches reports if an existing route goes via the gateway or
+// interface of r.
+func routeMatches(e *routeEntry, r Route) bool {
+	if r.Gateway != nil {
+		return net.ParseIP(e.Gateway).Equal(r.Gateway)
+	}
+	return e.Interface == r.Interface
+}
+
+// routeArgs returns arguments to route, eg.
+// -n add -net 10.1.0.0/16 192.168.99.2 or
+// -n add -inet6 -net fd00:1::/64 -interface bridge1.
+func routeArgs(action string, dest *net.IPNet, gateway net.IP, iface string) []string {
+	args := []string{"-n", action}
+	if dest.IP.To4() == nil {
+		args = append(args, "-inet6")
+	}
+	args = append(args, "-net", dest.String())
+	if gateway != nil {
+		args = append(args, gateway.String())
+	} else if iface != "" {
+		args = append(args, "-interface", iface)
+	}
+	return args
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendMissing(list []string, s string) []string {
+	if contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
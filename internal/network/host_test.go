@@ -0,0 +1,172 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeHost is a route table and sysctls standing in for the host.
+type fakeHost struct {
+	routes  map[string]string // destination to gateway or interface
+	sysctls map[string]string
+	cmds    []string
+}
+
+func (f *fakeHost) output(name string, args ...string) (string, error) {
+	switch name {
+	case "sysctl":
+		v, ok := f.sysctls[args[len(args)-1]]
+		if !ok {
+			return "", fmt.Errorf("unknown oid")
+		}
+		return v + "\n", nil
+	case "route":
+		dest := args[len(args)-1]
+		via, ok := f.routes[dest]
+		if !ok {
+			return "", fmt.Errorf("not in table")
+		}
+		ip, cidr, _ := net.ParseCIDR(dest)
+		out := fmt.Sprintf("   route to: %s\ndestination: %s\n       mask: %s\n", ip, ip, net.IP(cidr.Mask))
+		if net.ParseIP(via) != nil {
+			return out + fmt.Sprintf("    gateway: %s\n  interface: en0\n", via), nil
+		}
+		return out + fmt.Sprintf("  interface: %s\n", via), nil
+	}
+	return "", fmt.Errorf("unexpected command %s", name)
+}
+
+func (f *fakeHost) run(name string, args ...string) error {
+	f.cmds = append(f.cmds, name+" "+strings.Join(args, " "))
+	switch name {
+	case "sysctl":
+		kv := strings.SplitN(args[1], "=", 2)
+		f.sysctls[kv[0]] = kv[1]
+	case "route":
+		i := 0
+		for args[i] != "-net" {
+			i++
+		}
+		switch args[1] {
+		case "add":
+			f.routes[args[i+1]] = args[len(args)-1]
+		case "delete":
+			delete(f.routes, args[i+1])
+		}
+	}
+	return nil
+}
+
+func TestHostConfig(t *testing.T) {
+	origOutput, origRun := hostOutput, hostRun
+	defer func() { hostOutput, hostRun = origOutput, origRun }()
+
+	dir, err := ioutil.TempDir("", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := &fakeHost{
+		routes:  map[string]string{"10.9.0.0/16": "192.168.1.1"},
+		sysctls: map[string]string{"net.inet.ip.forwarding": "0", "net.inet6.ip6.forwarding": "1"},
+	}
+	hostOutput, hostRun = host.output, host.run
+
+	_, net1, _ := net.ParseCIDR("10.1.0.0/16")
+	_, net2, _ := net.ParseCIDR("fd00:1::/64")
+	_, net9, _ := net.ParseCIDR("10.9.0.0/16")
+	h := &HostConfig{
+		Routes: []Route{
+			{Dest: net1, Gateway: net.ParseIP("192.168.99.2")},
+			{Dest: net2, Interface: "bridge1"},
+			{Dest: net9, Gateway: net.ParseIP("192.168.1.1")},
+		},
+		Sysctls: []Sysctl{
+			{Name: "net.inet.ip.forwarding", Value: "1"},
+			{Name: "net.inet6.ip6.forwarding", Value: "1"},
+		},
+		StateFile: filepath.Join(dir, "host", "changes.json"),
+	}
+
+	if err := h.Apply(); err != nil {
+		t.Fatalf("HostConfig.Apply() error = %v", err)
+	}
+	want := []string{
+		"route -n add -net 10.1.0.0/16 192.168.99.2",
+		"route -n add -inet6 -net fd00:1::/64 -interface bridge1",
+		"sysctl -w net.inet.ip.forwarding=1",
+	}
+	if !reflect.DeepEqual(host.cmds, want) {
+		t.Errorf("HostConfig.Apply() ran %q, want %q", host.cmds, want)
+	}
+
+	// Applying again changes nothing.
+	host.cmds = nil
+	if err := h.Apply(); err != nil {
+		t.Fatalf("HostConfig.Apply() error = %v", err)
+	}
+	if len(host.cmds) != 0 {
+		t.Errorf("HostConfig.Apply() again ran %q, want nothing", host.cmds)
+	}
+
+	// Routes and sysctls no longer configured are reverted.
+	host.cmds = nil
+	h.Routes = h.Routes[1:]
+	h.Sysctls = nil
+	if err := h.Apply(); err != nil {
+		t.Fatalf("HostConfig.Apply() error = %v", err)
+	}
+	want = []string{
+		"route -n delete -net 10.1.0.0/16",
+		"sysctl -w net.inet.ip.forwarding=0",
+	}
+	if !reflect.DeepEqual(host.cmds, want) {
+		t.Errorf("HostConfig.Apply() with fewer changes ran %q, want %q", host.cmds, want)
+	}
+
+	// A different existing route which wasn't added by hkmgr isn't replaced.
+	host.cmds = nil
+	h.Routes = []Route{{Dest: net9, Gateway: net.ParseIP("192.168.99.3")}}
+	if err := h.Apply(); err == nil {
+		t.Errorf("HostConfig.Apply() replacing an existing route, want error")
+	}
+
+	// Revert only removes what hkmgr changed.
+	host.cmds = nil
+	if err := h.Revert(); err != nil {
+		t.Fatalf("HostConfig.Revert() error = %v", err)
+	}
+	want = []string{"route -n delete -inet6 -net fd00:1::/64"}
+	if !reflect.DeepEqual(host.cmds, want) {
+		t.Errorf("HostConfig.Revert() ran %q, want %q", host.cmds, want)
+	}
+	if _, ok := host.routes["10.9.0.0/16"]; !ok {
+		t.Errorf("HostConfig.Revert() removed a route hkmgr didn't add")
+	}
+	if _, err := os.Stat(h.StateFile); !os.IsNotExist(err) {
+		t.Errorf("HostConfig.Revert() left %s", h.StateFile)
+	}
+}
+
+func Test_parseRouteGet(t *testing.T) {
+	out := `   route to: 10.1.0.0
+destination: 10.1.0.0
+       mask: 255.255.0.0
+    gateway: 192.168.99.2
+  interface: bridge1
+      flags: <UP,GATEWAY,DONE,STATIC,PRCLONING>
+ recvpipe  sendpipe  ssthresh  rtt,msec    rttvar  hopcount      mtu     expire
+       0         0         0         0         0         0      1500         0
+`
+	want := &routeEntry{Dest: "10.1.0.0", Mask: net.ParseIP("255.255.0.0"), Gateway: "192.168.99.2", Interface: "bridge1"}
+	if got := parseRouteGet(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRouteGet() = %+v, want %+v", got, want)
+	}
+}
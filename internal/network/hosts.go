@@ -0,0 +1,138 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultHostsFile is the hosts file of the host.
+const DefaultHostsFile = "/etc/hosts"
+
+// HostsEntry maps a name to an address in a hosts file.
+type HostsEntry struct {
+	IP   net.IP
+	Name string
+}
+
+// HostsFile is a block of entries managed by hkmgr in a hosts file, between
+// "# BEGIN hkmgr <ID>" and "# END hkmgr <ID>" lines, so that each
+// configuration manages its own block.
+type HostsFile struct {
+	Path    string // DefaultHostsFile if empty
+	ID      string // eg. the path of the configuration
+	Entries []HostsEntry
+}
+
+func (h *HostsFile) path() string {
+	if h.Path == "" {
+		return DefaultHostsFile
+	}
+	return h.Path
+}
+
+// Sync replaces the block in the hosts file with the entries, leaving the
+// other lines as is. The block is removed when there are no entries. The
+// file is only written if it changes, by writing a temporary file and
+// renaming it, so the file is never partially written.
+func (h *HostsFile) Sync() error {
+	// Rename the target of a symlink, eg. /etc/hosts on macOS, rather than
+	// replacing the symlink.
+	path, err := filepath.EvalSymlinks(h.path())
+	if os.IsNotExist(err) {
+		path = h.path()
+	} else if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	updated, err := h.update(data)
+	if err != nil {
+		return fmt.Errorf("updating %s, %v", h.path(), err)
+	}
+	if bytes.Equal(data, updated) {
+		return nil
+	}
+	fmt.Printf("updating %s\n", h.path())
+
+	tmp := path + ".hkmgr"
+	if err := ioutil.WriteFile(tmp, updated, mode); err != nil {
+		return err
+	}
+	// WriteFile doesn't set the mode of existing files, or beyond the umask.
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// update returns the hosts file data with the block replaced. A new block is
+// appended to the end of the file.
+func (h *HostsFile) update(data []byte) ([]byte, error) {
+	begin, end := "# BEGIN hkmgr "+h.ID, "# END hkmgr "+h.ID
+
+	var out bytes.Buffer
+	var inBlock, found bool
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == begin:
+			if inBlock || found {
+				return nil, fmt.Errorf("found more than one %q line", begin)
+			}
+			inBlock, found = true, true
+			h.writeBlock(&out)
+		case strings.TrimSpace(line) == end:
+			if !inBlock {
+				return nil, fmt.Errorf("found %q without %q", end, begin)
+			}
+			inBlock = false
+		case !inBlock:
+			out.WriteString(line + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inBlock {
+		return nil, fmt.Errorf("found %q without %q", begin, end)
+	}
+	if !found {
+		if len(h.Entries) == 0 {
+			return data, nil
+		}
+		h.writeBlock(&out)
+	}
+	return out.Bytes(), nil
+}
+
+// writeBlock writes the block with the entries, or nothing if there are none.
+func (h *HostsFile) writeBlock(w *bytes.Buffer) {
+	if len(h.Entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# BEGIN hkmgr %s\n", h.ID)
+	for _, e := range h.Entries {
+		fmt.Fprintf(w, "%s\t%s\n", e.IP, e.Name)
+	}
+	fmt.Fprintf(w, "# END hkmgr %s\n", h.ID)
+}
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const hostsFile = `##
+# Host Database
+##
+127.0.0.1	localhost
+255.255.255.255	broadcasthost
+::1             localhost
+`
+
+func TestHostsFile_update(t *testing.T) {
+	entries := []HostsEntry{
+		{IP: net.ParseIP("192.168.99.2"), Name: "ros-vm1"},
+		{IP: net.ParseIP("fd00:99::3"), Name: "ros-vm2"},
+	}
+	block := "# BEGIN hkmgr /vms/hkmgr.toml\n192.168.99.2\tros-vm1\nfd00:99::3\tros-vm2\n# END hkmgr /vms/hkmgr.toml\n"
+	otherBlock := "# BEGIN hkmgr /other/hkmgr.toml\n192.168.98.2\tdb\n# END hkmgr /other/hkmgr.toml\n"
+
+	tests := []struct {
+		name    string
+		data    string
+		entries []HostsEntry
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "add block",
+			data:    hostsFile,
+			entries: entries,
+			want:    hostsFile + block,
+		},
+		{
+			name:    "add block without trailing newline",
+			data:    "127.0.0.1\tlocalhost",
+			entries: entries,
+			want:    "127.0.0.1\tlocalhost\n" + block,
+		},
+		{
+			name:    "replace block",
+			data:    hostsFile + "# BEGIN hkmgr /vms/hkmgr.toml\n192.168.99.9\told\n# END hkmgr /vms/hkmgr.toml\n" + "10.0.0.1\tafter\n",
+			entries: entries,
+			want:    hostsFile + block + "10.0.0.1\tafter\n",
+		},
+		{
+			name:    "other configuration",
+			data:    hostsFile + otherBlock,
+			entries: entries,
+			want:    hostsFile + otherBlock + block,
+		},
+		{
+			name: "remove block",
+			data: hostsFile + block,
+			want: hostsFile,
+		},
+		{
+			name: "nothing to remove",
+			data: "127.0.0.1\tlocalhost",
+			want: "127.0.0.1\tlocalhost",
+		},
+		{
+			name:    "unterminated block",
+			data:    hostsFile + "# BEGIN hkmgr /vms/hkmgr.toml\n",
+			entries: entries,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate block",
+			data:    hostsFile + block + block,
+			entries: entries,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HostsFile{ID: "/vms/hkmgr.toml", Entries: tt.entries}
+			got, err := h.update([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HostsFile.update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("HostsFile.update() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostsFile_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The target of a symlinked hosts file is updated, keeping its mode.
+	target := filepath.Join(dir, "hosts.real")
+	if err := ioutil.WriteFile(target, []byte(hostsFile), 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "hosts")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HostsFile{Path: link, ID: "test", Entries: []HostsEntry{{IP: net.ParseIP("192.168.99.2"), Name: "ros-vm1"}}}
+	if err := h.Sync(); err != nil {
+		t.Fatalf("HostsFile.Sync() error = %v", err)
+	}
+
+	got, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hostsFile + "# BEGIN hkmgr test\n192.168.99.2\tros-vm1\n# END hkmgr test\n"
+	if string(got) != want {
+		t.Errorf("HostsFile.Sync() wrote %q, want %q", got, want)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("HostsFile.Sync() replaced the symlink, %v", err)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("HostsFile.Sync() changed the mode, %v", err)
+	}
+}
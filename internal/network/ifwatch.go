@@ -0,0 +1,114 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMemberTimeout is how long to wait for members of a bridge, eg. tap
+// interfaces created by hyperkit, to appear.
+const DefaultMemberTimeout = 10 * time.Second
+
+// pollInterval is how often interfaces are checked without a watcher, and as
+// a fallback in case a change notification is missed.
+var pollInterval = 250 * time.Millisecond
+
+// interfaceExists reports if a network interface exists.
+var interfaceExists = func(name string) bool {
+	_, err := net.InterfaceByName(name)
+	return err == nil
+}
+
+// interfaceEvents returns a channel signalled when interfaces may have
+// changed, and a function to stop watching.
+var interfaceEvents = watchInterfaces
+
+// MemberResult is the result of adding a member to a bridge.
+type MemberResult struct {
+	Member string
+	Err    error // nil if the member was added
+}
+
+// MembersError reports bridge members that couldn't be added. Members that
+// were found are still added.
+type MembersError struct {
+	Bridge  string
+	Results []MemberResult
+}
+
+func (e *MembersError) Error() string {
+	var failed []string
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.Member, r.Err))
+		}
+	}
+	return fmt.Sprintf("adding members to bridge %s failed, %s", e.Bridge, strings.Join(failed, ", "))
+}
+
+// Failed returns the members that couldn't be added.
+func (e *MembersError) Failed() []string {
+	var failed []string
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed = append(failed, r.Member)
+		}
+	}
+	return failed
+}
+
+// waitInterfaces waits for all of the named interfaces to exist, checking
+// the pending ones together whenever interfaces change, until timeout. The
+// results are in the order of names.
+func waitInterfaces(names []string, timeout time.Duration) []MemberResult {
+	events, stop := interfaceEvents()
+	defer stop()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	pending := map[string]bool{}
+	for _, name := range names {
+		pending[name] = true
+	}
+
+Wait:
+	for {
+		for name := range pending {
+			if interfaceExists(name) {
+				delete(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		select {
+		case <-events:
+		case <-ticker.C:
+		case <-deadline.C:
+			break Wait
+		}
+	}
+
+	results := make([]MemberResult, len(names))
+	for i, name := range names {
+		results[i] = MemberResult{Member: name}
+		if pending[name] {
+			results[i].Err = fmt.Errorf("not found after %s", timeout)
+		}
+	}
+	return results
+}
+
+// sortedMembers returns a sorted copy of members, so they're added in a
+// deterministic order.
+func sortedMembers(members []string) []string {
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,49 @@
+//go:build darwin
+// +build darwin
+
+package network
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchInterfaces signals on the returned channel when the kernel announces
+// a change to an interface or address, by reading a routing socket. If the
+// socket can't be opened the channel is never signalled, leaving
+// waitInterfaces to poll.
+func watchInterfaces() (<-chan struct{}, func()) {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, func() {}
+	}
+	// A non-blocking fd is pollable, so Close interrupts the pending Read.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, func() {}
+	}
+	f := os.NewFile(uintptr(fd), "route")
+
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			// rtm_type is the fourth byte of every routing message.
+			if n < 4 {
+				continue
+			}
+			switch buf[3] {
+			case syscall.RTM_IFINFO, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return events, func() { f.Close() }
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+// +build !darwin
+
+package network
+
+// watchInterfaces returns a channel that's never signalled, leaving
+// waitInterfaces to poll, as there's no routing socket to watch.
+func watchInterfaces() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_waitInterfaces(t *testing.T) {
+	origExists, origEvents, origPoll := interfaceExists, interfaceEvents, pollInterval
+	defer func() {
+		interfaceExists, interfaceEvents, pollInterval = origExists, origEvents, origPoll
+	}()
+
+	var mu sync.Mutex
+	up := map[string]bool{"tap0": true}
+	interfaceExists = func(name string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return up[name]
+	}
+	events := make(chan struct{})
+	interfaceEvents = func() (<-chan struct{}, func()) { return events, func() {} }
+	pollInterval = time.Hour
+
+	// tap1 and tap2 appear together, tap3 never does.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		up["tap1"], up["tap2"] = true, true
+		mu.Unlock()
+		events <- struct{}{}
+	}()
+
+	start := time.Now()
+	results := waitInterfaces([]string{"tap0", "tap1", "tap2", "tap3"}, 200*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitInterfaces() took %s, want about the 200ms timeout", elapsed)
+	}
+
+	for i, want := range []string{"tap0", "tap1", "tap2"} {
+		if results[i].Member != want || results[i].Err != nil {
+			t.Errorf("waitInterfaces() result %d = %+v, want %s found", i, results[i], want)
+		}
+	}
+	if results[3].Member != "tap3" || results[3].Err == nil {
+		t.Errorf("waitInterfaces() result 3 = %+v, want tap3 not found", results[3])
+	}
+
+	err := &MembersError{Bridge: "bridge1", Results: results}
+	if got, want := err.Error(), "adding members to bridge bridge1 failed, tap3: not found after 200ms"; got != want {
+		t.Errorf("MembersError.Error() = %s, want %s", got, want)
+	}
+	if got := err.Failed(); len(got) != 1 || got[0] != "tap3" {
+		t.Errorf("MembersError.Failed() = %v, want [tap3]", got)
+	}
+}
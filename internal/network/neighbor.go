@@ -0,0 +1,171 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LeasesPath is the lease database of the macOS DHCP server, bootpd, which
+// serves vmnet and Internet Sharing networks.
+var LeasesPath = "/var/db/dhcpd_leases"
+
+// Lease is a DHCP lease from the bootpd lease database.
+type Lease struct {
+	Name    string // Hostname sent by the client
+	IP      net.IP
+	HWAddr  net.HardwareAddr
+	Expires time.Time
+}
+
+// Neighbor is an entry of the ARP table.
+type Neighbor struct {
+	IP        net.IP
+	HWAddr    net.HardwareAddr
+	Interface string
+}
+
+// FindIP returns the IP address of the interface with hardware address
+// hwaddr, from its most recent DHCP lease or else the ARP table.
+func FindIP(hwaddr net.HardwareAddr) (net.IP, error) {
+	if f, err := os.Open(LeasesPath); err == nil {
+		leases, err := ParseLeases(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		var found *Lease
+		for i, l := range leases {
+			if bytes.Equal(l.HWAddr, hwaddr) && (found == nil || l.Expires.After(found.Expires)) {
+				found = &leases[i]
+			}
+		}
+		if found != nil {
+			return found.IP, nil
+		}
+	}
+
+	out, err := exec.Command("arp", "-an").Output()
+	if err != nil {
+		return nil, fmt.Errorf("arp -an failed, %v", err)
+	}
+	neighbors, err := ParseARP(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range neighbors {
+		if bytes.Equal(n.HWAddr, hwaddr) {
+			return n.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no address found for %s", hwaddr)
+}
+
+// ParseLeases parses the bootpd lease database, eg.
+//
+//	{
+//		name=vm1
+//		ip_address=192.168.64.2
+//		hw_address=1,5a:94:ef:e4:c:ee
+//		identifier=1,5a:94:ef:e4:c:ee
+//		lease=0x5e9a1b2c
+//	}
+func ParseLeases(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	var l *Lease
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case line == "{":
+			l = &Lease{}
+		case line == "}":
+			if l == nil {
+				return nil, fmt.Errorf("leases line %d: unexpected }", n)
+			}
+			if l.IP != nil && l.HWAddr != nil {
+				leases = append(leases, *l)
+			}
+			l = nil
+		case l == nil:
+			return nil, fmt.Errorf("leases line %d: field outside of a lease", n)
+		default:
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("leases line %d: expected key=value", n)
+			}
+			switch kv[0] {
+			case "name":
+				l.Name = kv[1]
+			case "ip_address":
+				l.IP = net.ParseIP(kv[1])
+			case "hw_address":
+				// Hardware type 1 is ethernet, others don't have a MAC.
+				hw := strings.SplitN(kv[1], ",", 2)
+				if len(hw) == 2 && hw[0] == "1" {
+					mac, err := parseMAC(hw[1])
+					if err != nil {
+						return nil, fmt.Errorf("leases line %d: %v", n, err)
+					}
+					l.HWAddr = mac
+				}
+			case "lease":
+				secs, err := strconv.ParseInt(kv[1], 0, 64)
+				if err != nil {
+					return nil, fmt.Errorf("leases line %d: %v", n, err)
+				}
+				l.Expires = time.Unix(secs, 0)
+			}
+		}
+	}
+	return leases, scanner.Err()
+}
+
+// ParseARP parses the output of arp -an, eg.
+//
+//	? (192.168.64.2) at 5a:94:ef:e4:c:ee on bridge100 ifscope [ethernet]
+//
+// Incomplete entries are skipped.
+func ParseARP(r io.Reader) ([]Neighbor, error) {
+	var neighbors []Neighbor
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[2] != "at" || fields[4] != "on" {
+			continue
+		}
+		ip := net.ParseIP(strings.Trim(fields[1], "()"))
+		mac, err := parseMAC(fields[3])
+		if ip == nil || err != nil {
+			continue
+		}
+		neighbors = append(neighbors, Neighbor{IP: ip, HWAddr: mac, Interface: fields[5]})
+	}
+	return neighbors, scanner.Err()
+}
+
+// parseMAC parses a MAC address as printed by macOS, which drops leading
+// zeros of each octet, eg. 5a:94:ef:e4:c:ee.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	octets := strings.Split(s, ":")
+	if len(octets) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %s", s)
+	}
+	mac := make(net.HardwareAddr, 6)
+	for i, o := range octets {
+		b, err := strconv.ParseUint(o, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address %s", s)
+		}
+		mac[i] = byte(b)
+	}
+	return mac, nil
+}
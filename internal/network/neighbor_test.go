@@ -0,0 +1,95 @@
+package network
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustMAC(s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		panic(err)
+	}
+	return mac
+}
+
+func TestParseLeases(t *testing.T) {
+	f, err := os.Open("testdata/dhcpd_leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := ParseLeases(f)
+	if err != nil {
+		t.Fatalf("ParseLeases() error = %v", err)
+	}
+	want := []Lease{
+		{Name: "ros-vm1", IP: net.ParseIP("192.168.64.3"), HWAddr: mustMAC("5a:94:ef:e4:0c:ee"), Expires: time.Unix(0x5e9a1b2c, 0)},
+		{Name: "ros-vm1", IP: net.ParseIP("192.168.64.2"), HWAddr: mustMAC("5a:94:ef:e4:0c:ee"), Expires: time.Unix(0x5e9a0000, 0)},
+		{Name: "web", IP: net.ParseIP("192.168.64.4"), HWAddr: mustMAC("a2:00:03:4b:5c:0d"), Expires: time.Unix(0x5e9a2000, 0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLeases() = %v, want %v", got, want)
+	}
+}
+
+func TestParseLeasesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "unexpected close", input: "}\n"},
+		{name: "field outside lease", input: "name=vm1\n"},
+		{name: "missing value", input: "{\n\tname\n}\n"},
+		{name: "bad mac", input: "{\n\thw_address=1,zz:0:0:0:0:0\n}\n"},
+		{name: "bad lease", input: "{\n\tlease=soon\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseLeases(strings.NewReader(tt.input)); err == nil {
+				t.Errorf("ParseLeases() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestParseARP(t *testing.T) {
+	f, err := os.Open("testdata/arp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := ParseARP(f)
+	if err != nil {
+		t.Fatalf("ParseARP() error = %v", err)
+	}
+	want := []Neighbor{
+		{IP: net.ParseIP("192.168.1.1"), HWAddr: mustMAC("00:11:22:33:44:55"), Interface: "en0"},
+		{IP: net.ParseIP("192.168.64.5"), HWAddr: mustMAC("a2:00:03:4b:5c:0e"), Interface: "bridge100"},
+		{IP: net.ParseIP("192.168.99.10"), HWAddr: mustMAC("02:aa:bb:cc:dd:01"), Interface: "bridge1"},
+		{IP: net.ParseIP("224.0.0.251"), HWAddr: mustMAC("01:00:5e:00:00:fb"), Interface: "en0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseARP() = %v, want %v", got, want)
+	}
+}
+
+func TestFindIP(t *testing.T) {
+	LeasesPath = "testdata/dhcpd_leases"
+	defer func() { LeasesPath = "/var/db/dhcpd_leases" }()
+
+	// The most recent lease of the MAC is used.
+	ip, err := FindIP(mustMAC("5a:94:ef:e4:0c:ee"))
+	if err != nil {
+		t.Fatalf("FindIP() error = %v", err)
+	}
+	if want := net.ParseIP("192.168.64.3"); !ip.Equal(want) {
+		t.Errorf("FindIP() = %s, want %s", ip, want)
+	}
+}
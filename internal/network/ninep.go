@@ -0,0 +1,255 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// A minimal 9P2000 client, enough to drive vpnkit's port forwarding control
+// filesystem, which is served on its --port socket.
+
+const (
+	p9Tversion = 100
+	p9Rversion = 101
+	p9Tauth    = 102
+	p9Tattach  = 104
+	p9Rerror   = 107
+	p9Tflush   = 108
+	p9Twalk    = 110
+	p9Topen    = 112
+	p9Tcreate  = 114
+	p9Tread    = 116
+	p9Twrite   = 118
+	p9Tclunk   = 120
+	p9Tremove  = 122
+	p9Tstat    = 124
+	p9Twstat   = 126
+
+	p9NoTag = 0xffff
+	p9NoFid = 0xffffffff
+	p9Msize = 8192
+
+	p9OREAD   = 0
+	p9OWRITE  = 1
+	p9ORDWR   = 2
+	p9OEXEC   = 3
+	p9OTRUNC  = 0x10
+	p9ORCLOSE = 0x40
+	p9DMDIR   = 0x80000000
+	p9QTDIR   = 0x80
+	p9RootFd  = 0
+)
+
+// p9Error is an error returned by the server in an Rerror message, eg. when a
+// walk finds no file at the path, as opposed to failing to reach the server.
+type p9Error string
+
+func (e p9Error) Error() string {
+	return string(e)
+}
+
+type p9Client struct {
+	conn    net.Conn
+	nextFid uint32
+}
+
+// dial9P connects to a 9P server on a unix socket and attaches to its root.
+func dial9P(path string) (*p9Client, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &p9Client{conn: conn, nextFid: p9RootFd + 1}
+
+	var b p9Buf
+	b.u32(p9Msize)
+	b.str("9P2000")
+	if _, err := c.rpc(p9Tversion, p9NoTag, b.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	b.Reset()
+	b.u32(p9RootFd)
+	b.u32(p9NoFid)
+	b.str("hkmgr")
+	b.str("")
+	if _, err := c.rpc(p9Tattach, 1, b.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *p9Client) Close() error {
+	return c.conn.Close()
+}
+
+// rpc sends a T-message and returns the body of the matching R-message.
+func (c *p9Client) rpc(typ uint8, tag uint16, body []byte) ([]byte, error) {
+	var msg p9Buf
+	msg.u32(uint32(4 + 1 + 2 + len(body)))
+	msg.u8(typ)
+	msg.u16(tag)
+	msg.Write(body)
+	if _, err := c.conn.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size uint32
+	if err := binary.Read(c.conn, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 7 || size > p9Msize {
+		return nil, fmt.Errorf("9p: invalid message size %d", size)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return nil, err
+	}
+
+	rtype, rbody := resp[0], resp[3:]
+	if rtype == p9Rerror {
+		ename, _ := readStr(rbody)
+		return nil, p9Error(ename)
+	}
+	if rtype != typ+1 {
+		return nil, fmt.Errorf("9p: unexpected response type %d to %d", rtype, typ)
+	}
+	return rbody, nil
+}
+
+// walk returns a new fid for the path relative to the root.
+func (c *p9Client) walk(path ...string) (uint32, error) {
+	fid := c.nextFid
+	c.nextFid++
+
+	var b p9Buf
+	b.u32(p9RootFd)
+	b.u32(fid)
+	b.u16(uint16(len(path)))
+	for _, name := range path {
+		b.str(name)
+	}
+	resp, err := c.rpc(p9Twalk, 1, b.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 2 || int(binary.LittleEndian.Uint16(resp)) != len(path) {
+		return 0, p9Error(fmt.Sprintf("%v not found", path))
+	}
+	return fid, nil
+}
+
+func (c *p9Client) clunk(fid uint32) error {
+	var b p9Buf
+	b.u32(fid)
+	_, err := c.rpc(p9Tclunk, 1, b.Bytes())
+	return err
+}
+
+// mkdir creates the directory name in the root.
+func (c *p9Client) mkdir(name string) error {
+	fid, err := c.walk()
+	if err != nil {
+		return err
+	}
+	var b p9Buf
+	b.u32(fid)
+	b.str(name)
+	b.u32(p9DMDIR | 0755)
+	b.u8(p9OREAD)
+	if _, err := c.rpc(p9Tcreate, 1, b.Bytes()); err != nil {
+		c.clunk(fid)
+		return err
+	}
+	return c.clunk(fid)
+}
+
+// remove removes the file or directory at path.
+func (c *p9Client) remove(path ...string) error {
+	fid, err := c.walk(path...)
+	if err != nil {
+		return err
+	}
+	var b p9Buf
+	b.u32(fid)
+	_, err = c.rpc(p9Tremove, 1, b.Bytes())
+	return err
+}
+
+// writeRead opens the file at path, writes data to it, and returns the
+// response read back from it.
+func (c *p9Client) writeRead(data string, path ...string) (string, error) {
+	fid, err := c.walk(path...)
+	if err != nil {
+		return "", err
+	}
+	defer c.clunk(fid)
+
+	var b p9Buf
+	b.u32(fid)
+	b.u8(p9ORDWR)
+	if _, err := c.rpc(p9Topen, 1, b.Bytes()); err != nil {
+		return "", err
+	}
+
+	b.Reset()
+	b.u32(fid)
+	b.u64(0)
+	b.u32(uint32(len(data)))
+	b.WriteString(data)
+	if _, err := c.rpc(p9Twrite, 1, b.Bytes()); err != nil {
+		return "", err
+	}
+
+	b.Reset()
+	b.u32(fid)
+	b.u64(0)
+	b.u32(p9Msize - 24)
+	resp, err := c.rpc(p9Tread, 1, b.Bytes())
+	if err != nil {
+		return "", err
+	}
+	if len(resp) < 4 {
+		return "", fmt.Errorf("9p: short read response")
+	}
+	count := binary.LittleEndian.Uint32(resp)
+	if int(count) > len(resp)-4 {
+		return "", fmt.Errorf("9p: short read response")
+	}
+	return string(resp[4 : 4+count]), nil
+}
+
+// p9Buf encodes 9P message fields, little endian.
+type p9Buf struct {
+	bytes.Buffer
+}
+
+func (b *p9Buf) u8(v uint8) { b.WriteByte(v) }
+
+func (b *p9Buf) u16(v uint16) { binary.Write(b, binary.LittleEndian, v) }
+
+func (b *p9Buf) u32(v uint32) { binary.Write(b, binary.LittleEndian, v) }
+
+func (b *p9Buf) u64(v uint64) { binary.Write(b, binary.LittleEndian, v) }
+
+func (b *p9Buf) str(s string) {
+	b.u16(uint16(len(s)))
+	b.WriteString(s)
+}
+
+func readStr(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", fmt.Errorf("9p: short string")
+	}
+	n := int(binary.LittleEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", fmt.Errorf("9p: short string")
+	}
+	return string(b[2 : 2+n]), nil
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// hostRunInput runs a command changing host configuration with input on its
+// stdin.
+var hostRunInput = func(input string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	fmt.Printf("cmd: %s <<EOF\n%sEOF\n", strings.Join(cmd.Args, " "), input)
+	return cmd.Run()
+}
+
+// loadAnchor replaces the rules of a pf anchor, and enables pf if it isn't.
+// Anchors under com.apple are evaluated by the default pf.conf of macOS.
+func loadAnchor(anchor string, rules string) error {
+	if err := hostRunInput(rules, "pfctl", "-a", anchor, "-f", "-"); err != nil {
+		return fmt.Errorf("loading pf anchor %s, %v", anchor, err)
+	}
+	out, err := hostOutput("pfctl", "-s", "info")
+	if err != nil || !strings.Contains(out, "Status: Enabled") {
+		if err := hostRun("pfctl", "-e"); err != nil {
+			return fmt.Errorf("enabling pf, %v", err)
+		}
+	}
+	return nil
+}
+
+// flushAnchor removes the rules of a pf anchor.
+func flushAnchor(anchor string) error {
+	if err := hostRun("pfctl", "-a", anchor, "-F", "all"); err != nil {
+		return fmt.Errorf("flushing pf anchor %s, %v", anchor, err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// udpIdleTimeout is how long a UDP client's session to the guest is kept
+// without traffic.
+const udpIdleTimeout = 2 * time.Minute
+
+// Proxy forwards TCP connections and UDP datagrams from host addresses to
+// guests in userspace, so it works on any network the host can reach the
+// guest on.
+type Proxy struct {
+	Forwards []Forward
+
+	mu        sync.Mutex
+	listeners []io.Closer
+}
+
+// Listen binds the host addresses of all forwards.
+func (p *Proxy) Listen() error {
+	for _, f := range p.Forwards {
+		host := net.JoinHostPort(f.HostIP.String(), strconv.Itoa(f.HostPort))
+		var l io.Closer
+		var err error
+		switch f.Proto {
+		case "tcp":
+			l, err = net.Listen("tcp", host)
+		case "udp":
+			l, err = net.ListenPacket("udp", host)
+		default:
+			err = fmt.Errorf("proto %s not supported", f.Proto)
+		}
+		if err != nil {
+			p.Close()
+			return fmt.Errorf("forwarding %s, %v", f.spec(), err)
+		}
+		p.mu.Lock()
+		p.listeners = append(p.listeners, l)
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// Serve proxies the forwards until Close is called. Listen must be called
+// first.
+func (p *Proxy) Serve() {
+	var wg sync.WaitGroup
+	p.mu.Lock()
+	for i, l := range p.listeners {
+		f := p.Forwards[i]
+		guest := net.JoinHostPort(f.GuestIP.String(), strconv.Itoa(f.GuestPort))
+		wg.Add(1)
+		switch l := l.(type) {
+		case net.Listener:
+			go func() {
+				defer wg.Done()
+				serveTCP(l, guest)
+			}()
+		case net.PacketConn:
+			go func() {
+				defer wg.Done()
+				serveUDP(l, guest)
+			}()
+		}
+	}
+	p.mu.Unlock()
+	wg.Wait()
+}
+
+// Close stops listening on the host addresses.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, l := range p.listeners {
+		l.Close()
+	}
+	p.listeners = nil
+	return nil
+}
+
+func serveTCP(l net.Listener, guest string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go proxyTCP(conn, guest)
+	}
+}
+
+func proxyTCP(conn net.Conn, guest string) {
+	defer conn.Close()
+	upstream, err := net.DialTimeout("tcp", guest, 10*time.Second)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	<-done
+	<-done
+}
+
+// serveUDP relays datagrams from each client address over its own
+// connection to the guest, so replies can be returned to the client.
+func serveUDP(l net.PacketConn, guest string) {
+	var mu sync.Mutex
+	sessions := map[string]net.Conn{}
+	buf := make([]byte, 65535)
+	for {
+		n, client, err := l.ReadFrom(buf)
+		if err != nil {
+			mu.Lock()
+			for _, s := range sessions {
+				s.Close()
+			}
+			mu.Unlock()
+			return
+		}
+
+		mu.Lock()
+		upstream, ok := sessions[client.String()]
+		if !ok {
+			upstream, err = net.Dial("udp", guest)
+			if err != nil {
+				mu.Unlock()
+				continue
+			}
+			sessions[client.String()] = upstream
+			go func(client net.Addr, upstream net.Conn) {
+				reply := make([]byte, 65535)
+				for {
+					upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+					n, err := upstream.Read(reply)
+					if err != nil {
+						break
+					}
+					l.WriteTo(reply[:n], client)
+				}
+				mu.Lock()
+				delete(sessions, client.String())
+				mu.Unlock()
+				upstream.Close()
+			}(client, upstream)
+		}
+		mu.Unlock()
+
+		upstream.Write(buf[:n])
+	}
+}
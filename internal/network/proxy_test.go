@@ -0,0 +1,86 @@
+package network
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	tcpGuest, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpGuest.Close()
+	go func() {
+		for {
+			conn, err := tcpGuest.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte("tcp " + line))
+			}()
+		}
+	}()
+
+	udpGuest, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpGuest.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := udpGuest.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpGuest.WriteTo(append([]byte("udp "), buf[:n]...), addr)
+		}
+	}()
+
+	localhost := net.ParseIP("127.0.0.1")
+	p := &Proxy{Forwards: []Forward{
+		{Proto: "tcp", HostIP: localhost, GuestIP: localhost, GuestPort: tcpGuest.Addr().(*net.TCPAddr).Port},
+		{Proto: "udp", HostIP: localhost, GuestIP: localhost, GuestPort: udpGuest.LocalAddr().(*net.UDPAddr).Port},
+	}}
+	if err := p.Listen(); err != nil {
+		t.Fatalf("Proxy.Listen() error = %v", err)
+	}
+	served := make(chan struct{})
+	go func() {
+		p.Serve()
+		close(served)
+	}()
+
+	tcpHost := p.listeners[0].(net.Listener).Addr().String()
+	udpHost := p.listeners[1].(net.PacketConn).LocalAddr().String()
+
+	for _, tt := range []struct{ proto, addr, want string }{
+		{"tcp", tcpHost, "tcp ping\n"},
+		{"udp", udpHost, "udp ping\n"},
+	} {
+		conn, err := net.Dial(tt.proto, tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("ping\n"))
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || got != tt.want {
+			t.Errorf("%s proxy = %q, %v, want %q", tt.proto, got, err, tt.want)
+		}
+	}
+
+	p.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Proxy.Serve() didn't return after Close()")
+	}
+}
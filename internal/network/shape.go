@@ -0,0 +1,115 @@
+package network
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shapePipeBase is the first dummynet pipe number used for shaping, leaving
+// lower numbers to other users of dummynet.
+const shapePipeBase = 10000
+
+// Shaping limits the bandwidth of, and adds delay and loss to, the traffic
+// sent to a VM on its tap interface, eg. to test services under poor network
+// conditions. Traffic from the VM isn't shaped, so a round trip is delayed
+// once, on both macOS and Linux. Zero values aren't limited.
+type Shaping struct {
+	Interface string
+	Bandwidth int64 // Bits per second
+	Delay     time.Duration
+	Loss      float64 // Fraction of packets dropped, eg. 0.01
+}
+
+// ParseBandwidth parses a bandwidth in bits per second, eg. 10Mbit or
+// 512Kbit/s.
+func ParseBandwidth(s string) (int64, error) {
+	txt := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"Gbit", 1000 * 1000 * 1000},
+		{"Mbit", 1000 * 1000},
+		{"Kbit", 1000},
+		{"kbit", 1000},
+		{"bit", 1},
+	}
+	for _, u := range units {
+		if strings.HasSuffix(txt, u.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(txt, u.suffix)), 64)
+			if err != nil || n <= 0 {
+				break
+			}
+			return int64(n * float64(u.mult)), nil
+		}
+	}
+	return 0, fmt.Errorf("could not parse bandwidth, eg. 10Mbit: %s", s)
+}
+
+// ParseLoss parses a packet loss percentage, eg. 1% or 0.5%.
+func ParseLoss(s string) (float64, error) {
+	txt := strings.TrimSpace(s)
+	if !strings.HasSuffix(txt, "%") {
+		return 0, fmt.Errorf("loss must be a percentage, eg. 1%%: %s", s)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSuffix(txt, "%"), 64)
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("loss must be a percentage between 0%% and 100%%: %s", s)
+	}
+	return n / 100, nil
+}
+
+// dnctlArgs returns the arguments to dnctl configuring a pipe with the
+// shaping, eg. pipe 10002 config bw 10000000bit/s delay 50 plr 0.01.
+func (s *Shaping) dnctlArgs(pipe int) []string {
+	args := []string{"pipe", strconv.Itoa(pipe), "config"}
+	if s.Bandwidth > 0 {
+		args = append(args, "bw", fmt.Sprintf("%dbit/s", s.Bandwidth))
+	}
+	if s.Delay > 0 {
+		args = append(args, "delay", strconv.FormatInt(int64(s.Delay/time.Millisecond), 10))
+	}
+	if s.Loss > 0 {
+		args = append(args, "plr", strconv.FormatFloat(s.Loss, 'f', -1, 64))
+	}
+	return args
+}
+
+// pfRules returns the pf rule sending traffic to the VM, out on the
+// interface, through its pipe.
+func (s *Shaping) pfRules(pipe int) string {
+	return fmt.Sprintf("dummynet out quick on %s all pipe %d\n", s.Interface, pipe)
+}
+
+// anchor is the pf anchor of the shaping rules of the interface.
+func (s *Shaping) anchor() string {
+	return "com.apple/hkmgr.shape." + s.Interface
+}
+
+// pipe returns the dummynet pipe of the interface, numbered by the unit of
+// the tap interface, eg. tap3 uses pipe 10003.
+func (s *Shaping) pipe() (int, error) {
+	unit, err := strconv.Atoi(strings.TrimLeft(s.Interface, "abcdefghijklmnopqrstuvwxyz"))
+	if err != nil || !strings.HasPrefix(s.Interface, "tap") {
+		return 0, fmt.Errorf("shaping is only supported on tap interfaces: %s", s.Interface)
+	}
+	return shapePipeBase + unit, nil
+}
+
+// tcArgs returns the arguments to tc adding a netem qdisc to the interface,
+// which shapes the traffic it sends, that is traffic to the VM.
+func (s *Shaping) tcArgs() []string {
+	args := []string{"qdisc", "replace", "dev", s.Interface, "root", "netem"}
+	if s.Delay > 0 {
+		args = append(args, "delay", s.Delay.String())
+	}
+	if s.Loss > 0 {
+		args = append(args, "loss", strconv.FormatFloat(s.Loss*100, 'f', -1, 64)+"%")
+	}
+	if s.Bandwidth > 0 {
+		args = append(args, "rate", fmt.Sprintf("%dbit", s.Bandwidth))
+	}
+	return args
+}
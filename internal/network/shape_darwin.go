@@ -0,0 +1,42 @@
+//go:build darwin
+// +build darwin
+
+package network
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Up shapes the traffic to the VM, sent out on the interface, with a
+// dummynet pipe, replacing any previous shaping.
+func (s *Shaping) Up() error {
+	pipe, err := s.pipe()
+	if err != nil {
+		return err
+	}
+	if err := hostRun("dnctl", s.dnctlArgs(pipe)...); err != nil {
+		return fmt.Errorf("configuring dummynet pipe %d, %v", pipe, err)
+	}
+	return loadAnchor(s.anchor(), s.pfRules(pipe))
+}
+
+// Down removes the pf rules and dummynet pipe of the interface.
+func (s *Shaping) Down() error {
+	pipe, err := s.pipe()
+	if err != nil {
+		return err
+	}
+	var errs []string
+	if err := flushAnchor(s.anchor()); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := hostRun("dnctl", "pipe", "delete", strconv.Itoa(pipe)); err != nil {
+		errs = append(errs, fmt.Sprintf("deleting dummynet pipe %d, %v", pipe, err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package network
+
+import "fmt"
+
+// Up shapes the traffic to the interface with a netem qdisc, replacing any
+// previous shaping.
+func (s *Shaping) Up() error {
+	if err := hostRun("tc", s.tcArgs()...); err != nil {
+		return fmt.Errorf("shaping %s, %v", s.Interface, err)
+	}
+	return nil
+}
+
+// Down removes the netem qdisc of the interface.
+func (s *Shaping) Down() error {
+	if err := hostRun("tc", "qdisc", "del", "dev", s.Interface, "root"); err != nil {
+		return fmt.Errorf("removing shaping of %s, %v", s.Interface, err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package network
+
+import "fmt"
+
+// Up returns an error, as shaping uses dummynet on macOS or netem on Linux.
+func (s *Shaping) Up() error {
+	return fmt.Errorf("shaping %s is not supported on this platform", s.Interface)
+}
+
+// Down does nothing, as shaping can't have been applied.
+func (s *Shaping) Down() error {
+	return nil
+}
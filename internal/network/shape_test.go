@@ -0,0 +1,86 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "10Mbit", want: 10000000},
+		{input: "512Kbit/s", want: 512000},
+		{input: "1.5Gbit", want: 1500000000},
+		{input: "64000bit", want: 64000},
+		{input: "10MB", wantErr: true},
+		{input: "0Mbit", wantErr: true},
+		{input: "Mbit", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseBandwidth(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBandwidth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseBandwidth() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLoss(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{input: "1%", want: 0.01},
+		{input: "0.5%", want: 0.005},
+		{input: "0.01", wantErr: true},
+		{input: "101%", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLoss(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLoss() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLoss() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShaping(t *testing.T) {
+	s := &Shaping{Interface: "tap3", Bandwidth: 10000000, Delay: 50 * time.Millisecond, Loss: 0.01}
+
+	pipe, err := s.pipe()
+	if err != nil || pipe != 10003 {
+		t.Fatalf("Shaping.pipe() = %d, %v, want 10003", pipe, err)
+	}
+	if _, err := (&Shaping{Interface: "bridge1"}).pipe(); err == nil {
+		t.Errorf("Shaping.pipe() of bridge1, want error")
+	}
+
+	if got, want := s.dnctlArgs(pipe), []string{"pipe", "10003", "config", "bw", "10000000bit/s", "delay", "50", "plr", "0.01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Shaping.dnctlArgs() = %q, want %q", got, want)
+	}
+	if got, want := (&Shaping{Interface: "tap3", Delay: 50 * time.Millisecond}).dnctlArgs(pipe), []string{"pipe", "10003", "config", "delay", "50"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Shaping.dnctlArgs() delay only = %q, want %q", got, want)
+	}
+
+	// Only traffic to the VM is shaped, as with netem on Linux.
+	if got, want := s.pfRules(pipe), "dummynet out quick on tap3 all pipe 10003\n"; got != want {
+		t.Errorf("Shaping.pfRules() = %q, want %q", got, want)
+	}
+
+	if got, want := s.tcArgs(), []string{"qdisc", "replace", "dev", "tap3", "root", "netem", "delay", "50ms", "loss", "1%", "rate", "10000000bit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Shaping.tcArgs() = %q, want %q", got, want)
+	}
+}
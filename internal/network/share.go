@@ -0,0 +1,722 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shareMsize is the largest message size a Share negotiates.
+const shareMsize = 65536
+
+// Share serves a host directory over 9P2000 on a unix socket, which hyperkit's
+// virtio-9p device connects to for the guest to mount it.
+type Share struct {
+	Path     string // Host directory served
+	Socket   string // Unix socket listened on
+	ReadOnly bool   // Reject any change to the directory
+
+	mu sync.Mutex
+	l  net.Listener
+}
+
+// Listen removes a stale socket left by a previous server and listens on it.
+func (s *Share) Listen() error {
+	if err := os.Remove(s.Socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return fmt.Errorf("sharing %s, %v", s.Path, err)
+	}
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+	return nil
+}
+
+// Serve serves connections until Close is called. Listen must be called
+// first.
+func (s *Share) Serve() {
+	s.mu.Lock()
+	l := s.l
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serveConn(conn); err != nil && err != io.EOF {
+				fmt.Printf("sharing %s, %v\n", s.Path, err)
+			}
+		}()
+	}
+}
+
+// Close stops listening, which removes the socket.
+func (s *Share) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l == nil {
+		return nil
+	}
+	err := s.l.Close()
+	s.l = nil
+	return err
+}
+
+// p9Fid is a file of the share referenced by a client.
+type p9Fid struct {
+	path   string
+	file   *os.File
+	rclose bool
+	dir    [][]byte // Stat entries of an open directory, read at offset 0
+}
+
+// p9Session is the state of one client connection.
+type p9Session struct {
+	share *Share
+	root  string
+	msize uint32
+	fids  map[uint32]*p9Fid
+}
+
+func (s *Share) serveConn(conn net.Conn) error {
+	root, err := filepath.EvalSymlinks(s.Path)
+	if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	sess := &p9Session{share: s, root: root, msize: shareMsize, fids: map[uint32]*p9Fid{}}
+	defer sess.clunkAll()
+
+	for {
+		var size uint32
+		if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+			return err
+		}
+		if size < 7 || size > sess.msize {
+			return fmt.Errorf("9p: invalid message size %d", size)
+		}
+		msg := make([]byte, size-4)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return err
+		}
+		typ, tag := msg[0], binary.LittleEndian.Uint16(msg[1:])
+
+		rtype := typ + 1
+		body, err := sess.handle(typ, &p9Msg{b: msg[3:]})
+		if err != nil {
+			rtype = p9Rerror
+			body = &p9Buf{}
+			body.str(p9Ename(err))
+		}
+		var resp p9Buf
+		resp.u32(uint32(4 + 1 + 2 + body.Len()))
+		resp.u8(rtype)
+		resp.u16(tag)
+		resp.Write(body.Bytes())
+		if _, err := conn.Write(resp.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// p9Ename returns the 9P error string of err. Errno strings are capitalized to
+// match the C library, which is how the Linux 9p client maps them back to an
+// errno.
+func p9Ename(err error) string {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	msg := err.Error()
+	if _, ok := err.(syscall.Errno); ok && msg != "" {
+		msg = strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return msg
+}
+
+func (sess *p9Session) clunkAll() {
+	for fid, f := range sess.fids {
+		if f.file != nil {
+			f.file.Close()
+		}
+		delete(sess.fids, fid)
+	}
+}
+
+func (sess *p9Session) fid(fid uint32) (*p9Fid, error) {
+	f, ok := sess.fids[fid]
+	if !ok {
+		return nil, fmt.Errorf("unknown fid %d", fid)
+	}
+	return f, nil
+}
+
+// newFid adds fid for path, which must not be in use.
+func (sess *p9Session) newFid(fid uint32, path string) error {
+	if _, ok := sess.fids[fid]; ok {
+		return fmt.Errorf("fid %d in use", fid)
+	}
+	sess.fids[fid] = &p9Fid{path: path}
+	return nil
+}
+
+// inRoot reports whether path is the root or below it.
+func (sess *p9Session) inRoot(path string) bool {
+	return path == sess.root || strings.HasPrefix(path, sess.root+string(filepath.Separator))
+}
+
+// join returns the path of name in the directory dir, which can't leave the
+// root, either with ".." or through a symlink.
+func (sess *p9Session) join(dir, name string) (string, error) {
+	switch {
+	case name == "..":
+		if dir == sess.root {
+			return dir, nil
+		}
+		return filepath.Dir(dir), nil
+	case name == "." || name == "":
+		return dir, nil
+	case strings.ContainsRune(name, '/'):
+		return "", syscall.EINVAL
+	}
+	path := filepath.Join(dir, name)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		path, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", err
+		}
+		if !sess.inRoot(path) {
+			return "", syscall.EACCES
+		}
+	}
+	return path, nil
+}
+
+func (sess *p9Session) readOnly() error {
+	if sess.share.ReadOnly {
+		return syscall.EROFS
+	}
+	return nil
+}
+
+func (sess *p9Session) handle(typ uint8, m *p9Msg) (*p9Buf, error) {
+	var b p9Buf
+	switch typ {
+	case p9Tversion:
+		msize, version := m.u32(), m.str()
+		if m.err != nil {
+			return nil, m.err
+		}
+		sess.clunkAll()
+		if msize < sess.msize {
+			sess.msize = msize
+		}
+		if !strings.HasPrefix(version, "9P2000") {
+			version = "unknown"
+		} else {
+			version = "9P2000"
+		}
+		b.u32(sess.msize)
+		b.str(version)
+
+	case p9Tauth:
+		return nil, fmt.Errorf("authentication not required")
+
+	case p9Tattach:
+		fid := m.u32()
+		if m.err != nil {
+			return nil, m.err
+		}
+		if err := sess.newFid(fid, sess.root); err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(sess.root)
+		if err != nil {
+			delete(sess.fids, fid)
+			return nil, err
+		}
+		p9Qid(&b, fi)
+
+	case p9Tflush:
+		// Requests are answered in order, so there's never one to flush.
+
+	case p9Twalk:
+		fid, newfid, n := m.u32(), m.u32(), int(m.u16())
+		names := make([]string, n)
+		for i := range names {
+			names[i] = m.str()
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		if f.file != nil {
+			return nil, fmt.Errorf("fid %d is open", fid)
+		}
+		if newfid != fid {
+			if _, ok := sess.fids[newfid]; ok {
+				return nil, fmt.Errorf("fid %d in use", newfid)
+			}
+		}
+		path := f.path
+		var qids p9Buf
+		walked := 0
+		for _, name := range names {
+			next, err := sess.join(path, name)
+			var fi os.FileInfo
+			if err == nil {
+				fi, err = os.Stat(next)
+			}
+			if err != nil {
+				if walked == 0 {
+					return nil, err
+				}
+				break
+			}
+			p9Qid(&qids, fi)
+			path = next
+			walked++
+		}
+		if walked == n {
+			if newfid == fid {
+				f.path = path
+			} else {
+				sess.fids[newfid] = &p9Fid{path: path}
+			}
+		}
+		b.u16(uint16(walked))
+		b.Write(qids.Bytes())
+
+	case p9Topen:
+		fid, mode := m.u32(), m.u8()
+		if m.err != nil {
+			return nil, m.err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		if f.file != nil {
+			return nil, fmt.Errorf("fid %d is open", fid)
+		}
+		flags, err := sess.openFlags(mode)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			flags = os.O_RDONLY
+		}
+		file, err := os.OpenFile(f.path, flags, 0)
+		if err != nil {
+			return nil, err
+		}
+		f.file, f.rclose = file, mode&p9ORCLOSE != 0
+		p9Qid(&b, fi)
+		b.u32(sess.msize - 24)
+
+	case p9Tcreate:
+		fid, name, perm, mode := m.u32(), m.str(), m.u32(), m.u8()
+		if m.err != nil {
+			return nil, m.err
+		}
+		if err := sess.readOnly(); err != nil {
+			return nil, err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		if f.file != nil {
+			return nil, fmt.Errorf("fid %d is open", fid)
+		}
+		if name == "." || name == ".." || name == "" || strings.ContainsRune(name, '/') {
+			return nil, syscall.EINVAL
+		}
+		path := filepath.Join(f.path, name)
+		var file *os.File
+		if perm&p9DMDIR != 0 {
+			if err := os.Mkdir(path, os.FileMode(perm&0777)); err != nil {
+				return nil, err
+			}
+			file, err = os.Open(path)
+		} else {
+			flags, ferr := sess.openFlags(mode)
+			if ferr != nil {
+				return nil, ferr
+			}
+			file, err = os.OpenFile(path, flags|os.O_CREATE|os.O_EXCL, os.FileMode(perm&0777))
+		}
+		if err != nil {
+			return nil, err
+		}
+		fi, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		f.path, f.file, f.rclose = path, file, mode&p9ORCLOSE != 0
+		p9Qid(&b, fi)
+		b.u32(sess.msize - 24)
+
+	case p9Tread:
+		fid, offset, count := m.u32(), m.u64(), m.u32()
+		if m.err != nil {
+			return nil, m.err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		if f.file == nil {
+			return nil, fmt.Errorf("fid %d is not open", fid)
+		}
+		if max := sess.msize - 11; count > max {
+			count = max
+		}
+		data, err := sess.read(f, offset, count)
+		if err != nil {
+			return nil, err
+		}
+		b.u32(uint32(len(data)))
+		b.Write(data)
+
+	case p9Twrite:
+		fid, offset, count := m.u32(), m.u64(), m.u32()
+		data := m.bytes(int(count))
+		if m.err != nil {
+			return nil, m.err
+		}
+		if err := sess.readOnly(); err != nil {
+			return nil, err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		if f.file == nil {
+			return nil, fmt.Errorf("fid %d is not open", fid)
+		}
+		n, err := f.file.WriteAt(data, int64(offset))
+		if err != nil {
+			return nil, err
+		}
+		b.u32(uint32(n))
+
+	case p9Tclunk:
+		fid := m.u32()
+		if m.err != nil {
+			return nil, m.err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		delete(sess.fids, fid)
+		if f.file != nil {
+			f.file.Close()
+			if f.rclose {
+				os.Remove(f.path)
+			}
+		}
+
+	case p9Tremove:
+		fid := m.u32()
+		if m.err != nil {
+			return nil, m.err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		delete(sess.fids, fid)
+		if f.file != nil {
+			f.file.Close()
+		}
+		if err := sess.readOnly(); err != nil {
+			return nil, err
+		}
+		if f.path == sess.root {
+			return nil, syscall.EBUSY
+		}
+		if err := os.Remove(f.path); err != nil {
+			return nil, err
+		}
+
+	case p9Tstat:
+		fid := m.u32()
+		if m.err != nil {
+			return nil, m.err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			return nil, err
+		}
+		stat := p9Stat(fi)
+		b.u16(uint16(len(stat)))
+		b.Write(stat)
+
+	case p9Twstat:
+		fid := m.u32()
+		m.u16() // Size of the stat
+		m.u16() // Size within the stat
+		m.u16() // Type
+		m.u32() // Dev
+		m.bytes(13)
+		mode, _, mtime, length := m.u32(), m.u32(), m.u32(), m.u64()
+		name := m.str()
+		if m.err != nil {
+			return nil, m.err
+		}
+		if err := sess.readOnly(); err != nil {
+			return nil, err
+		}
+		f, err := sess.fid(fid)
+		if err != nil {
+			return nil, err
+		}
+		if err := sess.wstat(f, mode, mtime, length, name); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("9p: message type %d not supported", typ)
+	}
+	return &b, nil
+}
+
+// openFlags returns the flags to open a file with for a 9P open mode.
+func (sess *p9Session) openFlags(mode uint8) (int, error) {
+	var flags int
+	switch mode & 3 {
+	case p9OREAD, p9OEXEC:
+		flags = os.O_RDONLY
+	case p9OWRITE:
+		flags = os.O_WRONLY
+	case p9ORDWR:
+		flags = os.O_RDWR
+	}
+	if mode&p9OTRUNC != 0 {
+		flags |= os.O_TRUNC
+	}
+	if flags != os.O_RDONLY || mode&p9ORCLOSE != 0 {
+		if err := sess.readOnly(); err != nil {
+			return 0, err
+		}
+	}
+	return flags, nil
+}
+
+// read returns up to count bytes of f at offset. Directories are read as
+// whole stat entries, listed again when read from offset 0.
+func (sess *p9Session) read(f *p9Fid, offset uint64, count uint32) ([]byte, error) {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		data := make([]byte, count)
+		n, err := f.file.ReadAt(data, int64(offset))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+
+	if offset == 0 {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		names, err := f.file.Readdirnames(-1)
+		if err != nil {
+			return nil, err
+		}
+		f.dir = nil
+		for _, name := range names {
+			fi, err := os.Lstat(filepath.Join(f.path, name))
+			if err != nil {
+				continue
+			}
+			f.dir = append(f.dir, p9Stat(fi))
+		}
+	}
+
+	var pos uint64
+	i := 0
+	for ; i < len(f.dir) && pos < offset; i++ {
+		pos += uint64(len(f.dir[i]))
+	}
+	if pos != offset {
+		return nil, fmt.Errorf("9p: invalid directory offset %d", offset)
+	}
+	var data []byte
+	for ; i < len(f.dir) && len(data)+len(f.dir[i]) <= int(count); i++ {
+		data = append(data, f.dir[i]...)
+	}
+	return data, nil
+}
+
+// wstat changes the fields of a stat not set to "don't touch", all ones for
+// numbers and empty for strings.
+func (sess *p9Session) wstat(f *p9Fid, mode, mtime uint32, length uint64, name string) error {
+	if length != ^uint64(0) {
+		if err := os.Truncate(f.path, int64(length)); err != nil {
+			return err
+		}
+	}
+	if mode != ^uint32(0) {
+		if err := os.Chmod(f.path, os.FileMode(mode&0777)); err != nil {
+			return err
+		}
+	}
+	if mtime != ^uint32(0) {
+		t := time.Unix(int64(mtime), 0)
+		if err := os.Chtimes(f.path, t, t); err != nil {
+			return err
+		}
+	}
+	if name != "" && name != filepath.Base(f.path) {
+		if f.path == sess.root || strings.ContainsRune(name, '/') {
+			return syscall.EINVAL
+		}
+		path := filepath.Join(filepath.Dir(f.path), name)
+		if err := os.Rename(f.path, path); err != nil {
+			return err
+		}
+		f.path = path
+	}
+	return nil
+}
+
+// p9Qid encodes the qid of a file, identified by its inode.
+func p9Qid(b *p9Buf, fi os.FileInfo) {
+	var typ uint8
+	if fi.IsDir() {
+		typ = p9QTDIR
+	}
+	var ino uint64
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		ino = uint64(st.Ino)
+	}
+	b.u8(typ)
+	b.u32(uint32(fi.ModTime().Unix()))
+	b.u64(ino)
+}
+
+// p9Stat encodes the stat of a file, preceded by its size.
+func p9Stat(fi os.FileInfo) []byte {
+	mode := uint32(fi.Mode().Perm())
+	length := uint64(fi.Size())
+	if fi.IsDir() {
+		mode |= p9DMDIR
+		length = 0
+	}
+	uid, gid := "", ""
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		uid = strconv.FormatUint(uint64(st.Uid), 10)
+		gid = strconv.FormatUint(uint64(st.Gid), 10)
+	}
+
+	var s p9Buf
+	s.u16(0) // Type
+	s.u32(0) // Dev
+	p9Qid(&s, fi)
+	s.u32(mode)
+	s.u32(uint32(fi.ModTime().Unix())) // Atime
+	s.u32(uint32(fi.ModTime().Unix()))
+	s.u64(length)
+	s.str(fi.Name())
+	s.str(uid)
+	s.str(gid)
+	s.str(uid) // Muid
+
+	var b p9Buf
+	b.u16(uint16(s.Len()))
+	b.Write(s.Bytes())
+	return b.Bytes()
+}
+
+// p9Msg decodes the fields of a 9P message, little endian. Decoding past the
+// end sets err and returns zero values.
+type p9Msg struct {
+	b   []byte
+	err error
+}
+
+func (m *p9Msg) bytes(n int) []byte {
+	if m.err != nil || n < 0 || len(m.b) < n {
+		if m.err == nil {
+			m.err = fmt.Errorf("9p: short message")
+		}
+		return nil
+	}
+	v := m.b[:n]
+	m.b = m.b[n:]
+	return v
+}
+
+func (m *p9Msg) u8() uint8 {
+	if b := m.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (m *p9Msg) u16() uint16 {
+	if b := m.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (m *p9Msg) u32() uint32 {
+	if b := m.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (m *p9Msg) u64() uint64 {
+	if b := m.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (m *p9Msg) str() string {
+	return string(m.bytes(int(m.u16())))
+}
@@ -0,0 +1,115 @@
+package network
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// read opens the file at path read only and returns its contents.
+func (c *p9Client) read(path ...string) (string, error) {
+	fid, err := c.walk(path...)
+	if err != nil {
+		return "", err
+	}
+	defer c.clunk(fid)
+
+	var b p9Buf
+	b.u32(fid)
+	b.u8(p9OREAD)
+	if _, err := c.rpc(p9Topen, 1, b.Bytes()); err != nil {
+		return "", err
+	}
+	b.Reset()
+	b.u32(fid)
+	b.u64(0)
+	b.u32(p9Msize - 24)
+	resp, err := c.rpc(p9Tread, 1, b.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return string(resp[4 : 4+binary.LittleEndian.Uint32(resp)]), nil
+}
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly bool
+		wantErr  string
+	}{
+		{name: "read write"},
+		{name: "read only", readOnly: true, wantErr: "Read-only file system"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "hkmgr")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			root := filepath.Join(dir, "root")
+			if err := os.Mkdir(root, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(root, "file"), []byte("contents"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "outside"), []byte("secret"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "link")); err != nil {
+				t.Fatal(err)
+			}
+
+			s := &Share{Path: root, Socket: filepath.Join(dir, "share.sock"), ReadOnly: tt.readOnly}
+			if err := s.Listen(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			go s.Serve()
+
+			c, err := dial9P(s.Socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if got, err := c.read("file"); err != nil || got != "contents" {
+				t.Errorf("read(file) = %q, %v, want contents", got, err)
+			}
+			if got, err := c.read("..", "..", "file"); err != nil || got != "contents" {
+				t.Errorf("read(../../file) = %q, %v, want contents", got, err)
+			}
+			if _, err := c.read("link"); err == nil {
+				t.Errorf("read(link) to a file outside the share succeeded")
+			}
+
+			errString := func(err error) string {
+				if err == nil {
+					return ""
+				}
+				return err.Error()
+			}
+			if err := c.mkdir("dir"); errString(err) != tt.wantErr {
+				t.Errorf("mkdir(dir) error = %v, want %q", err, tt.wantErr)
+			}
+			if _, err := c.writeRead("changed", "file"); errString(err) != tt.wantErr {
+				t.Errorf("writeRead(file) error = %v, want %q", err, tt.wantErr)
+			}
+			if err := c.remove("file"); errString(err) != tt.wantErr {
+				t.Errorf("remove(file) error = %v, want %q", err, tt.wantErr)
+			}
+
+			_, err = os.Stat(filepath.Join(root, "dir"))
+			if created := err == nil; created == tt.readOnly {
+				t.Errorf("dir created = %v, want %v", created, !tt.readOnly)
+			}
+			_, err = os.Stat(filepath.Join(root, "file"))
+			if removed := os.IsNotExist(err); removed == tt.readOnly {
+				t.Errorf("file removed = %v, want %v", removed, !tt.readOnly)
+			}
+		})
+	}
+}
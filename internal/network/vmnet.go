@@ -0,0 +1,295 @@
+package network
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// VmnetPlist is the macOS configuration of the vmnet shared network.
+var VmnetPlist = "/Library/Preferences/SystemConfiguration/com.apple.vmnet.plist"
+
+// Keys of the shared network address and mask in the vmnet plist.
+const (
+	vmnetAddressKey = "Shared_Net_Address"
+	vmnetMaskKey    = "Shared_Net_Mask"
+)
+
+// DefaultVmnetAddr is the address of the host on the vmnet shared network
+// when it isn't configured.
+var DefaultVmnetAddr = &net.IPNet{IP: net.IPv4(192, 168, 64, 1).To4(), Mask: net.CIDRMask(24, 32)}
+
+// emptyPlist is written when the vmnet plist doesn't exist.
+const emptyPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+</dict>
+</plist>
+`
+
+// Vmnet is the macOS vmnet shared network, where the host is the gateway and
+// DHCP server of VMs.
+type Vmnet struct {
+	Plist string     // VmnetPlist if empty
+	Addr  *net.IPNet // Address of the host on the network, left as is if nil
+}
+
+func (v *Vmnet) plist() string {
+	if v.Plist == "" {
+		return VmnetPlist
+	}
+	return v.Plist
+}
+
+// Gateway returns the effective address of the host on the network, ie. the
+// gateway of VMs, from the plist. DefaultVmnetAddr is returned if the plist
+// doesn't exist or doesn't set it.
+func (v *Vmnet) Gateway() (*net.IPNet, error) {
+	data, err := ioutil.ReadFile(v.plist())
+	if os.IsNotExist(err) {
+		return DefaultVmnetAddr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values, err := readPlist(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s, %v", v.plist(), err)
+	}
+	ip := net.ParseIP(values[vmnetAddressKey]).To4()
+	if ip == nil {
+		return DefaultVmnetAddr, nil
+	}
+	mask := net.IPMask(net.ParseIP(values[vmnetMaskKey]).To4())
+	if ones, bits := mask.Size(); ones == 0 && bits == 0 {
+		mask = ip.DefaultMask()
+	}
+	return &net.IPNet{IP: ip, Mask: mask}, nil
+}
+
+// Diff returns the changes to the plist needed to configure Addr, as lines
+// of removed and added values, eg. "+ Shared_Net_Address = 192.168.99.1".
+func (v *Vmnet) Diff() ([]string, error) {
+	_, diff, err := v.update()
+	return diff, err
+}
+
+// Up writes Addr to the plist, showing the changes first, if it differs. The
+// shared network of macOS uses the new address once all VMs on it have
+// stopped.
+func (v *Vmnet) Up() error {
+	data, diff, err := v.update()
+	if err != nil || len(diff) == 0 {
+		return err
+	}
+	fmt.Printf("updating %s:\n%s\n", v.plist(), strings.Join(diff, "\n"))
+
+	// Write to a temporary file and rename so the plist is never partially
+	// written.
+	tmp := v.plist() + ".hkmgr"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, v.plist()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// update returns the plist with Addr set, and the changes made.
+func (v *Vmnet) update() ([]byte, []string, error) {
+	if v.Addr == nil {
+		return nil, nil, nil
+	}
+	data, err := ioutil.ReadFile(v.plist())
+	if os.IsNotExist(err) {
+		data = []byte(emptyPlist)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	cur, err := readPlist(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s, %v", v.plist(), err)
+	}
+	want := map[string]string{
+		vmnetAddressKey: v.Addr.IP.String(),
+		vmnetMaskKey:    net.IP(v.Addr.Mask).String(),
+	}
+
+	var keys []string
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var diff []string
+	for _, key := range keys {
+		old, ok := cur[key]
+		if ok && old == want[key] {
+			delete(want, key)
+			continue
+		}
+		if ok {
+			diff = append(diff, fmt.Sprintf("- %s = %s", key, old))
+		}
+		diff = append(diff, fmt.Sprintf("+ %s = %s", key, want[key]))
+	}
+	if len(diff) == 0 {
+		return data, nil, nil
+	}
+
+	data, err = setPlist(data, want)
+	if err != nil {
+		return nil, nil, fmt.Errorf("updating %s, %v", v.plist(), err)
+	}
+	return data, diff, nil
+}
+
+// plistEntry is the location of a value in the top level dict of a plist.
+type plistEntry struct {
+	value      string
+	start, end int64 // Offsets of the value element
+}
+
+// parsePlist parses the top level dict of an XML plist, returning its string
+// values, and the offset of the end of the dict. Values of other types are
+// included with an empty value.
+func parsePlist(data []byte) (map[string]plistEntry, int64, error) {
+	if bytes.HasPrefix(data, []byte("bplist")) {
+		return nil, 0, fmt.Errorf("binary plists aren't supported, convert it with plutil -convert xml1")
+	}
+
+	entries := map[string]plistEntry{}
+	dictEnd := int64(-1)
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var depth int
+	var key string
+	var inKey bool
+	var entry *plistEntry
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 && tok.Name.Local != "dict" {
+				return nil, 0, fmt.Errorf("expected a dict, found %s", tok.Name.Local)
+			}
+			if depth != 3 {
+				continue
+			}
+			if tok.Name.Local == "key" {
+				inKey, key = true, ""
+			} else {
+				entry = &plistEntry{start: offset}
+			}
+		case xml.CharData:
+			if depth != 3 {
+				continue
+			}
+			if inKey {
+				key += string(tok)
+			} else if entry != nil {
+				entry.value += string(tok)
+			}
+		case xml.EndElement:
+			if depth == 3 {
+				if inKey {
+					inKey = false
+				} else if entry != nil {
+					entry.end = dec.InputOffset()
+					if tok.Name.Local != "string" {
+						entry.value = ""
+					}
+					entries[key] = *entry
+					entry = nil
+				}
+			}
+			if depth == 2 {
+				dictEnd = offset
+			}
+			depth--
+		}
+	}
+	if dictEnd == -1 {
+		return nil, 0, fmt.Errorf("no dict found")
+	}
+	return entries, dictEnd, nil
+}
+
+// readPlist returns the string values of the top level dict of an XML plist.
+func readPlist(data []byte) (map[string]string, error) {
+	entries, _, err := parsePlist(data)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for key, e := range entries {
+		values[key] = e.value
+	}
+	return values, nil
+}
+
+// setPlist sets string values in the top level dict of an XML plist, leaving
+// the rest of it as is. Existing values are replaced in place, and new ones
+// appended to the dict.
+func setPlist(data []byte, values map[string]string) ([]byte, error) {
+	entries, dictEnd, err := parsePlist(data)
+	if err != nil {
+		return nil, err
+	}
+
+	type edit struct {
+		start, end int64
+		text       string
+	}
+	var edits []edit
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := plistString("string", values[key])
+		if e, ok := entries[key]; ok {
+			edits = append(edits, edit{start: e.start, end: e.end, text: value})
+		} else {
+			edits = append(edits, edit{start: dictEnd, end: dictEnd, text: "\t" + plistString("key", key) + "\n\t" + value + "\n"})
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var out bytes.Buffer
+	var pos int64
+	for _, e := range edits {
+		out.Write(data[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+// plistString returns an element with escaped text, eg. <string>a</string>.
+func plistString(name string, text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return "<" + name + ">" + buf.String() + "</" + name + ">"
+}
@@ -0,0 +1,157 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const vmnetPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Shared_Net_Address</key>
+	<string>192.168.64.1</string>
+	<key>Shared_Net_Mask</key>
+	<string>255.255.255.0</string>
+	<key>Shared_Net_IPv6_Enabled</key>
+	<true/>
+</dict>
+</plist>
+`
+
+func TestVmnet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		plist    string // empty if the plist doesn't exist
+		addr     string
+		wantDiff []string
+		wantGw   string
+		want     string
+	}{
+		{
+			name:   "not configured",
+			plist:  vmnetPlist,
+			wantGw: "192.168.64.1/24",
+			want:   vmnetPlist,
+		},
+		{
+			name:   "unchanged",
+			plist:  vmnetPlist,
+			addr:   "192.168.64.1/24",
+			wantGw: "192.168.64.1/24",
+			want:   vmnetPlist,
+		},
+		{
+			name:  "new subnet",
+			plist: vmnetPlist,
+			addr:  "192.168.99.1/16",
+			wantDiff: []string{
+				"- Shared_Net_Address = 192.168.64.1",
+				"+ Shared_Net_Address = 192.168.99.1",
+				"- Shared_Net_Mask = 255.255.255.0",
+				"+ Shared_Net_Mask = 255.255.0.0",
+			},
+			wantGw: "192.168.99.1/16",
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Shared_Net_Address</key>
+	<string>192.168.99.1</string>
+	<key>Shared_Net_Mask</key>
+	<string>255.255.0.0</string>
+	<key>Shared_Net_IPv6_Enabled</key>
+	<true/>
+</dict>
+</plist>
+`,
+		},
+		{
+			name: "no plist",
+			addr: "192.168.99.1/24",
+			wantDiff: []string{
+				"+ Shared_Net_Address = 192.168.99.1",
+				"+ Shared_Net_Mask = 255.255.255.0",
+			},
+			wantGw: "192.168.99.1/24",
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Shared_Net_Address</key>
+	<string>192.168.99.1</string>
+	<key>Shared_Net_Mask</key>
+	<string>255.255.255.0</string>
+</dict>
+</plist>
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".plist")
+			if tt.plist != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.plist), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			v := &Vmnet{Plist: path}
+			if tt.addr != "" {
+				ip, cidr, _ := net.ParseCIDR(tt.addr)
+				v.Addr = &net.IPNet{IP: ip.To4(), Mask: cidr.Mask}
+			}
+
+			diff, err := v.Diff()
+			if err != nil {
+				t.Fatalf("Vmnet.Diff() error = %v", err)
+			}
+			if !reflect.DeepEqual(diff, tt.wantDiff) {
+				t.Errorf("Vmnet.Diff() = %q, want %q", diff, tt.wantDiff)
+			}
+
+			if err := v.Up(); err != nil {
+				t.Fatalf("Vmnet.Up() error = %v", err)
+			}
+			if got, _ := ioutil.ReadFile(path); tt.want != "" && string(got) != tt.want {
+				t.Errorf("Vmnet.Up() wrote\n%s\nwant\n%s", got, tt.want)
+			}
+
+			gw, err := v.Gateway()
+			if err != nil {
+				t.Fatalf("Vmnet.Gateway() error = %v", err)
+			}
+			if gw.String() != tt.wantGw {
+				t.Errorf("Vmnet.Gateway() = %s, want %s", gw, tt.wantGw)
+			}
+		})
+	}
+}
+
+func Test_readPlist(t *testing.T) {
+	got, err := readPlist([]byte(vmnetPlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Shared_Net_Address":      "192.168.64.1",
+		"Shared_Net_Mask":         "255.255.255.0",
+		"Shared_Net_IPv6_Enabled": "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readPlist() = %v, want %v", got, want)
+	}
+
+	if _, err := readPlist([]byte("bplist00")); err == nil {
+		t.Errorf("readPlist() of a binary plist, want error")
+	}
+}
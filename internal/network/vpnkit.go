@@ -0,0 +1,232 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/go-ps"
+)
+
+// VPNKitPath is the vpnkit executable, looked up in PATH if not absolute.
+var VPNKitPath = "vpnkit"
+
+// VPNKit is a vpnkit process providing a NAT network to VMs over its ethernet
+// socket, with port forwards from the host to guests.
+type VPNKit struct {
+	RunDir   string
+	Forwards []Forward
+}
+
+// Forward is a port forward from a host address to a guest address.
+type Forward struct {
+	Proto     string // tcp or udp
+	HostIP    net.IP
+	HostPort  int
+	GuestIP   net.IP
+	GuestPort int
+}
+
+// spec is the vpnkit representation of a forward, eg.
+// tcp:127.0.0.1:8080:tcp:192.168.65.2:80.
+func (f Forward) spec() string {
+	return fmt.Sprintf("%s:%s:%d:%s:%s:%d", f.Proto, f.HostIP, f.HostPort, f.Proto, f.GuestIP, f.GuestPort)
+}
+
+// EthernetSocket is the socket hyperkit connects virtio-vpnkit devices to.
+func (v *VPNKit) EthernetSocket() string {
+	return filepath.Join(v.RunDir, "vpnkit.eth.sock")
+}
+
+// PortSocket is the socket vpnkit serves port forwarding control on.
+func (v *VPNKit) PortSocket() string {
+	return filepath.Join(v.RunDir, "vpnkit.port.sock")
+}
+
+func (v *VPNKit) pidFile() string {
+	return filepath.Join(v.RunDir, "vpnkit.pid")
+}
+
+// superviseInterval is how often Supervise checks vpnkit is still running.
+var superviseInterval = time.Second
+
+// Running reports whether vpnkit is running and has created its sockets.
+func (v *VPNKit) Running() bool {
+	return v.pid() != 0 && fileExists(v.EthernetSocket()) && fileExists(v.PortSocket())
+}
+
+// Up exposes the configured port forwards on vpnkit, which is started and
+// kept running by Supervise.
+func (v *VPNKit) Up() error {
+	if !v.Running() {
+		return fmt.Errorf("vpnkit is not running, see %s", v.logFile())
+	}
+	return v.expose()
+}
+
+// Supervise keeps vpnkit running until stop is closed, starting it again
+// when it exits and exposing the port forwards, which don't outlive it. A
+// vpnkit that's already running is adopted rather than restarted, so VMs
+// stay connected when the supervisor is replaced. vpnkit is left running
+// when stop is closed.
+func (v *VPNKit) Supervise(stop <-chan struct{}) {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if v.pid() == 0 {
+			fmt.Printf("starting vpnkit\n")
+			if err := v.start(); err != nil {
+				fmt.Printf("starting vpnkit, %v\n", err)
+			} else if err := v.expose(); err != nil {
+				fmt.Printf("%v\n", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pid returns the PID of the running vpnkit process, or 0 if not running.
+func (v *VPNKit) pid() int {
+	pidTxt, err := ioutil.ReadFile(v.pidFile())
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidTxt)))
+	if err != nil {
+		return 0
+	}
+	proc, err := ps.FindProcess(pid)
+	if err != nil || proc == nil {
+		return 0
+	}
+	if proc.Executable() != "vpnkit" && proc.Executable() != "com.docker.vpnkit" {
+		return 0
+	}
+	return pid
+}
+
+func (v *VPNKit) logFile() string {
+	return filepath.Join(v.RunDir, "vpnkit.log")
+}
+
+// start launches vpnkit in its own session so it outlives the supervisor, and
+// waits for its sockets to be created.
+func (v *VPNKit) start() error {
+	if err := os.MkdirAll(v.RunDir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, sock := range []string{v.EthernetSocket(), v.PortSocket()} {
+		if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	log, err := os.OpenFile(v.logFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(VPNKitPath, "--ethernet", v.EthernetSocket(), "--port", v.PortSocket())
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting vpnkit, %v", err)
+	}
+
+	if err := ioutil.WriteFile(v.pidFile(), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	for i := 0; i < 50; i++ {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("vpnkit exited, %v, see %s", err, log.Name())
+		case <-time.After(100 * time.Millisecond):
+		}
+		if fileExists(v.EthernetSocket()) && fileExists(v.PortSocket()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("timed out waiting for vpnkit to create %s", v.EthernetSocket())
+}
+
+// expose adds the port forwards to vpnkit. Forwards that already exist are
+// left as is.
+func (v *VPNKit) expose() error {
+	if len(v.Forwards) == 0 {
+		return nil
+	}
+
+	c, err := dial9P(v.PortSocket())
+	if err != nil {
+		return fmt.Errorf("connecting to vpnkit port control, %v", err)
+	}
+	defer c.Close()
+
+	for _, f := range v.Forwards {
+		fid, err := c.walk(f.spec())
+		if err == nil {
+			c.clunk(fid)
+			continue
+		}
+		// Only a forward vpnkit doesn't have is added, not one that couldn't
+		// be looked up, eg. after vpnkit exited.
+		if _, ok := err.(p9Error); !ok {
+			return fmt.Errorf("looking up forward %s, %v", f.spec(), err)
+		}
+		if err := c.mkdir(f.spec()); err != nil {
+			return fmt.Errorf("forwarding %s, %v", f.spec(), err)
+		}
+		resp, err := c.writeRead(f.spec(), f.spec(), "ctl")
+		if err != nil {
+			return fmt.Errorf("forwarding %s, %v", f.spec(), err)
+		}
+		if strings.HasPrefix(resp, "ERROR") {
+			c.remove(f.spec())
+			return fmt.Errorf("forwarding %s, %s", f.spec(), strings.TrimSpace(strings.TrimPrefix(resp, "ERROR")))
+		}
+	}
+	return nil
+}
+
+// Destroy stops vpnkit, which also removes its port forwards.
+func (v *VPNKit) Destroy() error {
+	pid := v.pid()
+	if pid == 0 {
+		return nil
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	return os.Remove(v.pidFile())
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestForward_spec(t *testing.T) {
+	f := Forward{Proto: "tcp", HostIP: net.ParseIP("127.0.0.1"), HostPort: 8080, GuestIP: net.ParseIP("192.168.65.2"), GuestPort: 80}
+	if got, want := f.spec(), "tcp:127.0.0.1:8080:tcp:192.168.65.2:80"; got != want {
+		t.Errorf("Forward.spec() = %s, want %s", got, want)
+	}
+}
+
+// fake9P is a vpnkit port control server which records the directories
+// created and the requests written to their ctl files. With hangup it closes
+// the connection on the first walk.
+type fake9P struct {
+	dirs   []string
+	writes []string
+	reply  string
+	hangup bool
+}
+
+func (s *fake9P) serve(conn net.Conn) {
+	defer conn.Close()
+	qid := make([]byte, 13)
+	paths := map[uint32][]string{}
+	for {
+		var size uint32
+		if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+			return
+		}
+		msg := make([]byte, size-4)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		typ, tag, body := msg[0], binary.LittleEndian.Uint16(msg[1:]), msg[3:]
+
+		var r p9Buf
+		rtype := typ + 1
+		switch typ {
+		case p9Tversion:
+			r.u32(p9Msize)
+			r.str("9P2000")
+		case p9Tattach:
+			r.Write(qid)
+		case p9Twalk:
+			if s.hangup {
+				return
+			}
+			newfid, n := binary.LittleEndian.Uint32(body[4:]), int(binary.LittleEndian.Uint16(body[8:]))
+			var path []string
+			rest := body[10:]
+			for i := 0; i < n; i++ {
+				name, _ := readStr(rest)
+				rest = rest[2+len(name):]
+				path = append(path, name)
+			}
+			if n > 0 && !contains(s.dirs, path[0]) {
+				rtype = p9Rerror
+				r.str("file not found")
+				break
+			}
+			paths[newfid] = path
+			r.u16(uint16(n))
+			for i := 0; i < n; i++ {
+				r.Write(qid)
+			}
+		case p9Tcreate:
+			name, _ := readStr(body[4:])
+			s.dirs = append(s.dirs, name)
+			r.Write(qid)
+			r.u32(0)
+		case p9Topen:
+			r.Write(qid)
+			r.u32(0)
+		case p9Twrite:
+			count := binary.LittleEndian.Uint32(body[12:])
+			s.writes = append(s.writes, string(body[16:16+count]))
+			r.u32(count)
+		case p9Tread:
+			r.u32(uint32(len(s.reply)))
+			r.WriteString(s.reply)
+		case p9Tclunk, p9Tremove:
+		}
+
+		var out p9Buf
+		out.u32(uint32(7 + r.Len()))
+		out.u8(rtype)
+		out.u16(tag)
+		out.Write(r.Bytes())
+		conn.Write(out.Bytes())
+	}
+}
+
+func TestVPNKit_expose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpnkit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	v := &VPNKit{RunDir: dir, Forwards: []Forward{
+		{Proto: "tcp", HostIP: net.ParseIP("127.0.0.1"), HostPort: 8080, GuestIP: net.ParseIP("192.168.65.2"), GuestPort: 80},
+		{Proto: "udp", HostIP: net.ParseIP("0.0.0.0"), HostPort: 53, GuestIP: net.ParseIP("192.168.65.2"), GuestPort: 53},
+	}}
+
+	l, err := net.Listen("unix", filepath.Join(dir, "vpnkit.port.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv := &fake9P{reply: "OK"}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv.serve(conn)
+		}
+	}()
+
+	// The second expose finds the forwards and leaves them as is.
+	for i := 0; i < 2; i++ {
+		if err := v.expose(); err != nil {
+			t.Fatalf("VPNKit.expose() error = %v", err)
+		}
+	}
+
+	want := []string{"tcp:127.0.0.1:8080:tcp:192.168.65.2:80", "udp:0.0.0.0:53:udp:192.168.65.2:53"}
+	if !reflect.DeepEqual(srv.dirs, want) {
+		t.Errorf("VPNKit.expose() created %v, want %v", srv.dirs, want)
+	}
+	if !reflect.DeepEqual(srv.writes, want) {
+		t.Errorf("VPNKit.expose() wrote %v, want %v", srv.writes, want)
+	}
+
+	srv.reply = "ERROR port 8081 in use"
+	v.Forwards[0].HostPort = 8081
+	if err := v.expose(); err == nil || err.Error() != "forwarding tcp:127.0.0.1:8081:tcp:192.168.65.2:80, port 8081 in use" {
+		t.Errorf("VPNKit.expose() error = %v, want port 8081 in use", err)
+	}
+
+	// A forward that can't be looked up isn't added.
+	srv.hangup = true
+	srv.dirs = nil
+	if err := v.expose(); err == nil {
+		t.Errorf("VPNKit.expose() error = nil, want lookup error")
+	}
+	if len(srv.dirs) != 0 {
+		t.Errorf("VPNKit.expose() created %v after a failed lookup", srv.dirs)
+	}
+}
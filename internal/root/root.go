@@ -6,13 +6,23 @@ import (
 	"path/filepath"
 
 	"github.com/BurntSushi/toml"
+	"github.com/bensallen/hkmgr/internal/capture"
 	"github.com/bensallen/hkmgr/internal/config"
 	"github.com/bensallen/hkmgr/internal/console"
 	"github.com/bensallen/hkmgr/internal/destroy"
+	"github.com/bensallen/hkmgr/internal/dns"
 	"github.com/bensallen/hkmgr/internal/down"
+	"github.com/bensallen/hkmgr/internal/forward"
+	"github.com/bensallen/hkmgr/internal/hosts"
+	"github.com/bensallen/hkmgr/internal/initialize"
+	"github.com/bensallen/hkmgr/internal/share"
+	"github.com/bensallen/hkmgr/internal/show"
 	"github.com/bensallen/hkmgr/internal/ssh"
 	"github.com/bensallen/hkmgr/internal/status"
 	"github.com/bensallen/hkmgr/internal/up"
+	"github.com/bensallen/hkmgr/internal/validate"
+	"github.com/bensallen/hkmgr/internal/vpnkit"
+	"github.com/bensallen/hkmgr/internal/vsock"
 	"github.com/integrii/flaggy"
 	"github.com/kr/pretty"
 )
@@ -29,6 +39,17 @@ func Run() error {
 	var sshSubcommand *flaggy.Subcommand
 	var statusSubcommand *flaggy.Subcommand
 	var consoleSubcommand *flaggy.Subcommand
+	var initSubcommand *flaggy.Subcommand
+	var configSubcommand *flaggy.Subcommand
+	var configShowSubcommand *flaggy.Subcommand
+	var vsockSubcommand *flaggy.Subcommand
+	var forwardSubcommand *flaggy.Subcommand
+	var captureSubcommand *flaggy.Subcommand
+	var dnsSubcommand *flaggy.Subcommand
+	var vpnkitSubcommand *flaggy.Subcommand
+	var shareSubcommand *flaggy.Subcommand
+	var hostsSubcommand *flaggy.Subcommand
+	var hostsSyncSubcommand *flaggy.Subcommand
 
 	//
 	var cliConfigPaths []string
@@ -55,6 +76,8 @@ func Run() error {
 	downSubcommand.ShortName = "stop"
 	var downSignal string
 	downSubcommand.String(&downSignal, "s", "signal", "Signal to send to VM")
+	var downNetworks bool
+	downSubcommand.Bool(&downNetworks, "", "networks", "Also stop vpnkit networks and the dns server, and revert host routes and sysctls set by up")
 	downSubcommand.AddPositionalValue(&vmName, "name", 1, false, "Specify a VM, otherwise all VMs will be stopped")
 
 	destroySubcommand = flaggy.NewSubcommand("destroy")
@@ -77,13 +100,102 @@ func Run() error {
 	consoleSubcommand.Description = "Open Console of VM"
 	consoleSubcommand.AddPositionalValue(&vmName, "name", 1, true, "Specify a VM")
 
+	vsockSubcommand = flaggy.NewSubcommand("vsock")
+	vsockSubcommand.Description = "Connect stdin and stdout to a vsock port of a VM"
+	var vsockPort string
+	vsockSubcommand.AddPositionalValue(&vmName, "name", 1, true, "Specify a VM")
+	vsockSubcommand.AddPositionalValue(&vsockPort, "port", 2, true, "Guest vsock port")
+
+	captureSubcommand = flaggy.NewSubcommand("capture")
+	captureSubcommand.Description = "Capture the traffic of a VM network interface with tcpdump, filter arguments may follow --"
+	var captureNIC string
+	var captureFile string
+	var captureFilter string
+	captureSubcommand.String(&captureFile, "w", "write", "Write the capture to a pcap file, or - for stdout, otherwise to a pcap in the run dir")
+	captureSubcommand.AddPositionalValue(&vmName, "name", 1, true, "Specify a VM")
+	captureSubcommand.AddPositionalValue(&captureNIC, "nic", 2, false, "Index of the network interface, defaults to 0")
+	captureSubcommand.AddPositionalValue(&captureFilter, "filter", 3, false, "tcpdump filter, eg. \"port 67 or port 68\"")
+
+	// Run in the background by up to serve the port forwards of a VM.
+	forwardSubcommand = flaggy.NewSubcommand("forward")
+	forwardSubcommand.Description = "Serve the port forwards of a VM"
+	forwardSubcommand.Hidden = true
+	forwardSubcommand.AddPositionalValue(&vmName, "name", 1, true, "Specify a VM")
+
+	// Run in the background by up to serve the names of VMs.
+	dnsSubcommand = flaggy.NewSubcommand("dns")
+	dnsSubcommand.Description = "Serve the names of VMs"
+	dnsSubcommand.Hidden = true
+
+	// Run in the background by up to keep vpnkit running.
+	vpnkitSubcommand = flaggy.NewSubcommand("vpnkit")
+	vpnkitSubcommand.Description = "Keep vpnkit of a network running"
+	vpnkitSubcommand.Hidden = true
+	var vpnkitNetwork string
+	vpnkitSubcommand.AddPositionalValue(&vpnkitNetwork, "network", 1, true, "Specify a vpnkit network")
+
+	// Run in the background by up to serve the shared directories of a VM.
+	shareSubcommand = flaggy.NewSubcommand("share")
+	shareSubcommand.Description = "Serve the shared directories of a VM"
+	shareSubcommand.Hidden = true
+	shareSubcommand.AddPositionalValue(&vmName, "name", 1, true, "Specify a VM")
+
+	initSubcommand = flaggy.NewSubcommand("init")
+	initSubcommand.Description = "Generate a hkmgr.toml, prompting for values not passed as flags"
+	var initOpts initialize.Options
+	var initYes bool
+	initSubcommand.String(&initOpts.Name, "", "name", "VM name")
+	initSubcommand.Int(&initOpts.Cores, "", "cores", "Number of cores")
+	initSubcommand.String(&initOpts.Memory, "m", "memory", "Memory, eg. 1GiB")
+	initSubcommand.String(&initOpts.Boot, "b", "boot", "Boot mode: kexec, firmware, or fbsd")
+	initSubcommand.String(&initOpts.Kernel, "", "kernel", "Kernel path for kexec boot")
+	initSubcommand.String(&initOpts.Initrd, "", "initrd", "Initrd path for kexec boot")
+	initSubcommand.String(&initOpts.Cmdline, "", "cmdline", "Kernel cmdline for kexec boot")
+	initSubcommand.String(&initOpts.Firmware, "", "firmware", "Firmware path for firmware boot")
+	initSubcommand.String(&initOpts.Userboot, "", "userboot", "Userboot path for fbsd boot")
+	initSubcommand.String(&initOpts.BootVolume, "", "bootvolume", "Boot volume path for fbsd boot")
+	initSubcommand.String(&initOpts.KernelEnv, "", "kernelenv", "Kernel environment for fbsd boot")
+	initSubcommand.String(&initOpts.Net, "", "net", "Network type: vmnet or tap")
+	initSubcommand.String(&initOpts.Bridge, "", "bridge", "Bridge device for tap networks, chosen automatically if not specified")
+	initSubcommand.String(&initOpts.Subnet, "", "subnet", "Bridge IP and prefix for tap networks, eg. 192.168.99.1/24, chosen automatically if not specified")
+	initSubcommand.String(&initOpts.Device, "", "device", "Tap device, chosen automatically if not specified")
+	initSubcommand.Bool(&initYes, "y", "yes", "Don't prompt, use defaults for values not passed as flags")
+
+	configSubcommand = flaggy.NewSubcommand("config")
+	configSubcommand.Description = "Inspect configuration"
+
+	configShowSubcommand = flaggy.NewSubcommand("show")
+	configShowSubcommand.Description = "Show the resolved configuration, including defaults and generated values"
+	var configShowFormat string
+	configShowSubcommand.String(&configShowFormat, "f", "format", "Output format: toml or json")
+	configShowSubcommand.AddPositionalValue(&vmName, "name", 1, false, "Specify a VM")
+	configSubcommand.AttachSubcommand(configShowSubcommand, 1)
+
+	hostsSubcommand = flaggy.NewSubcommand("hosts")
+	hostsSubcommand.Description = "Manage the names of VMs in the hosts file"
+
+	hostsSyncSubcommand = flaggy.NewSubcommand("sync")
+	hostsSyncSubcommand.Description = "Map the names of running VMs to their IPs in a block of the hosts file"
+	var hostsFile string
+	hostsSyncSubcommand.String(&hostsFile, "f", "file", "Hosts file to update, otherwise the file of the hosts config or /etc/hosts")
+	hostsSubcommand.AttachSubcommand(hostsSyncSubcommand, 1)
+
 	flaggy.AttachSubcommand(upSubcommand, 1)
 	flaggy.AttachSubcommand(downSubcommand, 1)
 	//flaggy.AttachSubcommand(destroySubcommand, 1)
-	//flaggy.AttachSubcommand(validateSubcommand, 1)
+	flaggy.AttachSubcommand(validateSubcommand, 1)
 	flaggy.AttachSubcommand(statusSubcommand, 1)
 	//flaggy.AttachSubcommand(sshSubcommand, 1)
 	//flaggy.AttachSubcommand(consoleSubcommand, 1)
+	flaggy.AttachSubcommand(vsockSubcommand, 1)
+	flaggy.AttachSubcommand(captureSubcommand, 1)
+	flaggy.AttachSubcommand(forwardSubcommand, 1)
+	flaggy.AttachSubcommand(dnsSubcommand, 1)
+	flaggy.AttachSubcommand(vpnkitSubcommand, 1)
+	flaggy.AttachSubcommand(shareSubcommand, 1)
+	flaggy.AttachSubcommand(initSubcommand, 1)
+	flaggy.AttachSubcommand(configSubcommand, 1)
+	flaggy.AttachSubcommand(hostsSubcommand, 1)
 
 	flaggy.SetVersion(Version)
 	flaggy.Parse()
@@ -92,6 +204,17 @@ func Run() error {
 		debug = true
 	}
 
+	if initSubcommand.Used {
+		path := "hkmgr.toml"
+		if len(cliConfigPaths) > 0 {
+			path = cliConfigPaths[0]
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				path = filepath.Join(path, "hkmgr.toml")
+			}
+		}
+		return initialize.Run(path, &initOpts, !initYes && initialize.IsTerminal(os.Stdin))
+	}
+
 	// Default to look for configs hkmgr.toml and hkmgr.d/*.toml
 	if len(cliConfigPaths) == 0 {
 		cliConfigPaths = []string{"hkmgr.toml", "hkmgr.d"}
@@ -135,6 +258,11 @@ func Run() error {
 		if _, err := toml.DecodeFile(cfgPath, &config); err != nil {
 			return err
 		}
+		absPath, err := filepath.Abs(cfgPath)
+		if err != nil {
+			return err
+		}
+		config.Files = append(config.Files, absPath)
 	}
 
 	absPath, err := filepath.Abs(firstCfgPath)
@@ -143,10 +271,11 @@ func Run() error {
 	}
 	config.Path = absPath
 
-	if err := config.Defaults(); err != nil {
+	// Probing and claiming tap devices is left to the commands using them.
+	config.AllocateTaps = upSubcommand.Used || validateSubcommand.Used
+	if err := config.Load(); err != nil {
 		return err
 	}
-	config.UpdateRelativePaths()
 
 	if debug {
 		fmt.Printf("Parsed config:\n\n%# v\n", pretty.Formatter(config))
@@ -158,7 +287,19 @@ func Run() error {
 			return err
 		}
 	case downSubcommand.Used:
-		if err := down.Run(&config, vmName, downSignal); err != nil {
+		if err := down.Run(&config, vmName, downSignal, downNetworks); err != nil {
+			return err
+		}
+	case validateSubcommand.Used:
+		if err := validate.Run(&config, vmName); err != nil {
+			return err
+		}
+	case configShowSubcommand.Used:
+		if err := show.Run(&config, vmName, configShowFormat); err != nil {
+			return err
+		}
+	case hostsSyncSubcommand.Used:
+		if err := hosts.Run(&config, hostsFile); err != nil {
 			return err
 		}
 	case destroySubcommand.Used:
@@ -173,6 +314,35 @@ func Run() error {
 		if err := ssh.Run(&config); err != nil {
 			return err
 		}
+	case vsockSubcommand.Used:
+		if err := vsock.Run(&config, vmName, vsockPort); err != nil {
+			return err
+		}
+	case captureSubcommand.Used:
+		var filter []string
+		if captureFilter != "" {
+			filter = append(filter, captureFilter)
+		}
+		filter = append(filter, flaggy.TrailingArguments...)
+		if err := capture.Run(&config, vmName, captureNIC, captureFile, filter); err != nil {
+			return err
+		}
+	case forwardSubcommand.Used:
+		if err := forward.Run(&config, vmName); err != nil {
+			return err
+		}
+	case dnsSubcommand.Used:
+		if err := dns.Run(&config); err != nil {
+			return err
+		}
+	case vpnkitSubcommand.Used:
+		if err := vpnkit.Run(&config, vpnkitNetwork); err != nil {
+			return err
+		}
+	case shareSubcommand.Used:
+		if err := share.Run(&config, vmName); err != nil {
+			return err
+		}
 	case consoleSubcommand.Used:
 		if err := console.Run(&config); err != nil {
 			return err
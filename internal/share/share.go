@@ -0,0 +1,110 @@
+package share
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bensallen/hkmgr/internal/config"
+	"github.com/bensallen/hkmgr/internal/daemon"
+)
+
+// pollInterval is how often the VM is checked to still be running.
+var pollInterval = 5 * time.Second
+
+// Run serves the shared directories of VM "name" until the VM stops or a
+// SIGTERM or SIGINT is received. It's run in the background by Start before
+// the VM boots, as hyperkit connects to the shares when it starts.
+func Run(cfg *config.Config, name string) error {
+	vm, ok := cfg.VM[name]
+	if !ok {
+		return fmt.Errorf("%s not found in the configuration", name)
+	}
+
+	shares := vm.Shares()
+	closeAll := func() {
+		for _, s := range shares {
+			s.Close()
+		}
+	}
+	for _, s := range shares {
+		if err := s.Listen(); err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		running := false
+		for {
+			select {
+			case <-sig:
+				closeAll()
+				return
+			case <-ticker.C:
+				// The VM isn't running yet when the shares are first served.
+				if vm.Status() == config.Running {
+					running = true
+				} else if running {
+					closeAll()
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, s := range shares {
+		wg.Add(1)
+		go func(s func()) {
+			defer wg.Done()
+			s()
+		}(s.Serve)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Start runs "hkmgr share <name>" in the background to serve the shares of a
+// VM, replacing any previous share process of the VM, and waits for their
+// sockets. It runs as the user who ran hkmgr with sudo, so the guest can't
+// change the shared directories as root. Output is logged to share.log in the
+// run dir.
+func Start(cfg *config.Config, name string, vm *config.VMConfig) error {
+	if err := vm.StopShares(); err != nil {
+		return err
+	}
+	shares := vm.Shares()
+	for _, s := range shares {
+		if err := os.Remove(s.Socket); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// The sockets are created in the run dir as the user.
+	if err := vm.ChownRunDir(); err != nil {
+		return err
+	}
+
+	return daemon.Start(cfg, daemon.Daemon{
+		Args:       []string{"share", name},
+		PidFile:    vm.SharePidFile(),
+		LogFile:    filepath.Join(vm.RunDir, "share.log"),
+		Credential: config.SudoCredential(),
+		Ready: func() bool {
+			for _, s := range shares {
+				if _, err := os.Stat(s.Socket); err != nil {
+					return false
+				}
+			}
+			return true
+		},
+	})
+}
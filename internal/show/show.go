@@ -0,0 +1,40 @@
+package show
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+// Run prints the resolved configuration of all VMs or the specific VMs passed
+// as "name" in the given format, toml or json. The output includes the
+// networks, the host, dns, and hosts tables, and values derived when loading the configuration such as
+// generated UUIDs and MACs, and absolute paths.
+func Run(cfg *config.Config, name string, format string) error {
+	return write(os.Stdout, cfg, name, format)
+}
+
+func write(w io.Writer, cfg *config.Config, name string, format string) error {
+	out := config.Config{Host: cfg.Host, Network: cfg.Network, DNS: cfg.DNS, Hosts: cfg.Hosts, VM: cfg.VM}
+	if name != "" {
+		var err error
+		if out.VM, err = cfg.VM.Select(name); err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case "toml", "":
+		return toml.NewEncoder(w).Encode(out)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	default:
+		return fmt.Errorf("format %s not supported: toml and json are supported", format)
+	}
+}
@@ -0,0 +1,126 @@
+package show
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Host: &config.Host{
+			Routes: []*config.Route{{Dest: "10.10.0.0/16", Network: "net1"}},
+			Sysctl: map[string]config.SysctlValue{"net.inet.ip.forwarding": "1"},
+		},
+		DNS:   &config.DNS{Domain: "vms.test", Upstream: []string{"1.1.1.1"}},
+		Hosts: &config.Hosts{Auto: true},
+		Network: config.Network{
+			"net1": {Tap: &config.Tap{Bridge: "bridge1", IP: config.IPList{"192.168.99.1/24", "fd00:99::1/64"}}},
+		},
+		VM: config.VM{
+			"vm1": {
+				Memory: 4 * config.GiB,
+				Cores:  2,
+				UUID:   "9445CA7C-F976-456E-9061-B932194D8166",
+				RunDir: "/vms/.run/vm/vm1",
+				Network: []*config.NetConf{
+					{Driver: "virtio-tap", Device: "tap0", MAC: "aa:bb:cc:dd:00:01", MemberOf: "net1"},
+				},
+				Boot: config.Boot{Kexec: &config.Kexec{Kernel: "/vms/vmlinuz", Initrd: "/vms/initrd", Cmdline: "console=ttyS0"}},
+				PID:  1234,
+			},
+			"vm2": {
+				Memory: config.GiB,
+				Cores:  1,
+				UUID:   "09D30738-887C-48CA-8A80-687CEEB4CADB",
+				RunDir: "/vms/.run/vm/vm2",
+				Boot:   config.Boot{Firmware: &config.Firmware{Path: "/vms/UEFI.fd"}},
+			},
+		},
+	}
+}
+
+func Test_writeTOML(t *testing.T) {
+	cfg := testConfig()
+
+	var buf bytes.Buffer
+	if err := write(&buf, cfg, "", "toml"); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	out := buf.String()
+
+	for _, unwanted := range []string{"PID", "BridgeDev", "Path", "vm1.boot.firmware", "count"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("write() output contains %q:\n%s", unwanted, out)
+		}
+	}
+
+	var got config.Config
+	if _, err := toml.Decode(out, &got); err != nil {
+		t.Fatalf("toml.Decode() error = %v\n%s", err, out)
+	}
+	cfg.VM["vm1"].PID = 0
+	if !reflect.DeepEqual(got.VM, cfg.VM) {
+		t.Errorf("write() round trip = %#v, want %#v", got.VM, cfg.VM)
+	}
+	if !reflect.DeepEqual(got.Network, cfg.Network) {
+		t.Errorf("write() round trip = %#v, want %#v", got.Network, cfg.Network)
+	}
+	if !reflect.DeepEqual(got.Host, cfg.Host) {
+		t.Errorf("write() round trip = %#v, want %#v", got.Host, cfg.Host)
+	}
+	if !reflect.DeepEqual(got.DNS, cfg.DNS) {
+		t.Errorf("write() round trip = %#v, want %#v", got.DNS, cfg.DNS)
+	}
+	if !reflect.DeepEqual(got.Hosts, cfg.Hosts) {
+		t.Errorf("write() round trip = %#v, want %#v", got.Hosts, cfg.Hosts)
+	}
+}
+
+func Test_writeJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := write(&buf, testConfig(), "vm2", "json"); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	var got struct {
+		VM      map[string]map[string]interface{} `json:"vm"`
+		Network map[string]interface{}            `json:"network"`
+		Host    *config.Host                      `json:"host"`
+		DNS     *config.DNS                       `json:"dns"`
+		Hosts   *config.Hosts                     `json:"hosts"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v\n%s", err, buf.String())
+	}
+	if _, ok := got.VM["vm1"]; ok {
+		t.Errorf("write() output contains vm1, only vm2 was selected")
+	}
+	if got.VM["vm2"]["memory"] != "1GiB" {
+		t.Errorf("write() memory = %v, want 1GiB", got.VM["vm2"]["memory"])
+	}
+	if _, ok := got.Network["net1"]; !ok {
+		t.Errorf("write() output is missing network net1")
+	}
+	want := testConfig()
+	if !reflect.DeepEqual(got.Host, want.Host) {
+		t.Errorf("write() host = %#v, want %#v", got.Host, want.Host)
+	}
+	if !reflect.DeepEqual(got.DNS, want.DNS) {
+		t.Errorf("write() dns = %#v, want %#v", got.DNS, want.DNS)
+	}
+	if !reflect.DeepEqual(got.Hosts, want.Hosts) {
+		t.Errorf("write() hosts = %#v, want %#v", got.Hosts, want.Hosts)
+	}
+}
+
+func Test_writeFormat(t *testing.T) {
+	if err := write(&bytes.Buffer{}, testConfig(), "", "yaml"); err == nil {
+		t.Errorf("write() expected an error for an unsupported format")
+	}
+}
@@ -3,28 +3,37 @@ package status
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/bensallen/hkmgr/internal/config"
 )
 
 //Current prints the current status of the VM(s).
 func Current(cfg *config.Config, name string, debug bool) error {
+	vms := cfg.VM
 	if name != "" {
-		if vm, ok := cfg.VM[name]; ok {
-			fmt.Printf("%s status is %s, PID: %d\n", name, vm.Status(), vm.PID)
-		} else {
-			return fmt.Errorf("%s not found in the configuration", name)
+		var err error
+		if vms, err = cfg.VM.Select(name); err != nil {
+			return err
 		}
-	} else {
-		names := make([]string, 0, len(cfg.VM))
-		for name := range cfg.VM {
-			names = append(names, name)
+	}
+
+	names := make([]string, 0, len(vms))
+	for name := range vms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		vm := vms[n]
+		status := vm.Status()
+		if status == config.Running {
+			vm.DiscoverIPs()
 		}
-		sort.Strings(names)
-		for _, n := range names {
-			vm := cfg.VM[n]
-			fmt.Printf("%s status is %s, PID: %d\n", n, vm.Status(), vm.PID)
+		fmt.Printf("%s status is %s, PID: %d, memory: %s", n, status, vm.PID, vm.Memory)
+		if ips := vm.IPs(); len(ips) > 0 {
+			fmt.Printf(", IP: %s", strings.Join(ips, ", "))
 		}
+		fmt.Printf("\n")
 	}
 	return nil
 }
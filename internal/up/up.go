@@ -3,8 +3,14 @@ package up
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/bensallen/hkmgr/internal/config"
+	"github.com/bensallen/hkmgr/internal/dns"
+	"github.com/bensallen/hkmgr/internal/forward"
+	"github.com/bensallen/hkmgr/internal/network"
+	"github.com/bensallen/hkmgr/internal/share"
+	"github.com/bensallen/hkmgr/internal/vpnkit"
 )
 
 //Run ...
@@ -16,42 +22,137 @@ func Run(cfg *config.Config, vmName string, debug bool, dryRun bool) error {
 		}
 	}
 
-	if vmName != "" {
-		if vm, ok := cfg.VM[vmName]; ok {
-			if err := vm.Validate(); err != nil {
-				return fmt.Errorf("validating the configuration of %s, %v", vmName, err)
-			}
-			if err := upVM(vm, cfg.Network, dryRun); err != nil {
-				return fmt.Errorf("bringing vm: %s up, %v", vm.UUID, err)
+	host, err := cfg.HostConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.Filters(); err != nil {
+		return err
+	}
+
+	if _, err := cfg.DNSServer(); err != nil {
+		return err
+	}
+
+	// hyperkit connects to the vpnkit ethernet socket and to the vmnet shared
+	// network when it starts, so these networks are brought up before the VMs.
+	// vpnkit is kept running by a supervisor in the background.
+	for name, netTypes := range cfg.Network {
+		if netTypes.VPNKit != nil || netTypes.Vmnet != nil {
+			fmt.Printf("Configuring Network: %#v\n", name)
+			if !dryRun {
+				var err error
+				if netTypes.VPNKit != nil {
+					err = vpnkit.Start(cfg, name)
+				} else {
+					err = netTypes.NetType().Up()
+				}
+				if err != nil {
+					return err
+				}
+			} else if netTypes.Vmnet != nil {
+				diff, err := netTypes.Vmnet.VmnetDev.Diff()
+				if err != nil {
+					return err
+				}
+				if len(diff) > 0 {
+					fmt.Printf("would update the vmnet plist:\n%s\n", strings.Join(diff, "\n"))
+				}
 			}
-		} else {
-			return fmt.Errorf("%s not found in the configuration", vmName)
 		}
-	} else {
-		for name, vm := range cfg.VM {
-			if err := vm.Validate(); err != nil {
-				return fmt.Errorf("validation failed for the configuration of %s, %v", name, err)
-			}
-			if err := upVM(vm, cfg.Network, dryRun); err != nil {
-				fmt.Printf("bringing vm: %s up, %v\n", vm.UUID, err)
+	}
+
+	vms := cfg.VM
+	if vmName != "" {
+		var err error
+		if vms, err = cfg.VM.Select(vmName); err != nil {
+			return err
+		}
+	}
+
+	for name, vm := range vms {
+		if err := vm.Validate(); err != nil {
+			return fmt.Errorf("validation failed for the configuration of %s, %v", name, err)
+		}
+		if err := upVM(cfg, name, vm, dryRun); err != nil {
+			if vmName != "" {
+				return fmt.Errorf("bringing vm: %s up, %v", vm.UUID, err)
 			}
+			fmt.Printf("bringing vm: %s up, %v\n", vm.UUID, err)
 		}
 	}
 
 	for name, netTypes := range cfg.Network {
+		if netTypes.VPNKit != nil || netTypes.Vmnet != nil {
+			continue
+		}
 		fmt.Printf("Configuring Network: %#v\n", name)
 		if !dryRun {
 			net := netTypes.NetType()
 			if err := net.Up(); err != nil {
+				if membersErr, ok := err.(*network.MembersError); ok {
+					for _, r := range membersErr.Results {
+						if r.Err != nil {
+							fmt.Printf("member %s of network %s, %v\n", r.Member, name, r.Err)
+						}
+					}
+				}
 				return err
 			}
 		}
 	}
 
+	// Tap interfaces exist once their VMs are up, and are shaped after being
+	// added to their networks.
+	for _, vm := range vms {
+		shapings, err := vm.Shapings()
+		if err != nil {
+			return err
+		}
+		for _, s := range shapings {
+			fmt.Printf("Shaping interface %s of VM: %s\n", s.Interface, vm.UUID)
+			if !dryRun {
+				if err := s.Up(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// The DNS server listens on the addresses of the bridges, so it's started
+	// once they're up.
+	if cfg.DNS != nil {
+		fmt.Printf("Starting DNS server for %s\n", cfg.Domain())
+		if !dryRun {
+			if err := dns.Start(cfg); err != nil {
+				return fmt.Errorf("starting dns server, %v", err)
+			}
+		}
+	}
+
+	if cfg.Hosts != nil && cfg.Hosts.Auto {
+		fmt.Printf("Updating hosts file\n")
+		if !dryRun {
+			if err := cfg.HostsFile(nil).Sync(); err != nil {
+				return fmt.Errorf("updating hosts file, %v", err)
+			}
+		}
+	}
+
+	// Routes may go via the bridges of networks, so the host is configured
+	// once they're up.
+	fmt.Printf("Configuring Host\n")
+	if !dryRun {
+		if err := host.Apply(); err != nil {
+			return fmt.Errorf("configuring host, %v", err)
+		}
+	}
+
 	return nil
 }
 
-func upVM(vm *config.VMConfig, netCfg config.Network, dryRun bool) error {
+func upVM(cfg *config.Config, name string, vm *config.VMConfig, dryRun bool) error {
 	fmt.Printf("Booting VM: %s\n", vm.UUID)
 
 	if err := os.MkdirAll(vm.RunDir, os.ModePerm); err != nil {
@@ -59,13 +160,29 @@ func upVM(vm *config.VMConfig, netCfg config.Network, dryRun bool) error {
 	}
 
 	if !dryRun {
+		// hyperkit connects to the 9P servers of the shares when it starts.
+		if len(vm.Share) > 0 {
+			fmt.Printf("Sharing directories with VM: %s\n", vm.UUID)
+			if err := share.Start(cfg, name, vm); err != nil {
+				return err
+			}
+		}
+
 		if err := vm.Up(); err != nil {
+			vm.StopShares()
 			return err
 		}
 
+		if len(vm.Forward) > 0 {
+			fmt.Printf("Forwarding ports for VM: %s\n", vm.UUID)
+			if err := forward.Start(cfg, name, vm); err != nil {
+				return err
+			}
+		}
+
 		for _, vmnet := range vm.Network {
 			if vmnet.Device != "" {
-				if net, ok := netCfg[vmnet.MemberOf]; ok {
+				if net, ok := cfg.Network[vmnet.MemberOf]; ok {
 					if tap := net.Tap; tap != nil {
 						if tap.BridgeDev != nil {
 							fmt.Printf("adding member %s to network %s for vm %s\n", vmnet.Device, vmnet.MemberOf, vm.UUID)
@@ -77,6 +194,12 @@ func upVM(vm *config.VMConfig, netCfg config.Network, dryRun bool) error {
 				}
 			}
 		}
+
+		// The pid file and the files of port forwards are written by hkmgr
+		// as root.
+		if err := vm.ChownRunDir(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
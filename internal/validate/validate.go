@@ -2,15 +2,54 @@ package validate
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/bensallen/hkmgr/internal/config"
-	"github.com/kr/pretty"
 )
 
-func Run(cfg *config.Config) error {
+// Run validates the configuration of the networks and of all VMs or the
+// specific VMs passed as "name".
+func Run(cfg *config.Config, name string) error {
+	for netName, netTypes := range cfg.Network {
+		net := netTypes.NetType()
+		if net == nil {
+			return fmt.Errorf("network %s has no type: vmnet, tap, and vpnkit are supported", netName)
+		}
+		if err := net.Discover(); err != nil {
+			return fmt.Errorf("validation failed for network %s, %v", netName, err)
+		}
+	}
 
-	fmt.Printf("%# v\n", pretty.Formatter(cfg))
+	if _, err := cfg.HostConfig(); err != nil {
+		return fmt.Errorf("validation failed for host, %v", err)
+	}
 
-	return nil
+	if err := cfg.Filters(); err != nil {
+		return fmt.Errorf("validation failed for filters, %v", err)
+	}
+
+	if _, err := cfg.DNSServer(); err != nil {
+		return fmt.Errorf("validation failed for dns, %v", err)
+	}
 
+	vms := cfg.VM
+	if name != "" {
+		var err error
+		if vms, err = cfg.VM.Select(name); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(vms))
+	for n := range vms {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := vms[n].Validate(); err != nil {
+			return fmt.Errorf("validation failed for the configuration of %s, %v", n, err)
+		}
+		fmt.Printf("%s configuration is valid\n", n)
+	}
+	return nil
 }
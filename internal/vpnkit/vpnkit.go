@@ -0,0 +1,79 @@
+package vpnkit
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bensallen/hkmgr/internal/config"
+	"github.com/bensallen/hkmgr/internal/daemon"
+)
+
+// lookup returns the vpnkit network "name" of the configuration.
+func lookup(cfg *config.Config, name string) (*config.VPNKit, error) {
+	nt, ok := cfg.Network[name]
+	if !ok || nt.VPNKit == nil {
+		return nil, fmt.Errorf("vpnkit network %s not found in the configuration", name)
+	}
+	if err := nt.VPNKit.Discover(); err != nil {
+		return nil, err
+	}
+	return nt.VPNKit, nil
+}
+
+// Run keeps the vpnkit of network "name" running, starting it again when it
+// exits, until a SIGTERM or SIGINT is received. It's run in the background by
+// Start.
+func Run(cfg *config.Config, name string) error {
+	v, err := lookup(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+
+	v.VPNKitDev.Supervise(stop)
+	return nil
+}
+
+// Start runs "hkmgr vpnkit <name>" in the background to keep the vpnkit of
+// network "name" running, replacing any previous supervisor of it, waits for
+// vpnkit to be running, and exposes the port forwards. A vpnkit that's
+// already running is kept. The supervisor, and so vpnkit, runs as the user who
+// ran hkmgr with sudo. Output is logged to supervisor.log in the run dir.
+func Start(cfg *config.Config, name string) error {
+	v, err := lookup(cfg, name)
+	if err != nil {
+		return err
+	}
+	if err := v.StopSupervisor(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(v.RunDir, os.ModePerm); err != nil {
+		return err
+	}
+	// vpnkit creates its sockets in the run dir as the user.
+	if err := v.ChownRunDir(); err != nil {
+		return err
+	}
+
+	err = daemon.Start(cfg, daemon.Daemon{
+		Args:       []string{"vpnkit", name},
+		PidFile:    v.SupervisorPidFile(),
+		LogFile:    filepath.Join(v.RunDir, "supervisor.log"),
+		Credential: config.SudoCredential(),
+		Ready:      v.VPNKitDev.Running,
+	})
+	if err != nil {
+		return err
+	}
+	return v.Up()
+}
@@ -0,0 +1,52 @@
+package vsock
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+// Run connects stdin and stdout to port in the guest of VM "name" through its
+// virtio-sock device.
+func Run(cfg *config.Config, name string, port string) error {
+	vm, ok := cfg.VM[name]
+	if !ok {
+		return fmt.Errorf("%s not found in the configuration", name)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", port)
+	}
+
+	if vm.Status() != config.Running {
+		return fmt.Errorf("%s is not running", name)
+	}
+
+	conn, err := vm.DialVsock(uint32(p))
+	if err != nil {
+		return fmt.Errorf("connecting to %s port %d, %v", name, p, err)
+	}
+	defer conn.Close()
+
+	return proxy(conn, os.Stdin, os.Stdout)
+}
+
+// proxy copies in to conn and conn to out until conn is closed by the guest.
+// When in reaches EOF the write side of conn is closed, so the guest sees
+// EOF while the remaining output is still copied.
+func proxy(conn net.Conn, in io.Reader, out io.Writer) error {
+	go func() {
+		io.Copy(conn, in)
+		if c, ok := conn.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	_, err := io.Copy(out, conn)
+	return err
+}
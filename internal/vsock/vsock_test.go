@@ -0,0 +1,65 @@
+package vsock
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bensallen/hkmgr/internal/config"
+)
+
+// Test_proxy runs an echo server in place of hyperkit's connect socket.
+func Test_proxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hkmgr-vsock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vsock := true
+	vm := &config.VMConfig{RunDir: dir, Vsock: &vsock}
+	if err := os.Mkdir(vm.VsockDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(vm.VsockDir(), "connect"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	header := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		line, _ := r.ReadString('\n')
+		header <- line
+		data, _ := ioutil.ReadAll(r)
+		conn.Write(bytes.ToUpper(data))
+	}()
+
+	conn, err := vm.DialVsock(1024)
+	if err != nil {
+		t.Fatalf("VMConfig.DialVsock() error = %v", err)
+	}
+	defer conn.Close()
+
+	var out bytes.Buffer
+	if err := proxy(conn, strings.NewReader("hello"), &out); err != nil {
+		t.Fatalf("proxy() error = %v", err)
+	}
+	if got := <-header; got != "00000003.00000400\n" {
+		t.Errorf("DialVsock() header = %q, want %q", got, "00000003.00000400\n")
+	}
+	if out.String() != "HELLO" {
+		t.Errorf("proxy() output = %q, want HELLO", out.String())
+	}
+}